@@ -5,25 +5,37 @@ set -u
 set -o pipefail
 
 function main() {
-  if [[ "${CF_STACK:-}" != "cflinuxfs3" && "${CF_STACK:-}" != "cflinuxfs4" ]]; then
-    echo "       **ERROR** Unsupported stack"
-    echo "                 See https://docs.cloudfoundry.org/devguide/deploy-apps/stacks.html for more info"
-    exit 1
-  fi
-
-  local version expected_sha dir
+  local version arch expected_sha dir url strip
   version="1.22.5"
-  expected_sha="ddb12ede43eef214c7d4376761bd5ba6297d5fa7a06d5635ea3e7a276b3db730"
   dir="/tmp/go${version}"
 
+  # The stack itself is checked by supply and finalize against the stacks of
+  # manifest.yml. The cflinuxfs3 build of Go is used on every stack: it links
+  # against the oldest glibc of the supported stacks, which newer stacks such
+  # as cflinuxfs4 stay compatible with.
+  arch="$(uname -m)"
+  case "${arch}" in
+    x86_64|amd64)
+      expected_sha="ddb12ede43eef214c7d4376761bd5ba6297d5fa7a06d5635ea3e7a276b3db730"
+      url="https://buildpacks.cloudfoundry.org/dependencies/go/go_${version}_linux_x64_cflinuxfs3_${expected_sha:0:8}.tgz"
+      strip=0
+      ;;
+    aarch64|arm64)
+      # The buildpacks bucket has no arm64 build of Go, the upstream release
+      # nests everything below go/
+      expected_sha="8d21325bfcf431be3660527c1a39d3d9ad71535fabdf5041c826e44e31642b5a"
+      url="https://go.dev/dl/go${version}.linux-arm64.tar.gz"
+      strip=1
+      ;;
+    *)
+      echo "       **ERROR** Unsupported architecture ${arch}"
+      exit 1
+      ;;
+  esac
+
   mkdir -p "${dir}"
 
   if [[ ! -f "${dir}/bin/go" ]]; then
-    local url
-    # TODO: use exact stack based dep, after go buildpack has cflinuxfs4 support
-    #url="https://buildpacks.cloudfoundry.org/dependencies/go/go_${version}_linux_x64_${CF_STACK}_${expected_sha:0:8}.tgz"
-    url="https://buildpacks.cloudfoundry.org/dependencies/go/go_${version}_linux_x64_cflinuxfs3_${expected_sha:0:8}.tgz"
-
     echo "-----> Download go ${version}"
     curl "${url}" \
       --silent \
@@ -40,7 +52,7 @@ function main() {
       exit 1
     fi
 
-    tar xzf "/tmp/go.tgz" -C "${dir}"
+    tar xzf "/tmp/go.tgz" -C "${dir}" --strip-components="${strip}"
     rm "/tmp/go.tgz"
   fi
 
@@ -0,0 +1,124 @@
+package config_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/config"
+	"github.com/cloudfoundry/libbuildpack"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Staging on an arm64 cell", func() {
+	var (
+		err          error
+		buildpackDir string
+		depsDir      string
+		outputDir    string
+		cfg          *config.Config
+		installer    *config.Installer
+	)
+
+	// writeArtifact writes a tarball holding a single file into the
+	// buildpack, the way a cached buildpack carries its dependencies
+	writeArtifact := func(name, file string) string {
+		buffer := &bytes.Buffer{}
+		gz := gzip.NewWriter(buffer)
+		tw := tar.NewWriter(gz)
+		Expect(tw.WriteHeader(&tar.Header{Name: file, Mode: 0755, Size: 2})).To(Succeed())
+		_, err := tw.Write([]byte("ok"))
+		Expect(err).To(BeNil())
+		Expect(tw.Close()).To(Succeed())
+		Expect(gz.Close()).To(Succeed())
+
+		Expect(os.WriteFile(filepath.Join(buildpackDir, name), buffer.Bytes(), 0644)).To(Succeed())
+		sum := sha256.Sum256(buffer.Bytes())
+		return hex.EncodeToString(sum[:])
+	}
+
+	BeforeEach(func() {
+		buildpackDir, err = os.MkdirTemp("", "dotnet-core-buildpack.buildpack.")
+		Expect(err).To(BeNil())
+		depsDir, err = os.MkdirTemp("", "dotnet-core-buildpack.deps.")
+		Expect(err).To(BeNil())
+		outputDir, err = os.MkdirTemp("", "dotnet-core-buildpack.output.")
+		Expect(err).To(BeNil())
+
+		// The manifest.yml the buildpack ships
+		manifestYAML, err := os.ReadFile(filepath.Join("..", "..", "..", "manifest.yml"))
+		Expect(err).To(BeNil())
+		Expect(os.WriteFile(filepath.Join(buildpackDir, "manifest.yml"), manifestYAML, 0644)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(buildpackDir, "VERSION"), []byte("99.99.99"), 0644)).To(Succeed())
+
+		sdkSHA := writeArtifact("dotnet-sdk_8.0.401_linux_arm64.tgz", "dotnet")
+		Expect(os.MkdirAll(filepath.Join(depsDir, "0"), 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(depsDir, "0", "override.yml"), []byte(fmt.Sprintf(`---
+dotnet-core:
+  dependencies:
+  - name: dotnet-sdk
+    version: 8.0.401
+    arch: arm64
+    uri: https://mirror.example.com/dotnet-sdk_8.0.401_linux_arm64.tgz
+    file: dotnet-sdk_8.0.401_linux_arm64.tgz
+    sha256: %s
+    cf_stacks: [cflinuxfs4]
+`, sdkSHA)), 0644)).To(Succeed())
+
+		Expect(os.Setenv("CF_STACK", "cflinuxfs4")).To(Succeed())
+
+		logger := libbuildpack.NewLogger(&bytes.Buffer{})
+		manifest, err := libbuildpack.NewManifest(buildpackDir, logger, time.Now())
+		Expect(err).To(BeNil())
+
+		extensions, err := config.LoadManifestExtensions(buildpackDir, depsDir)
+		Expect(err).To(BeNil())
+		extensions.SelectArchitecture(manifest, "arm64")
+
+		cfg = &config.Config{}
+		installer = config.NewInstaller(libbuildpack.NewInstaller(manifest), manifest, cfg, "arm64")
+	})
+
+	AfterEach(func() {
+		Expect(os.Unsetenv("CF_STACK")).To(Succeed())
+		Expect(os.RemoveAll(buildpackDir)).To(Succeed())
+		Expect(os.RemoveAll(depsDir)).To(Succeed())
+		Expect(os.RemoveAll(outputDir)).To(Succeed())
+	})
+
+	It("installs the arm64 build of a dependency", func() {
+		Expect(installer.InstallDependency(libbuildpack.Dependency{Name: "dotnet-sdk", Version: "8.0.401"}, outputDir)).To(Succeed())
+		Expect(filepath.Join(outputDir, "dotnet")).To(BeARegularFile())
+		Expect(cfg.InstalledDependencies).To(Equal([]config.Dependency{{Name: "dotnet-sdk", Version: "8.0.401"}}))
+	})
+
+	It("keeps dependencies that run on every architecture", func() {
+		Expect(installerVersions(buildpackDir, depsDir, "bower")).To(Equal([]string{"1.8.14"}))
+	})
+
+	It("fails clearly for a dependency the manifest only has x64 builds of", func() {
+		err := installer.InstallDependency(libbuildpack.Dependency{Name: "dotnet-runtime", Version: "8.0.8"}, outputDir)
+		Expect(err).To(MatchError("the buildpack has no linux-arm64 build of dotnet-runtime for cflinuxfs4"))
+
+		err = installer.InstallOnlyVersion("libunwind", outputDir)
+		Expect(err).To(MatchError("the buildpack has no linux-arm64 build of libunwind for cflinuxfs4"))
+	})
+})
+
+// installerVersions lists the versions of a dependency the manifest offers
+// on arm64
+func installerVersions(buildpackDir, depsDir, name string) []string {
+	manifest, err := libbuildpack.NewManifest(buildpackDir, libbuildpack.NewLogger(&bytes.Buffer{}), time.Now())
+	Expect(err).To(BeNil())
+	extensions, err := config.LoadManifestExtensions(buildpackDir, depsDir)
+	Expect(err).To(BeNil())
+	extensions.SelectArchitecture(manifest, "arm64")
+	return manifest.AllDependencyVersions(name)
+}
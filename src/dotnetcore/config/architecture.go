@@ -0,0 +1,30 @@
+package config
+
+import (
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// Architecture returns the CPU architecture of the cell the way it appears in
+// .NET runtime identifiers, x64 or arm64. It asks uname since the buildpack
+// binaries may have been built for another architecture than the one they run
+// on, and falls back to the architecture they were built for.
+func Architecture() string {
+	if output, err := exec.Command("uname", "-m").Output(); err == nil {
+		return normalizeArchitecture(strings.TrimSpace(string(output)))
+	}
+	return normalizeArchitecture(runtime.GOARCH)
+}
+
+// normalizeArchitecture maps the names used by uname, Go and manifests to the
+// ones used in runtime identifiers. Manifest entries without an arch are x64.
+func normalizeArchitecture(arch string) string {
+	switch strings.ToLower(arch) {
+	case "", "x64", "amd64", "x86_64":
+		return "x64"
+	case "arm64", "aarch64":
+		return "arm64"
+	}
+	return strings.ToLower(arch)
+}
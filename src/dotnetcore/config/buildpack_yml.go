@@ -0,0 +1,301 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// BuildpackYAML is the dotnet-core section of an app's buildpack.yml
+type BuildpackYAML struct {
+	SDK                string             `yaml:"sdk"`
+	Runtime            string             `yaml:"runtime"`
+	AspNetCore         string             `yaml:"aspnetcore"`
+	Configuration      string             `yaml:"configuration"`
+	Project            string             `yaml:"project"`
+	TargetFramework    string             `yaml:"target-framework"`
+	Processes          map[string]Process `yaml:"processes"`
+	PublishProperties  map[string]string  `yaml:"publish-properties"`
+	PublishFlags       string             `yaml:"publish-flags"`
+	ReadyToRun         *bool              `yaml:"ready-to-run"`
+	Trimmed            *bool              `yaml:"trimmed"`
+	TrimMode           string             `yaml:"trim-mode"`
+	SingleFile         *bool              `yaml:"single-file"`
+	KeepNode           *bool              `yaml:"keep-node"`
+	RunTests           *bool              `yaml:"run-tests"`
+	FrameworkDependent *bool              `yaml:"framework-dependent"`
+	AllowEOL           *bool              `yaml:"allow-eol"`
+	Lock               *bool              `yaml:"lock"`
+	PublishedDir       string             `yaml:"published-dir"`
+}
+
+// Process is a process type that is published from its own project, runs a
+// command of its own, or both
+type Process struct {
+	Project string `yaml:"project"`
+	Command string `yaml:"command"`
+}
+
+type buildpackYAMLFile struct {
+	DotnetCore BuildpackYAML `yaml:"dotnet-core"`
+	// Other buildpacks may share the same buildpack.yml
+	Others map[string]interface{} `yaml:",inline"`
+}
+
+var (
+	unknownKeyRe       = regexp.MustCompile(`^line (\d+): field (.+) not found in type (.+)$`)
+	configurationRe    = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+	msbuildPropertyRe  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+	projectExtensionRe = regexp.MustCompile(`\.(cs|fs|vb)proj$`)
+	targetFrameworkRe  = regexp.MustCompile(`^[A-Za-z0-9.-]+$`)
+	processNameRe      = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+var validTrimModes = map[string]bool{"full": true, "partial": true}
+
+// LoadBuildpackYAML reads and validates buildpack.yml from the root of the
+// build dir. A missing file yields an empty BuildpackYAML. The CLIs read it
+// once and hand it to the Supplier, Finalizer and Project.
+func LoadBuildpackYAML(buildDir string) (BuildpackYAML, error) {
+	content, err := os.ReadFile(filepath.Join(buildDir, "buildpack.yml"))
+	if os.IsNotExist(err) {
+		return BuildpackYAML{}, nil
+	} else if err != nil {
+		return BuildpackYAML{}, err
+	}
+
+	obj := buildpackYAMLFile{}
+	if err := yaml.UnmarshalStrict(content, &obj); err != nil {
+		if typeErr, ok := err.(*yaml.TypeError); ok {
+			return BuildpackYAML{}, fmt.Errorf("invalid buildpack.yml:\n  %s", strings.Join(describeTypeErrors(typeErr), "\n  "))
+		}
+		return BuildpackYAML{}, fmt.Errorf("invalid buildpack.yml: %s", err)
+	}
+
+	if err := obj.DotnetCore.validate(); err != nil {
+		return BuildpackYAML{}, fmt.Errorf("invalid buildpack.yml: %s", err)
+	}
+
+	return obj.DotnetCore, nil
+}
+
+func describeTypeErrors(typeErr *yaml.TypeError) []string {
+	var messages []string
+	for _, msg := range typeErr.Errors {
+		if matches := unknownKeyRe.FindStringSubmatch(msg); matches != nil {
+			msg = fmt.Sprintf("line %s: unknown key %q", matches[1], matches[2])
+			if t := reflect.TypeOf(BuildpackYAML{}); matches[3] == t.String() {
+				msg += fmt.Sprintf(" in dotnet-core, supported keys are: %s", strings.Join(supportedKeys(t), ", "))
+			}
+		}
+		messages = append(messages, msg)
+	}
+	return messages
+}
+
+func supportedKeys(t reflect.Type) []string {
+	var keys []string
+	for i := 0; i < t.NumField(); i++ {
+		keys = append(keys, strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0])
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (b BuildpackYAML) validate() error {
+	if b.Configuration != "" && !configurationRe.MatchString(b.Configuration) {
+		return fmt.Errorf("configuration %q may only contain letters, digits, '.', '-' and '_'", b.Configuration)
+	}
+
+	if b.Project != "" {
+		if err := validateProject(b.Project); err != nil {
+			return fmt.Errorf("project %s", err)
+		}
+	}
+
+	var processNames []string
+	for name := range b.Processes {
+		processNames = append(processNames, name)
+	}
+	sort.Strings(processNames)
+	for _, name := range processNames {
+		if !ValidProcessName(name) {
+			return fmt.Errorf("processes: %q may only contain letters, digits, '-' and '_'", name)
+		}
+		process := b.Processes[name]
+		if process.Project == "" && strings.TrimSpace(process.Command) == "" {
+			return fmt.Errorf("processes: %s names neither a project nor a command", name)
+		}
+		if process.Project != "" {
+			if err := validateProject(process.Project); err != nil {
+				return fmt.Errorf("processes: %s project %s", name, err)
+			}
+		}
+	}
+
+	if b.PublishedDir != "" && !isRelativePath(b.PublishedDir) {
+		return fmt.Errorf("published-dir %q must be a path relative to the application root", b.PublishedDir)
+	}
+
+	if b.TargetFramework != "" && !targetFrameworkRe.MatchString(b.TargetFramework) {
+		return fmt.Errorf("target-framework %q is not a valid target framework moniker", b.TargetFramework)
+	}
+
+	if _, err := splitShellWords(b.PublishFlags); err != nil {
+		return fmt.Errorf("publish-flags: %s", err)
+	}
+
+	if b.TrimMode != "" && !validTrimModes[b.TrimMode] {
+		return fmt.Errorf("trim-mode %q must be full or partial", b.TrimMode)
+	}
+
+	var names []string
+	for name := range b.PublishProperties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !msbuildPropertyRe.MatchString(name) {
+			return fmt.Errorf("publish-properties: %q is not a valid MSBuild property name", name)
+		}
+	}
+
+	return nil
+}
+
+// ValidProcessName reports whether name may be used as a process type, which
+// also names the directory the process is published into
+func ValidProcessName(name string) bool {
+	return processNameRe.MatchString(name)
+}
+
+func validateProject(project string) error {
+	if !isRelativePath(project) {
+		return fmt.Errorf("%q must be a path relative to the application root", project)
+	}
+	if !projectExtensionRe.MatchString(project) && strings.ContainsAny(project, `/\`) {
+		return fmt.Errorf("%q must be a .csproj, .fsproj or .vbproj file or the name of a project in the solution", project)
+	}
+	return nil
+}
+
+func isRelativePath(path string) bool {
+	return !filepath.IsAbs(path) && !strings.HasPrefix(filepath.Clean(path), "..")
+}
+
+// PublishConfiguration is the MSBuild configuration the app is published
+// with: the one in buildpack.yml, else Release when PUBLISH_RELEASE_CONFIG is
+// set and Debug otherwise.
+func (b BuildpackYAML) PublishConfiguration() string {
+	if b.Configuration != "" {
+		return b.Configuration
+	}
+
+	if os.Getenv("PUBLISH_RELEASE_CONFIG") == "true" {
+		return "Release"
+	}
+
+	return "Debug"
+}
+
+// PublishPropertyArgs turns publish-properties into sorted -p:Name=Value
+// arguments for dotnet publish.
+func (b BuildpackYAML) PublishPropertyArgs() []string {
+	var names []string
+	for name := range b.PublishProperties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var args []string
+	for _, name := range names {
+		args = append(args, fmt.Sprintf("-p:%s=%s", name, b.PublishProperties[name]))
+	}
+	return args
+}
+
+// PublishFlagArgs are the extra arguments for dotnet publish from publish-flags
+// in buildpack.yml or else BP_DOTNET_PUBLISH_FLAGS, split with shell quoting
+// rules
+func (b BuildpackYAML) PublishFlagArgs() ([]string, error) {
+	if b.PublishFlags != "" {
+		return splitShellWords(b.PublishFlags)
+	}
+
+	args, err := splitShellWords(os.Getenv("BP_DOTNET_PUBLISH_FLAGS"))
+	if err != nil {
+		return nil, fmt.Errorf("BP_DOTNET_PUBLISH_FLAGS: %s", err)
+	}
+	return args, nil
+}
+
+// PublishOptimizations are the ReadyToRun, trimming and single-file options
+// of dotnet publish
+type PublishOptimizations struct {
+	ReadyToRun bool
+	Trimmed    bool
+	TrimMode   string
+	SingleFile bool
+}
+
+// PublishOptimizations combines the options in buildpack.yml with
+// BP_DOTNET_READY_TO_RUN, BP_DOTNET_TRIMMED, BP_DOTNET_TRIM_MODE and
+// BP_DOTNET_SINGLE_FILE, buildpack.yml taking precedence. A trim mode turns
+// trimming on unless it is explicitly disabled.
+func (b BuildpackYAML) PublishOptimizations() (PublishOptimizations, error) {
+	boolOption := func(value *bool, env string) bool {
+		if value != nil {
+			return *value
+		}
+		return os.Getenv(env) == "true"
+	}
+
+	options := PublishOptimizations{
+		ReadyToRun: boolOption(b.ReadyToRun, "BP_DOTNET_READY_TO_RUN"),
+		Trimmed:    boolOption(b.Trimmed, "BP_DOTNET_TRIMMED"),
+		TrimMode:   b.TrimMode,
+		SingleFile: boolOption(b.SingleFile, "BP_DOTNET_SINGLE_FILE"),
+	}
+
+	if options.TrimMode == "" {
+		options.TrimMode = os.Getenv("BP_DOTNET_TRIM_MODE")
+		if options.TrimMode != "" && !validTrimModes[options.TrimMode] {
+			return PublishOptimizations{}, fmt.Errorf("BP_DOTNET_TRIM_MODE %q must be full or partial", options.TrimMode)
+		}
+	}
+
+	if options.TrimMode != "" {
+		explicitlyDisabled := (b.Trimmed != nil && !*b.Trimmed) || (b.Trimmed == nil && os.Getenv("BP_DOTNET_TRIMMED") == "false")
+		if explicitlyDisabled {
+			options.TrimMode = ""
+		} else {
+			options.Trimmed = true
+		}
+	}
+
+	return options, nil
+}
+
+// Args turns the options into -p:Name=Value arguments for dotnet publish
+func (o PublishOptimizations) Args() []string {
+	var args []string
+	if o.ReadyToRun {
+		args = append(args, "-p:PublishReadyToRun=true")
+	}
+	if o.Trimmed {
+		args = append(args, "-p:PublishTrimmed=true")
+		if o.TrimMode != "" {
+			args = append(args, "-p:TrimMode="+o.TrimMode)
+		}
+	}
+	if o.SingleFile {
+		args = append(args, "-p:PublishSingleFile=true")
+	}
+	return args
+}
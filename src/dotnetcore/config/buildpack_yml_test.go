@@ -0,0 +1,244 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/config"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("LoadBuildpackYAML", func() {
+	var (
+		err      error
+		buildDir string
+	)
+
+	writeBuildpackYAML := func(content string) {
+		Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte(content), 0644)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		buildDir, err = os.MkdirTemp("", "dotnet-core-buildpack.build.")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+	})
+
+	Context("buildpack.yml does not exist", func() {
+		It("returns an empty configuration", func() {
+			buildpackYAML, err := config.LoadBuildpackYAML(buildDir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(buildpackYAML).To(Equal(config.BuildpackYAML{}))
+		})
+	})
+
+	Context("buildpack.yml uses every key", func() {
+		BeforeEach(func() {
+			writeBuildpackYAML(`---
+dotnet-core:
+  sdk: 8.0.x
+  runtime: 8.0.8
+  aspnetcore: 8.0.x
+  configuration: Staging
+  project: src/app/app.csproj
+  target-framework: net8.0
+  processes:
+    web:
+      project: src/app/app.csproj
+  publish-properties:
+    InvariantGlobalization: true
+    Version: 1.2.3
+  keep-node: true
+  framework-dependent: true
+`)
+		})
+
+		It("parses them", func() {
+			buildpackYAML, err := config.LoadBuildpackYAML(buildDir)
+			Expect(err).NotTo(HaveOccurred())
+
+			enabled := true
+			Expect(buildpackYAML).To(Equal(config.BuildpackYAML{
+				SDK:             "8.0.x",
+				Runtime:         "8.0.8",
+				AspNetCore:      "8.0.x",
+				Configuration:   "Staging",
+				Project:         "src/app/app.csproj",
+				TargetFramework: "net8.0",
+				Processes: map[string]config.Process{
+					"web": {Project: "src/app/app.csproj"},
+				},
+				PublishProperties: map[string]string{
+					"InvariantGlobalization": "true",
+					"Version":                "1.2.3",
+				},
+				KeepNode:           &enabled,
+				FrameworkDependent: &enabled,
+			}))
+			Expect(buildpackYAML.PublishPropertyArgs()).To(Equal([]string{"-p:InvariantGlobalization=true", "-p:Version=1.2.3"}))
+		})
+	})
+
+	Context("buildpack.yml contains sections for other buildpacks", func() {
+		BeforeEach(func() {
+			writeBuildpackYAML("nodejs:\n  version: 20.x\ndotnet-core:\n  sdk: 8.0.x\n")
+		})
+
+		It("ignores them", func() {
+			buildpackYAML, err := config.LoadBuildpackYAML(buildDir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(buildpackYAML.SDK).To(Equal("8.0.x"))
+		})
+	})
+
+	Context("buildpack.yml contains an unknown key", func() {
+		BeforeEach(func() {
+			writeBuildpackYAML("dotnet-core:\n  sdk: 8.0.x\n  sdk-version: 8.0.x\n")
+		})
+
+		It("names the key and the supported keys", func() {
+			_, err := config.LoadBuildpackYAML(buildDir)
+			Expect(err).To(MatchError(ContainSubstring(`line 3: unknown key "sdk-version" in dotnet-core, supported keys are: allow-eol, aspnetcore, configuration,`)))
+		})
+	})
+
+	Context("buildpack.yml contains an invalid configuration", func() {
+		BeforeEach(func() {
+			writeBuildpackYAML("dotnet-core:\n  configuration: Release; rm -rf /\n")
+		})
+
+		It("returns an error", func() {
+			_, err := config.LoadBuildpackYAML(buildDir)
+			Expect(err).To(MatchError(ContainSubstring(`configuration "Release; rm -rf /" may only contain`)))
+		})
+	})
+
+	Context("buildpack.yml points at a project outside the app", func() {
+		BeforeEach(func() {
+			writeBuildpackYAML("dotnet-core:\n  project: ../other/other.csproj\n")
+		})
+
+		It("returns an error", func() {
+			_, err := config.LoadBuildpackYAML(buildDir)
+			Expect(err).To(MatchError(ContainSubstring("must be a path relative to the application root")))
+		})
+	})
+
+	Context("buildpack.yml declares a process with an invalid name", func() {
+		BeforeEach(func() {
+			writeBuildpackYAML("dotnet-core:\n  processes:\n    \"web api\":\n      project: src/Api/Api.csproj\n")
+		})
+
+		It("returns an error", func() {
+			_, err := config.LoadBuildpackYAML(buildDir)
+			Expect(err).To(MatchError(ContainSubstring(`processes: "web api" may only contain letters, digits, '-' and '_'`)))
+		})
+	})
+
+	Context("buildpack.yml declares a process with neither a project nor a command", func() {
+		BeforeEach(func() {
+			writeBuildpackYAML("dotnet-core:\n  processes:\n    worker:\n      command: \" \"\n")
+		})
+
+		It("returns an error", func() {
+			_, err := config.LoadBuildpackYAML(buildDir)
+			Expect(err).To(MatchError("invalid buildpack.yml: processes: worker names neither a project nor a command"))
+		})
+	})
+
+	Context("buildpack.yml contains an unknown trim mode", func() {
+		BeforeEach(func() {
+			writeBuildpackYAML("dotnet-core:\n  trim-mode: copyused\n")
+		})
+
+		It("returns an error", func() {
+			_, err := config.LoadBuildpackYAML(buildDir)
+			Expect(err).To(MatchError(`invalid buildpack.yml: trim-mode "copyused" must be full or partial`))
+		})
+	})
+
+	Context("buildpack.yml contains an invalid publish property", func() {
+		BeforeEach(func() {
+			writeBuildpackYAML("dotnet-core:\n  publish-properties:\n    \"-o\": /tmp\n")
+		})
+
+		It("returns an error", func() {
+			_, err := config.LoadBuildpackYAML(buildDir)
+			Expect(err).To(MatchError(ContainSubstring(`"-o" is not a valid MSBuild property name`)))
+		})
+	})
+})
+
+var _ = Describe("PublishOptimizations", func() {
+	AfterEach(func() {
+		for _, env := range []string{"BP_DOTNET_READY_TO_RUN", "BP_DOTNET_TRIMMED", "BP_DOTNET_TRIM_MODE", "BP_DOTNET_SINGLE_FILE"} {
+			Expect(os.Unsetenv(env)).To(Succeed())
+		}
+	})
+
+	It("turns nothing on by default", func() {
+		options, err := config.BuildpackYAML{}.PublishOptimizations()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(options.Args()).To(BeEmpty())
+	})
+
+	It("reads the options from buildpack.yml, a trim mode implying trimming", func() {
+		enabled := true
+		options, err := config.BuildpackYAML{ReadyToRun: &enabled, TrimMode: "partial", SingleFile: &enabled}.PublishOptimizations()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(options.Args()).To(Equal([]string{
+			"-p:PublishReadyToRun=true",
+			"-p:PublishTrimmed=true",
+			"-p:TrimMode=partial",
+			"-p:PublishSingleFile=true",
+		}))
+	})
+
+	It("falls back to the environment, buildpack.yml taking precedence", func() {
+		Expect(os.Setenv("BP_DOTNET_READY_TO_RUN", "true")).To(Succeed())
+		Expect(os.Setenv("BP_DOTNET_TRIM_MODE", "full")).To(Succeed())
+
+		disabled := false
+		options, err := config.BuildpackYAML{Trimmed: &disabled}.PublishOptimizations()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(options.Args()).To(Equal([]string{"-p:PublishReadyToRun=true"}))
+	})
+
+	It("rejects an unknown trim mode in the environment", func() {
+		Expect(os.Setenv("BP_DOTNET_TRIM_MODE", "link")).To(Succeed())
+
+		_, err := config.BuildpackYAML{}.PublishOptimizations()
+		Expect(err).To(MatchError(`BP_DOTNET_TRIM_MODE "link" must be full or partial`))
+	})
+})
+
+var _ = Describe("PublishFlagArgs", func() {
+	AfterEach(func() {
+		Expect(os.Unsetenv("BP_DOTNET_PUBLISH_FLAGS")).To(Succeed())
+	})
+
+	It("splits publish-flags with shell quoting rules", func() {
+		args, err := config.BuildpackYAML{PublishFlags: `-p:Version=1.2.3 --verbosity minimal "-p:Product=My App" '-p:Tag=a "b"' -p:Path=a\ b`}.PublishFlagArgs()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(args).To(Equal([]string{"-p:Version=1.2.3", "--verbosity", "minimal", "-p:Product=My App", `-p:Tag=a "b"`, "-p:Path=a b"}))
+	})
+
+	It("falls back to BP_DOTNET_PUBLISH_FLAGS", func() {
+		Expect(os.Setenv("BP_DOTNET_PUBLISH_FLAGS", `--no-restore  -p:Description="a \"quoted\" word"`)).To(Succeed())
+
+		args, err := config.BuildpackYAML{}.PublishFlagArgs()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(args).To(Equal([]string{"--no-restore", `-p:Description=a "quoted" word`}))
+	})
+
+	It("rejects an unterminated quote", func() {
+		Expect(os.Setenv("BP_DOTNET_PUBLISH_FLAGS", `-p:Product="My App`)).To(Succeed())
+
+		_, err := config.BuildpackYAML{}.PublishFlagArgs()
+		Expect(err).To(MatchError(`BP_DOTNET_PUBLISH_FLAGS: unterminated " quote in "-p:Product=\"My App"`))
+	})
+})
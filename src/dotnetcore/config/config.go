@@ -1,5 +1,33 @@
 package config
 
 type Config struct {
-	DotnetSdkVersion string
+	DotnetSdkVersion      string
+	InstalledDependencies []Dependency `yaml:"installed_dependencies,omitempty"`
+	Resolutions           []Resolution `yaml:"resolutions,omitempty"`
+
+	held []heldVersion
+}
+
+// heldVersion is a resolved version a lock held back at an older patch
+type heldVersion struct {
+	name     string
+	resolved string
+	locked   string
+}
+
+// Dependency is a manifest dependency the buildpack installed into the droplet
+type Dependency struct {
+	Name    string `yaml:"name"`
+	Version string `yaml:"version"`
+}
+
+// RecordInstalled remembers that a dependency was installed, once per name
+// and version
+func (c *Config) RecordInstalled(name, version string) {
+	for _, dep := range c.InstalledDependencies {
+		if dep.Name == name && dep.Version == version {
+			return
+		}
+	}
+	c.InstalledDependencies = append(c.InstalledDependencies, Dependency{Name: name, Version: version})
 }
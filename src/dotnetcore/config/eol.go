@@ -0,0 +1,149 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/blang/semver"
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+const deprecationDateFormat = "2006-01-02"
+
+// eolEnforcedDependencies are the dependencies the EOL policy applies to
+var eolEnforcedDependencies = map[string]bool{
+	"dotnet-sdk":        true,
+	"dotnet-runtime":    true,
+	"dotnet-aspnetcore": true,
+}
+
+// EOLPolicy is what staging does with a dotnet-sdk, dotnet-runtime or
+// dotnet-aspnetcore version whose line is past the end of life date in
+// dependency_deprecation_dates: warn, fail, or fail-after Days days
+type EOLPolicy struct {
+	Action string `yaml:"action"`
+	Days   int    `yaml:"days"`
+}
+
+func (p EOLPolicy) validate() error {
+	switch p.Action {
+	case "", "warn", "fail":
+		return nil
+	case "fail-after":
+		if p.Days <= 0 {
+			return fmt.Errorf("eol_policy fail-after needs a positive number of days")
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid eol_policy action %q, expected warn, fail or fail-after", p.Action)
+}
+
+// EOLEnforcer applies the EOL policy of the platform to the versions the
+// buildpack installs, unless the app allows EOL versions in buildpack.yml
+type EOLEnforcer struct {
+	policy       EOLPolicy
+	allowed      bool
+	deprecations []libbuildpack.DeprecationDate
+	now          time.Time
+	log          *libbuildpack.Logger
+}
+
+func NewEOLEnforcer(policy EOLPolicy, manifest *libbuildpack.Manifest, buildpackYAML BuildpackYAML, logger *libbuildpack.Logger, now time.Time) (*EOLEnforcer, error) {
+	if err := policy.validate(); err != nil {
+		return nil, err
+	}
+
+	return &EOLEnforcer{
+		policy:       policy,
+		allowed:      buildpackYAML.AllowEOL != nil && *buildpackYAML.AllowEOL,
+		deprecations: manifest.Deprecations,
+		now:          now,
+		log:          logger,
+	}, nil
+}
+
+// Check warns about or rejects a dependency version past its end of life,
+// naming the newest supported line among the available versions
+func (e *EOLEnforcer) Check(dep libbuildpack.Dependency, available []string) error {
+	if !eolEnforcedDependencies[dep.Name] {
+		return nil
+	}
+
+	deprecation, eol, found, err := e.deprecation(dep.Name, dep.Version)
+	if err != nil || !found || e.now.Before(eol) {
+		return err
+	}
+
+	message := fmt.Sprintf("%s %s reached the end of life of the %s line on %s", dep.Name, dep.Version, deprecation.VersionLine, deprecation.Date)
+	if deprecation.Link != "" {
+		message += fmt.Sprintf(" (%s)", deprecation.Link)
+	}
+	if line, found, err := e.replacementLine(dep.Name, available); err != nil {
+		return err
+	} else if found {
+		message += fmt.Sprintf("; move to the supported %s line", line)
+	} else {
+		message += fmt.Sprintf("; the buildpack has no supported line of %s", dep.Name)
+	}
+
+	if e.allowed {
+		e.log.Warning("%s. Staging it anyway because allow-eol is set in buildpack.yml", message)
+		return nil
+	}
+
+	switch e.policy.Action {
+	case "fail":
+		return fmt.Errorf("%s. Set allow-eol: true in buildpack.yml to stage it anyway", message)
+	case "fail-after":
+		failFrom := eol.AddDate(0, 0, e.policy.Days)
+		if !e.now.Before(failFrom) {
+			return fmt.Errorf("%s. Staging fails %d days after the end of life, set allow-eol: true in buildpack.yml to stage it anyway", message, e.policy.Days)
+		}
+		e.log.Warning("%s. Staging will fail from %s", message, failFrom.Format(deprecationDateFormat))
+	default:
+		e.log.Warning("%s", message)
+	}
+	return nil
+}
+
+func (e *EOLEnforcer) deprecation(name, version string) (libbuildpack.DeprecationDate, time.Time, bool, error) {
+	for _, deprecation := range e.deprecations {
+		if deprecation.Name != name {
+			continue
+		}
+		if _, err := libbuildpack.FindMatchingVersion(deprecation.VersionLine, []string{version}); err != nil {
+			continue
+		}
+
+		eol, err := time.Parse(deprecationDateFormat, deprecation.Date)
+		if err != nil {
+			return libbuildpack.DeprecationDate{}, time.Time{}, false, fmt.Errorf("invalid deprecation date %q for %s %s: %v", deprecation.Date, name, deprecation.VersionLine, err)
+		}
+		return deprecation, eol, true, nil
+	}
+	return libbuildpack.DeprecationDate{}, time.Time{}, false, nil
+}
+
+// replacementLine is the newest major.minor line of the available versions
+// that is not past its end of life
+func (e *EOLEnforcer) replacementLine(name string, available []string) (string, bool, error) {
+	var versions []semver.Version
+	for _, raw := range available {
+		if version, err := semver.Parse(raw); err == nil {
+			versions = append(versions, version)
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].GT(versions[j]) })
+
+	for _, version := range versions {
+		_, eol, found, err := e.deprecation(name, version.String())
+		if err != nil {
+			return "", false, err
+		}
+		if !found || e.now.Before(eol) {
+			return fmt.Sprintf("%d.%d.x", version.Major, version.Minor), true, nil
+		}
+	}
+	return "", false, nil
+}
@@ -0,0 +1,98 @@
+package config_test
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/config"
+	"github.com/cloudfoundry/libbuildpack"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("EOLEnforcer", func() {
+	var (
+		buildpackYAML config.BuildpackYAML
+		buffer        *bytes.Buffer
+		logger        *libbuildpack.Logger
+		manifest      *libbuildpack.Manifest
+		policy        config.EOLPolicy
+		now           time.Time
+		available     = []string{"6.0.33", "8.0.8"}
+		runtime6      = libbuildpack.Dependency{Name: "dotnet-runtime", Version: "6.0.33"}
+	)
+
+	BeforeEach(func() {
+		buildpackYAML = config.BuildpackYAML{}
+		buffer = new(bytes.Buffer)
+		logger = libbuildpack.NewLogger(buffer)
+		manifest = &libbuildpack.Manifest{Deprecations: []libbuildpack.DeprecationDate{
+			{Name: "dotnet-runtime", VersionLine: "6.0.x", Date: "2024-11-08", Link: "https://example.com/eol"},
+			{Name: "dotnet-runtime", VersionLine: "8.0.x", Date: "2026-11-10"},
+		}}
+		policy = config.EOLPolicy{Action: "warn"}
+		now = time.Date(2024, 11, 18, 0, 0, 0, 0, time.UTC)
+	})
+
+	check := func(dep libbuildpack.Dependency) error {
+		enforcer, err := config.NewEOLEnforcer(policy, manifest, buildpackYAML, logger, now)
+		Expect(err).NotTo(HaveOccurred())
+		return enforcer.Check(dep, available)
+	}
+
+	It("warns about a version past its end of life, naming the supported line", func() {
+		Expect(check(runtime6)).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("dotnet-runtime 6.0.33 reached the end of life of the 6.0.x line on 2024-11-08 (https://example.com/eol); move to the supported 8.0.x line"))
+	})
+
+	It("ignores versions that are still supported and dependencies it does not enforce", func() {
+		policy = config.EOLPolicy{Action: "fail"}
+		Expect(check(libbuildpack.Dependency{Name: "dotnet-runtime", Version: "8.0.8"})).To(Succeed())
+		Expect(check(libbuildpack.Dependency{Name: "node", Version: "6.0.33"})).To(Succeed())
+		Expect(buffer.String()).To(BeEmpty())
+	})
+
+	It("fails under the fail policy", func() {
+		policy = config.EOLPolicy{Action: "fail"}
+		Expect(check(runtime6)).To(MatchError(ContainSubstring("move to the supported 8.0.x line. Set allow-eol: true in buildpack.yml to stage it anyway")))
+	})
+
+	Context("fail-after", func() {
+		BeforeEach(func() {
+			policy = config.EOLPolicy{Action: "fail-after", Days: 30}
+		})
+
+		It("warns within the grace period, naming the date staging starts failing", func() {
+			Expect(check(runtime6)).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("Staging will fail from 2024-12-08"))
+		})
+
+		It("fails after the grace period", func() {
+			now = time.Date(2024, 12, 8, 0, 0, 0, 0, time.UTC)
+			Expect(check(runtime6)).To(MatchError(ContainSubstring("Staging fails 30 days after the end of life")))
+		})
+	})
+
+	It("says when no supported line is left", func() {
+		now = time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+		Expect(check(runtime6)).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("the buildpack has no supported line of dotnet-runtime"))
+	})
+
+	It("stages with a warning when the app allows EOL versions", func() {
+		policy = config.EOLPolicy{Action: "fail"}
+		allowEOL := true
+		buildpackYAML.AllowEOL = &allowEOL
+
+		Expect(check(runtime6)).To(Succeed())
+		Expect(buffer.String()).To(ContainSubstring("Staging it anyway because allow-eol is set in buildpack.yml"))
+	})
+
+	It("rejects an invalid policy", func() {
+		_, err := config.NewEOLEnforcer(config.EOLPolicy{Action: "block"}, manifest, buildpackYAML, logger, now)
+		Expect(err).To(MatchError(`invalid eol_policy action "block", expected warn, fail or fail-after`))
+
+		_, err = config.NewEOLEnforcer(config.EOLPolicy{Action: "fail-after"}, manifest, buildpackYAML, logger, now)
+		Expect(err).To(MatchError("eol_policy fail-after needs a positive number of days"))
+	})
+})
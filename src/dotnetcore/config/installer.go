@@ -0,0 +1,123 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// Installer is a libbuildpack.Installer that records every dependency it
+// installs in the config, so that finalize can list them in the SBOM. It
+// fails clearly when the manifest has no build of a dependency for the
+// architecture of the cell. When EOL is set, it applies the EOL policy
+// before installing, and when Lock is set it installs the locked patch of a
+// dependency instead of the newest one.
+type Installer struct {
+	*libbuildpack.Installer
+	manifest *libbuildpack.Manifest
+	config   *Config
+	arch     string
+
+	EOL  *EOLEnforcer
+	Lock *Lock
+}
+
+func NewInstaller(installer *libbuildpack.Installer, manifest *libbuildpack.Manifest, cfg *Config, arch string) *Installer {
+	return &Installer{
+		Installer: installer,
+		manifest:  manifest,
+		config:    cfg,
+		arch:      arch,
+	}
+}
+
+func (i *Installer) InstallDependency(dep libbuildpack.Dependency, outputDir string) error {
+	if err := i.checkAvailable(dep); err != nil {
+		return err
+	}
+
+	dep, err := i.applyPolicies(dep)
+	if err != nil {
+		return err
+	}
+
+	if err := i.Installer.InstallDependency(dep, outputDir); err != nil {
+		return err
+	}
+
+	i.config.RecordInstalled(dep.Name, dep.Version)
+	return nil
+}
+
+// Plan applies the lock and the EOL policy to a resolved dependency the way
+// InstallDependency does, without installing anything. It returns the
+// resolution with the version staging would install.
+func (i *Installer) Plan(resolution Resolution) (Resolution, error) {
+	if _, err := i.applyPolicies(libbuildpack.Dependency{Name: resolution.Name, Version: resolution.Version}); err != nil {
+		return Resolution{}, err
+	}
+	return i.config.RecordResolution(resolution), nil
+}
+
+// applyPolicies holds a dependency at its locked version and then checks it
+// against the EOL policy
+func (i *Installer) applyPolicies(dep libbuildpack.Dependency) (libbuildpack.Dependency, error) {
+	if i.Lock != nil {
+		if locked, held := i.Lock.Hold(dep, i.manifest.AllDependencyVersions(dep.Name)); held {
+			i.config.held = append(i.config.held, heldVersion{name: dep.Name, resolved: dep.Version, locked: locked})
+			dep.Version = locked
+		}
+	}
+
+	if i.EOL != nil {
+		if err := i.EOL.Check(dep, i.manifest.AllDependencyVersions(dep.Name)); err != nil {
+			return libbuildpack.Dependency{}, err
+		}
+	}
+	return dep, nil
+}
+
+func (i *Installer) FetchDependency(dep libbuildpack.Dependency, outputFile string) error {
+	if err := i.checkAvailable(dep); err != nil {
+		return err
+	}
+
+	if err := i.Installer.FetchDependency(dep, outputFile); err != nil {
+		return err
+	}
+
+	i.config.RecordInstalled(dep.Name, dep.Version)
+	return nil
+}
+
+func (i *Installer) InstallOnlyVersion(depName string, installDir string) error {
+	if len(i.manifest.AllDependencyVersions(depName)) == 0 {
+		return fmt.Errorf("the buildpack has no linux-%s build of %s for %s", i.arch, depName, os.Getenv("CF_STACK"))
+	}
+
+	if err := i.Installer.InstallOnlyVersion(depName, installDir); err != nil {
+		return err
+	}
+
+	if versions := i.manifest.AllDependencyVersions(depName); len(versions) == 1 {
+		i.config.RecordInstalled(depName, versions[0])
+		i.config.RecordResolution(Resolution{Name: depName, Source: "manifest", Steps: []string{"only version"}, Version: versions[0]})
+	}
+	return nil
+}
+
+func (i *Installer) checkAvailable(dep libbuildpack.Dependency) error {
+	versions := i.manifest.AllDependencyVersions(dep.Name)
+	for _, version := range versions {
+		if version == dep.Version {
+			return nil
+		}
+	}
+
+	if len(versions) == 0 {
+		return fmt.Errorf("the buildpack has no linux-%s build of %s for %s", i.arch, dep.Name, os.Getenv("CF_STACK"))
+	}
+	return fmt.Errorf("the buildpack has no linux-%s build of %s %s for %s, available versions are: %s", i.arch, dep.Name, dep.Version, os.Getenv("CF_STACK"), strings.Join(versions, ", "))
+}
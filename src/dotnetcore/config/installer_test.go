@@ -0,0 +1,131 @@
+package config_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/config"
+	"github.com/cloudfoundry/libbuildpack"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Installer", func() {
+	var (
+		err          error
+		buildpackDir string
+		outputDir    string
+		cfg          *config.Config
+		installer    *config.Installer
+	)
+
+	BeforeEach(func() {
+		buildpackDir, err = os.MkdirTemp("", "dotnet-core-buildpack.buildpack.")
+		Expect(err).To(BeNil())
+
+		outputDir, err = os.MkdirTemp("", "dotnet-core-buildpack.output.")
+		Expect(err).To(BeNil())
+
+		Expect(os.Setenv("CF_STACK", "cflinuxfs4")).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(buildpackDir, "manifest.yml"), []byte(`---
+language: dotnet-core
+dependencies:
+- name: dotnet-sdk
+  version: 8.0.401
+  uri: https://example.com/dotnet-sdk_8.0.401_linux_arm64.tar.xz
+  sha256: aaaa
+  cf_stacks: [cflinuxfs4]
+`), 0644)).To(Succeed())
+
+		manifest, err := libbuildpack.NewManifest(buildpackDir, libbuildpack.NewLogger(&bytes.Buffer{}), time.Now())
+		Expect(err).To(BeNil())
+
+		cfg = &config.Config{}
+		installer = config.NewInstaller(libbuildpack.NewInstaller(manifest), manifest, cfg, "arm64")
+	})
+
+	AfterEach(func() {
+		Expect(os.Unsetenv("CF_STACK")).To(Succeed())
+		Expect(os.RemoveAll(buildpackDir)).To(Succeed())
+		Expect(os.RemoveAll(outputDir)).To(Succeed())
+	})
+
+	It("fails naming the architecture when the version has no build for it", func() {
+		err := installer.InstallDependency(libbuildpack.Dependency{Name: "dotnet-sdk", Version: "9.0.100"}, outputDir)
+		Expect(err).To(MatchError("the buildpack has no linux-arm64 build of dotnet-sdk 9.0.100 for cflinuxfs4, available versions are: 8.0.401"))
+		Expect(cfg.InstalledDependencies).To(BeEmpty())
+	})
+
+	It("fails naming the architecture when the dependency has no build for it", func() {
+		err := installer.InstallOnlyVersion("libunwind", outputDir)
+		Expect(err).To(MatchError("the buildpack has no linux-arm64 build of libunwind for cflinuxfs4"))
+	})
+
+	Describe("Plan", func() {
+		var (
+			buildDir string
+			manifest *libbuildpack.Manifest
+			logger   *libbuildpack.Logger
+		)
+
+		BeforeEach(func() {
+			buildDir, err = os.MkdirTemp("", "dotnet-core-buildpack.build.")
+			Expect(err).To(BeNil())
+
+			Expect(os.WriteFile(filepath.Join(buildpackDir, "manifest.yml"), []byte(`---
+language: dotnet-core
+dependencies:
+- name: dotnet-sdk
+  version: 8.0.401
+  uri: https://example.com/dotnet-sdk_8.0.401.tar.xz
+  sha256: aaaa
+  cf_stacks: [cflinuxfs4]
+- name: dotnet-sdk
+  version: 8.0.402
+  uri: https://example.com/dotnet-sdk_8.0.402.tar.xz
+  sha256: bbbb
+  cf_stacks: [cflinuxfs4]
+- name: dotnet-runtime
+  version: 6.0.33
+  uri: https://example.com/dotnet-runtime_6.0.33.tar.xz
+  sha256: cccc
+  cf_stacks: [cflinuxfs4]
+dependency_deprecation_dates:
+- name: dotnet-runtime
+  version_line: 6.0.x
+  date: 2024-11-12
+`), 0644)).To(Succeed())
+
+			logger = libbuildpack.NewLogger(&bytes.Buffer{})
+			manifest, err = libbuildpack.NewManifest(buildpackDir, logger, time.Now())
+			Expect(err).To(BeNil())
+
+			cfg = &config.Config{}
+			installer = config.NewInstaller(libbuildpack.NewInstaller(manifest), manifest, cfg, "x64")
+			installer.EOL, err = config.NewEOLEnforcer(config.EOLPolicy{Action: "fail"}, manifest, config.BuildpackYAML{}, logger, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+			Expect(err).To(BeNil())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(buildDir)).To(Succeed())
+		})
+
+		It("holds a dependency at its locked version without installing it", func() {
+			Expect(os.WriteFile(filepath.Join(buildDir, config.LockFile), []byte("dependencies:\n- name: dotnet-sdk\n  version: 8.0.401\n"), 0644)).To(Succeed())
+			installer.Lock, err = config.LoadLock(buildDir, "", config.BuildpackYAML{}, logger)
+			Expect(err).To(BeNil())
+
+			resolution, err := installer.Plan(config.Resolution{Name: "dotnet-sdk", Constraint: "8.0.400", Source: "global.json", Steps: []string{"latest patch"}, Version: "8.0.402"})
+			Expect(err).To(BeNil())
+			Expect(resolution).To(Equal(config.Resolution{Name: "dotnet-sdk", Constraint: "8.0.400", Source: "global.json", Steps: []string{"latest patch", "locked: 8.0.401, 8.0.402 held back"}, Version: "8.0.401"}))
+			Expect(cfg.InstalledDependencies).To(BeEmpty())
+		})
+
+		It("rejects a version the EOL policy rejects", func() {
+			_, err := installer.Plan(config.Resolution{Name: "dotnet-runtime", Source: "runtimeconfig.json", Version: "6.0.33"})
+			Expect(err).To(MatchError(ContainSubstring("dotnet-runtime 6.0.33 reached the end of life of the 6.0.x line on 2024-11-12")))
+		})
+	})
+})
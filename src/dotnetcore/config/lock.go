@@ -0,0 +1,177 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/blang/semver"
+	"github.com/cloudfoundry/libbuildpack"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// LockFile holds the dependency versions of an earlier staging, either in
+// the app or in the app cache
+const LockFile = "dotnet-buildpack.lock"
+
+// lockedDependencies are the dependencies a lock holds at their version
+var lockedDependencies = map[string]bool{
+	"dotnet-sdk":        true,
+	"dotnet-runtime":    true,
+	"dotnet-aspnetcore": true,
+	"node":              true,
+}
+
+// Lock holds dependencies at the patch an earlier staging installed, so that
+// restaging an unchanged app installs the same versions while the buildpack
+// still has them
+type Lock struct {
+	Dependencies []Dependency `yaml:"dependencies"`
+
+	source  string
+	fromApp bool
+	pinned  map[string]string
+	log     *libbuildpack.Logger
+}
+
+// LoadLock returns the lock the app ships as dotnet-buildpack.lock, or the
+// one in the app cache when buildpack.yml sets lock: true. It is nil when the
+// app does not use a lock, and holds nothing for lock: true without a cache
+// dir.
+func LoadLock(buildDir, cacheDir string, buildpackYAML BuildpackYAML, logger *libbuildpack.Logger) (*Lock, error) {
+	// Exact versions in buildpack.yml are what the app asks for, a lock
+	// only holds back versions that float
+	pinned := map[string]string{}
+	for name, version := range map[string]string{
+		"dotnet-sdk":        buildpackYAML.SDK,
+		"dotnet-runtime":    buildpackYAML.Runtime,
+		"dotnet-aspnetcore": buildpackYAML.AspNetCore,
+	} {
+		if _, err := semver.Parse(version); err == nil {
+			pinned[name] = version
+		}
+	}
+
+	appLock := filepath.Join(buildDir, LockFile)
+	if exists, err := libbuildpack.FileExists(appLock); err != nil {
+		return nil, err
+	} else if exists {
+		return readLock(appLock, LockFile, true, pinned, logger)
+	}
+
+	if buildpackYAML.Lock == nil || !*buildpackYAML.Lock {
+		return nil, nil
+	}
+
+	if cacheDir != "" {
+		cacheLock := filepath.Join(cacheDir, LockFile)
+		if exists, err := libbuildpack.FileExists(cacheLock); err != nil {
+			return nil, err
+		} else if exists {
+			return readLock(cacheLock, LockFile+" in the app cache", false, pinned, logger)
+		}
+	}
+	return &Lock{source: LockFile + " in the app cache", pinned: pinned, log: logger}, nil
+}
+
+func readLock(path, source string, fromApp bool, pinned map[string]string, logger *libbuildpack.Logger) (*Lock, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	lock := &Lock{source: source, fromApp: fromApp, pinned: pinned, log: logger}
+	if err := yaml.UnmarshalStrict(content, lock); err != nil {
+		return nil, fmt.Errorf("invalid %s: %s", source, err)
+	}
+	for _, dep := range lock.Dependencies {
+		if _, err := semver.Parse(dep.Version); err != nil {
+			return nil, fmt.Errorf("invalid %s: %s version %q is not a semantic version", source, dep.Name, dep.Version)
+		}
+	}
+	return lock, nil
+}
+
+// Hold returns the locked version to install instead of dep, when the lock
+// has an older patch of the same line and the buildpack still has it. It
+// warns about the newer patch it holds back. A version pinned exactly in
+// buildpack.yml is never replaced.
+func (l *Lock) Hold(dep libbuildpack.Dependency, available []string) (string, bool) {
+	if !lockedDependencies[dep.Name] || l.pinned[dep.Name] == dep.Version {
+		return "", false
+	}
+
+	resolved, err := semver.Parse(dep.Version)
+	if err != nil {
+		return "", false
+	}
+
+	for _, locked := range l.Dependencies {
+		lockedVersion, err := semver.Parse(locked.Version)
+		if locked.Name != dep.Name || err != nil || lockLine(dep.Name, lockedVersion) != lockLine(dep.Name, resolved) || !lockedVersion.LT(resolved) {
+			continue
+		}
+
+		if !contains(available, locked.Version) {
+			l.log.Warning("%s %s from %s is no longer in the buildpack, installing %s", dep.Name, locked.Version, l.source, dep.Version)
+			return "", false
+		}
+
+		l.log.Warning("Holding %s at %s from %s, newer patch %s is available", dep.Name, locked.Version, l.source, dep.Version)
+		return locked.Version, true
+	}
+	return "", false
+}
+
+// Write records the locked dependencies staging installed in the app cache,
+// unless the app ships its own lock
+func (l *Lock) Write(cacheDir string, installed []Dependency) error {
+	if l.fromApp {
+		return nil
+	}
+
+	lock := Lock{}
+	for _, dep := range installed {
+		if lockedDependencies[dep.Name] {
+			lock.Dependencies = append(lock.Dependencies, dep)
+		}
+	}
+	sort.Slice(lock.Dependencies, func(i, j int) bool {
+		if lock.Dependencies[i].Name != lock.Dependencies[j].Name {
+			return lock.Dependencies[i].Name < lock.Dependencies[j].Name
+		}
+		return lock.Dependencies[i].Version < lock.Dependencies[j].Version
+	})
+
+	content, err := yaml.Marshal(lock)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(cacheDir, LockFile), content, 0644)
+}
+
+// lockLine is the line within which a lock holds a dependency back: the
+// major version of node, the feature band of the SDK and the major.minor of
+// the runtimes
+func lockLine(name string, version semver.Version) string {
+	switch name {
+	case "node":
+		return fmt.Sprintf("%d", version.Major)
+	case "dotnet-sdk":
+		return fmt.Sprintf("%d.%d.%d", version.Major, version.Minor, version.Patch/100)
+	}
+	return fmt.Sprintf("%d.%d", version.Major, version.Minor)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
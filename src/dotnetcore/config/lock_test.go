@@ -0,0 +1,161 @@
+package config_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/config"
+	"github.com/cloudfoundry/libbuildpack"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Lock", func() {
+	var (
+		err      error
+		buildDir string
+		cacheDir string
+
+		buildpackYAML config.BuildpackYAML
+		buffer        *bytes.Buffer
+		logger        *libbuildpack.Logger
+	)
+
+	BeforeEach(func() {
+		buildDir, err = os.MkdirTemp("", "dotnet-core-buildpack.build.")
+		Expect(err).To(BeNil())
+
+		cacheDir, err = os.MkdirTemp("", "dotnet-core-buildpack.cache.")
+		Expect(err).To(BeNil())
+
+		buildpackYAML = config.BuildpackYAML{}
+		buffer = new(bytes.Buffer)
+		logger = libbuildpack.NewLogger(buffer)
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(buildDir)).To(Succeed())
+		Expect(os.RemoveAll(cacheDir)).To(Succeed())
+	})
+
+	writeLock := func(dir string) {
+		Expect(os.WriteFile(filepath.Join(dir, "dotnet-buildpack.lock"), []byte(`---
+dependencies:
+- name: dotnet-runtime
+  version: 8.0.6
+- name: dotnet-sdk
+  version: 8.0.301
+- name: node
+  version: 20.10.0
+`), 0644)).To(Succeed())
+	}
+
+	It("is not used unless the app ships a lock or sets lock in buildpack.yml", func() {
+		writeLock(cacheDir)
+
+		lock, err := config.LoadLock(buildDir, cacheDir, buildpackYAML, logger)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lock).To(BeNil())
+	})
+
+	Context("buildpack.yml sets lock", func() {
+		BeforeEach(func() {
+			enabled := true
+			buildpackYAML.Lock = &enabled
+		})
+
+		It("starts empty on the first staging and records the installed versions in the app cache", func() {
+			lock, err := config.LoadLock(buildDir, cacheDir, buildpackYAML, logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lock.Dependencies).To(BeEmpty())
+
+			Expect(lock.Write(cacheDir, []config.Dependency{
+				{Name: "dotnet-sdk", Version: "8.0.401"},
+				{Name: "libunwind", Version: "1.6.2"},
+				{Name: "dotnet-runtime", Version: "8.0.8"},
+			})).To(Succeed())
+
+			lock, err = config.LoadLock(buildDir, cacheDir, buildpackYAML, logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lock.Dependencies).To(Equal([]config.Dependency{
+				{Name: "dotnet-runtime", Version: "8.0.8"},
+				{Name: "dotnet-sdk", Version: "8.0.401"},
+			}))
+		})
+
+		It("holds back newer patches of a locked line while the buildpack has the locked one", func() {
+			writeLock(cacheDir)
+			lock, err := config.LoadLock(buildDir, cacheDir, buildpackYAML, logger)
+			Expect(err).NotTo(HaveOccurred())
+
+			version, held := lock.Hold(libbuildpack.Dependency{Name: "dotnet-runtime", Version: "8.0.8"}, []string{"8.0.6", "8.0.8"})
+			Expect(held).To(BeTrue())
+			Expect(version).To(Equal("8.0.6"))
+			Expect(buffer.String()).To(ContainSubstring("Holding dotnet-runtime at 8.0.6 from dotnet-buildpack.lock in the app cache, newer patch 8.0.8 is available"))
+
+			version, held = lock.Hold(libbuildpack.Dependency{Name: "node", Version: "20.11.1"}, []string{"20.10.0", "20.11.1"})
+			Expect(held).To(BeTrue())
+			Expect(version).To(Equal("20.10.0"))
+		})
+
+		It("installs the resolved version of other lines and feature bands", func() {
+			writeLock(cacheDir)
+			lock, err := config.LoadLock(buildDir, cacheDir, buildpackYAML, logger)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, held := lock.Hold(libbuildpack.Dependency{Name: "dotnet-runtime", Version: "9.0.0"}, []string{"8.0.6", "9.0.0"})
+			Expect(held).To(BeFalse())
+
+			_, held = lock.Hold(libbuildpack.Dependency{Name: "dotnet-sdk", Version: "8.0.401"}, []string{"8.0.301", "8.0.401"})
+			Expect(held).To(BeFalse())
+		})
+
+		It("warns when the locked version is no longer in the buildpack", func() {
+			writeLock(cacheDir)
+			lock, err := config.LoadLock(buildDir, cacheDir, buildpackYAML, logger)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, held := lock.Hold(libbuildpack.Dependency{Name: "dotnet-runtime", Version: "8.0.8"}, []string{"8.0.8"})
+			Expect(held).To(BeFalse())
+			Expect(buffer.String()).To(ContainSubstring("dotnet-runtime 8.0.6 from dotnet-buildpack.lock in the app cache is no longer in the buildpack, installing 8.0.8"))
+		})
+	})
+
+	Context("the app ships dotnet-buildpack.lock", func() {
+		BeforeEach(func() {
+			writeLock(buildDir)
+		})
+
+		It("uses it and leaves the app cache alone", func() {
+			lock, err := config.LoadLock(buildDir, cacheDir, buildpackYAML, logger)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lock.Dependencies).To(HaveLen(3))
+
+			Expect(lock.Write(cacheDir, []config.Dependency{{Name: "dotnet-sdk", Version: "8.0.401"}})).To(Succeed())
+			Expect(filepath.Join(cacheDir, "dotnet-buildpack.lock")).NotTo(BeAnExistingFile())
+		})
+
+		It("never replaces a version pinned exactly in buildpack.yml", func() {
+			buildpackYAML.Runtime = "8.0.8"
+			buildpackYAML.SDK = "8.0.3xx"
+			lock, err := config.LoadLock(buildDir, cacheDir, buildpackYAML, logger)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, held := lock.Hold(libbuildpack.Dependency{Name: "dotnet-runtime", Version: "8.0.8"}, []string{"8.0.6", "8.0.8"})
+			Expect(held).To(BeFalse())
+			Expect(buffer.String()).To(BeEmpty())
+
+			version, held := lock.Hold(libbuildpack.Dependency{Name: "dotnet-sdk", Version: "8.0.303"}, []string{"8.0.301", "8.0.303"})
+			Expect(held).To(BeTrue())
+			Expect(version).To(Equal("8.0.301"))
+		})
+
+		It("rejects versions that are not semantic versions", func() {
+			Expect(os.WriteFile(filepath.Join(buildDir, "dotnet-buildpack.lock"), []byte("dependencies:\n- name: node\n  version: latest\n"), 0644)).To(Succeed())
+
+			_, err := config.LoadLock(buildDir, cacheDir, buildpackYAML, logger)
+			Expect(err).To(MatchError(`invalid dotnet-buildpack.lock: node version "latest" is not a semantic version`))
+		})
+	})
+})
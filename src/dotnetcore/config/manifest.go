@@ -0,0 +1,174 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// SharedFramework maps a shared framework name, as it appears in a
+// runtimeconfig.json, to the manifest dependency that provides it
+type SharedFramework struct {
+	Name       string `yaml:"name"`
+	Dependency string `yaml:"dependency"`
+}
+
+// ManifestDependency is a dependency entry of manifest.yml together with the
+// architecture of its artifact, which libbuildpack.Manifest does not know
+// about. Entries without arch are x64, noarch ones run on every architecture.
+type ManifestDependency struct {
+	libbuildpack.ManifestEntry `yaml:",inline"`
+	Arch                       string `yaml:"arch"`
+}
+
+// Stack describes what the buildpack needs to know about a stack: the
+// operating system part of its runtime identifier and what it provides
+type Stack struct {
+	Name                  string `yaml:"name"`
+	RuntimeOS             string `yaml:"runtime_os"`
+	LegacyOpenSSLProvider bool   `yaml:"legacy_openssl_provider"`
+}
+
+// RuntimeIdentifier is the RID dotnet publish targets on the stack
+func (s Stack) RuntimeIdentifier(arch string) string {
+	return s.RuntimeOS + "-" + arch
+}
+
+// ManifestExtensions are the dotnet-core specific sections of manifest.yml
+// that libbuildpack.Manifest does not know about
+type ManifestExtensions struct {
+	SharedFrameworks []SharedFramework    `yaml:"shared_frameworks"`
+	Dependencies     []ManifestDependency `yaml:"dependencies"`
+	Stacks           []Stack              `yaml:"stacks"`
+	EOLPolicy        EOLPolicy            `yaml:"eol_policy"`
+}
+
+// DefaultSharedFrameworks are always known, manifest.yml and override.yml can
+// add to them or point them at other dependencies
+var DefaultSharedFrameworks = []SharedFramework{
+	{Name: "Microsoft.NETCore.App", Dependency: "dotnet-runtime"},
+	{Name: "Microsoft.AspNetCore.App", Dependency: "dotnet-aspnetcore"},
+}
+
+// LoadManifestExtensions reads the extensions from the buildpack's
+// manifest.yml and applies the dotnet-core section of every override.yml in
+// the deps dir on top, the same way libbuildpack applies dependency overrides.
+// Without a deps dir only manifest.yml is read.
+func LoadManifestExtensions(buildpackDir, depsDir string) (ManifestExtensions, error) {
+	extensions := ManifestExtensions{}
+	if err := libbuildpack.NewYAML().Load(filepath.Join(buildpackDir, "manifest.yml"), &extensions); err != nil {
+		return ManifestExtensions{}, err
+	}
+
+	if depsDir == "" {
+		return extensions, nil
+	}
+
+	overrides, err := filepath.Glob(filepath.Join(depsDir, "*", "override.yml"))
+	if err != nil {
+		return ManifestExtensions{}, err
+	}
+
+	for _, file := range overrides {
+		overrideYml := map[string]ManifestExtensions{}
+		if err := libbuildpack.NewYAML().Load(file, &overrideYml); err != nil {
+			return ManifestExtensions{}, err
+		}
+
+		if override, found := overrideYml["dotnet-core"]; found {
+			extensions.merge(override)
+		}
+	}
+
+	return extensions, nil
+}
+
+func (e *ManifestExtensions) merge(override ManifestExtensions) {
+	for _, fw := range override.SharedFrameworks {
+		e.SharedFrameworks = mergeSharedFramework(e.SharedFrameworks, fw)
+	}
+
+	for _, dep := range override.Dependencies {
+		e.Dependencies = mergeDependency(e.Dependencies, dep)
+	}
+
+	for _, stack := range override.Stacks {
+		e.Stacks = mergeStack(e.Stacks, stack)
+	}
+
+	if override.EOLPolicy.Action != "" {
+		e.EOLPolicy = override.EOLPolicy
+	}
+}
+
+// Stack returns the declaration of a stack, failing for stacks that neither
+// manifest.yml nor an override.yml declare
+func (e ManifestExtensions) Stack(name string) (Stack, error) {
+	var names []string
+	for _, stack := range e.Stacks {
+		if stack.Name == name {
+			if stack.RuntimeOS == "" {
+				return Stack{}, fmt.Errorf("stack %s does not declare runtime_os", name)
+			}
+			return stack, nil
+		}
+		names = append(names, stack.Name)
+	}
+	return Stack{}, fmt.Errorf("unsupported stack %q, supported stacks are: %s; declare it under stacks in override.yml to use it", name, strings.Join(names, ", "))
+}
+
+func mergeStack(stacks []Stack, stack Stack) []Stack {
+	for i, existing := range stacks {
+		if existing.Name == stack.Name {
+			stacks[i] = stack
+			return stacks
+		}
+	}
+	return append(stacks, stack)
+}
+
+// SelectArchitecture replaces the dependencies of the manifest with the ones
+// built for the given architecture, so that version resolution and installs
+// only see artifacts that run on the cell. Entries of override.yml replace
+// the manifest.yml entry with the same name, version and architecture.
+func (e ManifestExtensions) SelectArchitecture(manifest *libbuildpack.Manifest, arch string) {
+	var entries []libbuildpack.ManifestEntry
+	for _, dep := range e.Dependencies {
+		if dep.Arch == "noarch" || normalizeArchitecture(dep.Arch) == arch {
+			entries = append(entries, dep.ManifestEntry)
+		}
+	}
+	manifest.ManifestEntries = entries
+}
+
+func mergeDependency(dependencies []ManifestDependency, dep ManifestDependency) []ManifestDependency {
+	for i, existing := range dependencies {
+		if existing.Dependency == dep.Dependency && normalizeArchitecture(existing.Arch) == normalizeArchitecture(dep.Arch) {
+			dependencies[i] = dep
+			return dependencies
+		}
+	}
+	return append(dependencies, dep)
+}
+
+// AllSharedFrameworks returns the default shared frameworks combined with the
+// ones declared in the manifest, the latter taking precedence
+func (e ManifestExtensions) AllSharedFrameworks() []SharedFramework {
+	frameworks := append([]SharedFramework{}, DefaultSharedFrameworks...)
+	for _, fw := range e.SharedFrameworks {
+		frameworks = mergeSharedFramework(frameworks, fw)
+	}
+	return frameworks
+}
+
+func mergeSharedFramework(frameworks []SharedFramework, fw SharedFramework) []SharedFramework {
+	for i, existing := range frameworks {
+		if existing.Name == fw.Name {
+			frameworks[i] = fw
+			return frameworks
+		}
+	}
+	return append(frameworks, fw)
+}
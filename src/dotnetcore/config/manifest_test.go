@@ -0,0 +1,218 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/config"
+	"github.com/cloudfoundry/libbuildpack"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("LoadManifestExtensions", func() {
+	var (
+		err          error
+		buildpackDir string
+		depsDir      string
+	)
+
+	BeforeEach(func() {
+		buildpackDir, err = os.MkdirTemp("", "dotnet-core-buildpack.buildpack.")
+		Expect(err).To(BeNil())
+
+		depsDir, err = os.MkdirTemp("", "dotnet-core-buildpack.deps.")
+		Expect(err).To(BeNil())
+
+		Expect(os.WriteFile(filepath.Join(buildpackDir, "manifest.yml"), []byte(`---
+language: dotnet-core
+shared_frameworks:
+- name: Microsoft.WindowsDesktop.App
+  dependency: dotnet-windowsdesktop
+`), 0644)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(buildpackDir)).To(Succeed())
+		Expect(os.RemoveAll(depsDir)).To(Succeed())
+	})
+
+	It("combines the default shared frameworks with the ones in manifest.yml", func() {
+		extensions, err := config.LoadManifestExtensions(buildpackDir, depsDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(extensions.AllSharedFrameworks()).To(Equal([]config.SharedFramework{
+			{Name: "Microsoft.NETCore.App", Dependency: "dotnet-runtime"},
+			{Name: "Microsoft.AspNetCore.App", Dependency: "dotnet-aspnetcore"},
+			{Name: "Microsoft.WindowsDesktop.App", Dependency: "dotnet-windowsdesktop"},
+		}))
+	})
+
+	Context("an override.yml declares shared frameworks", func() {
+		BeforeEach(func() {
+			Expect(os.MkdirAll(filepath.Join(depsDir, "0"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(depsDir, "0", "override.yml"), []byte(`---
+ruby:
+  default_versions: []
+dotnet-core:
+  shared_frameworks:
+  - name: Microsoft.AspNetCore.App
+    dependency: my-aspnetcore
+  - name: Contoso.Platform.App
+    dependency: contoso-platform
+`), 0644)).To(Succeed())
+		})
+
+		It("replaces frameworks by name and appends new ones", func() {
+			extensions, err := config.LoadManifestExtensions(buildpackDir, depsDir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(extensions.AllSharedFrameworks()).To(Equal([]config.SharedFramework{
+				{Name: "Microsoft.NETCore.App", Dependency: "dotnet-runtime"},
+				{Name: "Microsoft.AspNetCore.App", Dependency: "my-aspnetcore"},
+				{Name: "Microsoft.WindowsDesktop.App", Dependency: "dotnet-windowsdesktop"},
+				{Name: "Contoso.Platform.App", Dependency: "contoso-platform"},
+			}))
+		})
+	})
+
+	Describe("SelectArchitecture", func() {
+		BeforeEach(func() {
+			Expect(os.WriteFile(filepath.Join(buildpackDir, "manifest.yml"), []byte(`---
+language: dotnet-core
+dependencies:
+- name: dotnet-sdk
+  version: 8.0.401
+  uri: https://example.com/dotnet-sdk_8.0.401_linux_x64.tar.xz
+  sha256: aaaa
+  cf_stacks: [cflinuxfs4]
+- name: dotnet-sdk
+  version: 8.0.401
+  uri: https://example.com/dotnet-sdk_8.0.401_linux_arm64.tar.xz
+  sha256: bbbb
+  cf_stacks: [cflinuxfs4]
+  arch: arm64
+`), 0644)).To(Succeed())
+
+			Expect(os.MkdirAll(filepath.Join(depsDir, "0"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(depsDir, "0", "override.yml"), []byte(`---
+dotnet-core:
+  dependencies:
+  - name: dotnet-sdk
+    version: 8.0.401
+    uri: https://mirror.example.com/dotnet-sdk_8.0.401_linux_arm64.tar.xz
+    sha256: cccc
+    cf_stacks: [cflinuxfs4]
+    arch: aarch64
+  - name: libunwind
+    version: 1.6.2
+    uri: https://mirror.example.com/libunwind_1.6.2_linux_arm64.tgz
+    sha256: dddd
+    cf_stacks: [cflinuxfs4]
+    arch: arm64
+`), 0644)).To(Succeed())
+		})
+
+		It("keeps the entries built for the architecture, override.yml taking precedence", func() {
+			extensions, err := config.LoadManifestExtensions(buildpackDir, depsDir)
+			Expect(err).NotTo(HaveOccurred())
+
+			manifest := &libbuildpack.Manifest{}
+			extensions.SelectArchitecture(manifest, "arm64")
+			Expect(manifest.ManifestEntries).To(Equal([]libbuildpack.ManifestEntry{
+				{
+					Dependency: libbuildpack.Dependency{Name: "dotnet-sdk", Version: "8.0.401"},
+					URI:        "https://mirror.example.com/dotnet-sdk_8.0.401_linux_arm64.tar.xz",
+					SHA256:     "cccc",
+					CFStacks:   []string{"cflinuxfs4"},
+				},
+				{
+					Dependency: libbuildpack.Dependency{Name: "libunwind", Version: "1.6.2"},
+					URI:        "https://mirror.example.com/libunwind_1.6.2_linux_arm64.tgz",
+					SHA256:     "dddd",
+					CFStacks:   []string{"cflinuxfs4"},
+				},
+			}))
+		})
+
+		It("treats entries without arch as x64", func() {
+			extensions, err := config.LoadManifestExtensions(buildpackDir, depsDir)
+			Expect(err).NotTo(HaveOccurred())
+
+			manifest := &libbuildpack.Manifest{}
+			extensions.SelectArchitecture(manifest, "x64")
+			Expect(manifest.ManifestEntries).To(HaveLen(1))
+			Expect(manifest.ManifestEntries[0].URI).To(Equal("https://example.com/dotnet-sdk_8.0.401_linux_x64.tar.xz"))
+		})
+	})
+
+	Describe("Stack", func() {
+		BeforeEach(func() {
+			Expect(os.WriteFile(filepath.Join(buildpackDir, "manifest.yml"), []byte(`---
+language: dotnet-core
+stacks:
+- name: cflinuxfs3
+  runtime_os: linux
+- name: cflinuxfs4
+  runtime_os: linux
+  legacy_openssl_provider: true
+`), 0644)).To(Succeed())
+		})
+
+		It("returns the declared stack", func() {
+			extensions, err := config.LoadManifestExtensions(buildpackDir, depsDir)
+			Expect(err).NotTo(HaveOccurred())
+
+			stack, err := extensions.Stack("cflinuxfs4")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stack).To(Equal(config.Stack{Name: "cflinuxfs4", RuntimeOS: "linux", LegacyOpenSSLProvider: true}))
+			Expect(stack.RuntimeIdentifier("arm64")).To(Equal("linux-arm64"))
+		})
+
+		It("fails for an undeclared stack", func() {
+			extensions, err := config.LoadManifestExtensions(buildpackDir, depsDir)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = extensions.Stack("cflinuxfs5")
+			Expect(err).To(MatchError(`unsupported stack "cflinuxfs5", supported stacks are: cflinuxfs3, cflinuxfs4; declare it under stacks in override.yml to use it`))
+		})
+
+		Context("an override.yml declares a new stack", func() {
+			BeforeEach(func() {
+				Expect(os.MkdirAll(filepath.Join(depsDir, "0"), 0755)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(depsDir, "0", "override.yml"), []byte(`---
+dotnet-core:
+  stacks:
+  - name: cflinuxfs5
+    runtime_os: linux
+    legacy_openssl_provider: true
+`), 0644)).To(Succeed())
+			})
+
+			It("supports it", func() {
+				extensions, err := config.LoadManifestExtensions(buildpackDir, depsDir)
+				Expect(err).NotTo(HaveOccurred())
+
+				stack, err := extensions.Stack("cflinuxfs5")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(stack.LegacyOpenSSLProvider).To(BeTrue())
+			})
+		})
+	})
+
+	Context("an override.yml sets the EOL policy", func() {
+		BeforeEach(func() {
+			Expect(os.MkdirAll(filepath.Join(depsDir, "0"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(depsDir, "0", "override.yml"), []byte(`---
+dotnet-core:
+  eol_policy:
+    action: fail-after
+    days: 90
+`), 0644)).To(Succeed())
+		})
+
+		It("replaces the one in manifest.yml", func() {
+			extensions, err := config.LoadManifestExtensions(buildpackDir, depsDir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(extensions.EOLPolicy).To(Equal(config.EOLPolicy{Action: "fail-after", Days: 90}))
+		})
+	})
+})
@@ -0,0 +1,61 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+)
+
+// Resolution records how staging picked the version of a dependency: the
+// constraint that was requested, the file or rule it came from, the
+// roll-forward steps that were tried and the version they ended on
+type Resolution struct {
+	Name       string   `json:"name" yaml:"name"`
+	Constraint string   `json:"constraint,omitempty" yaml:"constraint,omitempty"`
+	Source     string   `json:"source" yaml:"source"`
+	Steps      []string `json:"steps,omitempty" yaml:"steps,omitempty"`
+	Version    string   `json:"version" yaml:"version"`
+}
+
+// RecordResolution remembers how a dependency version was picked, once per
+// name and version. It returns the resolution as recorded, with the version a
+// lock held it at.
+func (c *Config) RecordResolution(resolution Resolution) Resolution {
+	for _, held := range c.held {
+		if held.name == resolution.Name && held.resolved == resolution.Version {
+			resolution.Steps = append(append([]string{}, resolution.Steps...), fmt.Sprintf("locked: %s, %s held back", held.locked, held.resolved))
+			resolution.Version = held.locked
+		}
+	}
+
+	for i, existing := range c.Resolutions {
+		if existing.Name == resolution.Name && existing.Version == resolution.Version {
+			c.Resolutions[i] = resolution
+			return resolution
+		}
+	}
+	c.Resolutions = append(c.Resolutions, resolution)
+	return resolution
+}
+
+// ResolutionTable renders resolutions as aligned lines for the staging log
+func ResolutionTable(resolutions []Resolution) []string {
+	buffer := &bytes.Buffer{}
+	writer := tabwriter.NewWriter(buffer, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "DEPENDENCY\tVERSION\tREQUESTED\tSOURCE\tROLL-FORWARD")
+	for _, resolution := range resolutions {
+		constraint := resolution.Constraint
+		if constraint == "" {
+			constraint = "-"
+		}
+		steps := strings.Join(resolution.Steps, ", ")
+		if steps == "" {
+			steps = "-"
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", resolution.Name, resolution.Version, constraint, resolution.Source, steps)
+	}
+	writer.Flush()
+
+	return strings.Split(strings.TrimSuffix(buffer.String(), "\n"), "\n")
+}
@@ -0,0 +1,32 @@
+package config_test
+
+import (
+	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/config"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Resolution", func() {
+	It("records a dependency version once", func() {
+		cfg := &config.Config{}
+		cfg.RecordResolution(config.Resolution{Name: "dotnet-runtime", Version: "8.0.8", Source: "manifest"})
+		cfg.RecordResolution(config.Resolution{Name: "dotnet-runtime", Version: "8.0.8", Source: "app.runtimeconfig.json"})
+		cfg.RecordResolution(config.Resolution{Name: "dotnet-runtime", Version: "6.0.33", Source: "app.runtimeconfig.json"})
+
+		Expect(cfg.Resolutions).To(Equal([]config.Resolution{
+			{Name: "dotnet-runtime", Version: "8.0.8", Source: "app.runtimeconfig.json"},
+			{Name: "dotnet-runtime", Version: "6.0.33", Source: "app.runtimeconfig.json"},
+		}))
+	})
+
+	It("renders an aligned table", func() {
+		Expect(config.ResolutionTable([]config.Resolution{
+			{Name: "dotnet-sdk", Source: "manifest default", Version: "8.0.401"},
+			{Name: "dotnet-runtime", Constraint: "net8.0", Source: "src/Web/Web.csproj", Steps: []string{"8.0.x: no match", "8.x.x: 8.1.2"}, Version: "8.1.2"},
+		})).To(Equal([]string{
+			"DEPENDENCY      VERSION  REQUESTED  SOURCE              ROLL-FORWARD",
+			"dotnet-sdk      8.0.401  -          manifest default    -",
+			"dotnet-runtime  8.1.2    net8.0     src/Web/Web.csproj  8.0.x: no match, 8.x.x: 8.1.2",
+		}))
+	})
+})
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/config"
+	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/project"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+func main() {
+	// stdout is reserved for the detect result, reasons go to stderr and are
+	// only shown with BP_DEBUG
+	logger := libbuildpack.NewLogger(os.Stderr)
+
+	if len(os.Args) < 2 {
+		logger.Error("Usage: detect <build-dir>")
+		os.Exit(2)
+	}
+	buildDir := os.Args[1]
+
+	buildpackDir, err := libbuildpack.GetBuildpackDir()
+	if err != nil {
+		logger.Error("Unable to determine buildpack directory: %s", err.Error())
+		os.Exit(9)
+	}
+
+	version, err := os.ReadFile(filepath.Join(buildpackDir, "VERSION"))
+	if err != nil {
+		logger.Error("Unable to read buildpack version: %s", err.Error())
+		os.Exit(10)
+	}
+
+	buildpackYAML, err := config.LoadBuildpackYAML(buildDir)
+	if err != nil {
+		logger.Error("Unable to parse buildpack.yml: %s", err.Error())
+		os.Exit(11)
+	}
+
+	dotnetProject := project.New(buildDir, "", "", nil, nil, logger)
+	dotnetProject.BuildpackYAML = buildpackYAML
+	dotnetProject.Environ = os.Environ()
+
+	detection, err := dotnetProject.Detect()
+	if err != nil {
+		logger.Error("Unable to detect the app: %s", err.Error())
+		os.Exit(1)
+	}
+
+	for _, reason := range detection.Reasons {
+		logger.Debug("Detect: %s", reason)
+	}
+
+	if !detection.Detected {
+		fmt.Println("no")
+		os.Exit(1)
+	}
+	fmt.Printf("ASP.NET Core (buildpack-%s)\n", strings.TrimSpace(string(version)))
+}
@@ -57,19 +57,71 @@ func main() {
 		os.Exit(15)
 	}
 
-	installer := libbuildpack.NewInstaller(manifest)
+	manifestExtensions, err := config.LoadManifestExtensions(buildpackDir, stager.DepsDir())
+	if err != nil {
+		logger.Error("Unable to load manifest.yml and override.yml extensions: %s", err.Error())
+		os.Exit(18)
+	}
+	arch := config.Architecture()
+	manifestExtensions.SelectArchitecture(manifest, arch)
+
+	stack, err := manifestExtensions.Stack(os.Getenv("CF_STACK"))
+	if err != nil {
+		logger.Error("%s", err.Error())
+		os.Exit(19)
+	}
+
+	buildpackYAML, err := config.LoadBuildpackYAML(stager.BuildDir())
+	if err != nil {
+		logger.Error("Unable to parse buildpack.yml: %s", err.Error())
+		os.Exit(24)
+	}
+
+	installer := config.NewInstaller(libbuildpack.NewInstaller(manifest), manifest, &configYml.Config, arch)
+	installer.EOL, err = config.NewEOLEnforcer(manifestExtensions.EOLPolicy, manifest, buildpackYAML, logger, time.Now())
+	if err != nil {
+		logger.Error("Unable to load the EOL policy: %s", err.Error())
+		os.Exit(21)
+	}
+	if installer.Lock, err = config.LoadLock(stager.BuildDir(), stager.CacheDir(), buildpackYAML, logger); err != nil {
+		logger.Error("Unable to load %s: %s", config.LockFile, err.Error())
+		os.Exit(22)
+	}
+
+	dotnetProject := project.New(stager.BuildDir(), stager.DepDir(), stager.DepsIdx(), manifest, installer, logger)
+	dotnetProject.SharedFrameworks = manifestExtensions.AllSharedFrameworks()
+	dotnetProject.BuildpackYAML = buildpackYAML
+	dotnetProject.Environ = os.Environ()
+
 	f := finalize.Finalizer{
-		Stager:  stager,
-		Log:     logger,
-		Command: &libbuildpack.Command{},
-		Config:  &configYml.Config,
-		Project: project.New(stager.BuildDir(), stager.DepDir(), stager.DepsIdx(), manifest, installer, logger),
+		Stager:   stager,
+		Log:      logger,
+		Command:  &libbuildpack.Command{},
+		Config:   &configYml.Config,
+		Project:  dotnetProject,
+		Manifest: manifest,
+		Arch:     arch,
+		Stack:    stack,
+
+		BuildpackYAML: buildpackYAML,
 	}
 
 	if err := finalize.Run(&f); err != nil {
 		os.Exit(12)
 	}
 
+	if err := stager.WriteConfigYml(&configYml.Config); err != nil {
+		logger.Error("Error writing config.yml: %s", err.Error())
+		os.Exit(20)
+	}
+
+	if installer.Lock != nil {
+		if err := installer.Lock.Write(stager.CacheDir(), configYml.Config.InstalledDependencies); err != nil {
+			logger.Error("Unable to write %s to the app cache: %s", config.LockFile, err.Error())
+			os.Exit(23)
+		}
+	}
+
 	if err := libbuildpack.RunAfterCompile(stager); err != nil {
 		logger.Error("After Compile: %s", err.Error())
 		os.Exit(13)
@@ -6,19 +6,17 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/config"
+	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/nuget"
 	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/project"
+	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/sbom"
 	"github.com/cloudfoundry/libbuildpack"
 	"github.com/kr/text"
 )
 
-var stackToRuntimeRID = map[string]string{
-	"cflinuxfs3": "linux-x64",
-	"cflinuxfs4": "linux-x64",
-}
-
 type Project interface {
 	IsPublished() (bool, error)
 	StartCommand() (string, error)
@@ -30,6 +28,7 @@ type Project interface {
 
 type Stager interface {
 	BuildDir() string
+	CacheDir() string
 	DepsIdx() string
 	DepDir() string
 	WriteProfileD(string, string) error
@@ -40,11 +39,16 @@ type Command interface {
 }
 
 type Finalizer struct {
-	Stager  Stager
-	Log     *libbuildpack.Logger
-	Command Command
-	Config  *config.Config
-	Project *project.Project
+	Stager   Stager
+	Log      *libbuildpack.Logger
+	Command  Command
+	Config   *config.Config
+	Project  *project.Project
+	Manifest sbom.Manifest
+	Arch     string
+	Stack    config.Stack
+
+	BuildpackYAML config.BuildpackYAML
 }
 
 func Run(f *Finalizer) error {
@@ -59,23 +63,53 @@ func Run(f *Finalizer) error {
 		return err
 	}
 
-	stack := os.Getenv("CF_STACK")
-	stackRID := stackToRuntimeRID[stack]
-	if stackRID == "" {
-		f.Log.Error("Unsupported stack: %s", stack)
+	if f.Stack.RuntimeOS == "" {
+		err := fmt.Errorf("unsupported stack: %s", os.Getenv("CF_STACK"))
+		f.Log.Error("%s", err.Error())
+		return err
+	}
+	stackRID := f.Stack.RuntimeIdentifier(f.Arch)
+
+	isNativeAot, err := f.Project.IsNativeAot()
+	if err != nil {
 		return err
 	}
 
 	if isSourceBased {
-		if err := f.Project.SourceInstallDotnetRuntime(); err != nil {
+		if isNativeAot {
+			f.Log.Info("Skipping dotnet-runtime, the app is published with Native AOT")
+		} else if err := f.Project.SourceInstallDotnetRuntime(); err != nil {
 			f.Log.Error("Unable to install dotnet-runtime: %s", err.Error())
 			return err
 		}
 
+		nugetCacheKey, err := f.RestoreNugetCache()
+		if err != nil {
+			f.Log.Error("Unable to restore NuGet cache: %s", err.Error())
+			return err
+		}
+
+		if err := f.RunTests(); err != nil {
+			f.Log.Error("Unable to run dotnet test: %s", err.Error())
+			return err
+		}
+
 		if err := f.DotnetPublish(stackRID); err != nil {
 			f.Log.Error("Unable to run dotnet publish: %s", err.Error())
 			return err
 		}
+
+		if err := f.SaveNugetCache(nugetCacheKey); err != nil {
+			f.Log.Error("Unable to save NuGet cache: %s", err.Error())
+			return err
+		}
+
+		if f.frameworkDependent() && !isNativeAot {
+			if err := f.PruneDotnetInstall(); err != nil {
+				f.Log.Error("Unable to remove unused parts of the dotnet installation: %s", err.Error())
+				return err
+			}
+		}
 	}
 
 	if isFrameworkDependent {
@@ -85,11 +119,18 @@ func Run(f *Finalizer) error {
 		}
 	}
 
+	f.LogResolutions()
+
 	if err := f.CleanStagingArea(); err != nil {
 		f.Log.Error("Unable to run CleanStagingArea: %s", err.Error())
 		return err
 	}
 
+	if err := f.WriteSBOM(); err != nil {
+		f.Log.Error("Unable to write the SBOM: %s", err.Error())
+		return err
+	}
+
 	if err := f.WriteProfileD(); err != nil {
 		f.Log.Error("Unable to write profile.d: %s", err.Error())
 		return err
@@ -104,6 +145,23 @@ func Run(f *Finalizer) error {
 	return libbuildpack.NewYAML().Write(releasePath, data)
 }
 
+// LogResolutions records how the version of every dependency finalize
+// installed was picked in the config and prints it
+func (f *Finalizer) LogResolutions() {
+	resolutions := f.Project.Resolutions()
+	if len(resolutions) == 0 {
+		return
+	}
+
+	f.Log.BeginStep("Dependency versions")
+	for _, resolution := range resolutions {
+		f.Config.RecordResolution(resolution)
+	}
+	for _, line := range config.ResolutionTable(resolutions) {
+		f.Log.Info("%s", line)
+	}
+}
+
 func (f *Finalizer) CleanStagingArea() error {
 	f.Log.BeginStep("Cleaning staging area")
 
@@ -114,16 +172,21 @@ func (f *Finalizer) CleanStagingArea() error {
 		return err
 	}
 
-	startCmd, err := f.Project.StartCommand()
+	runsDll, err := f.runsDll()
 	if err != nil {
 		return err
 	}
 
-	if !(isFDD || strings.HasSuffix(startCmd, ".dll")) {
+	isNativeAot, err := f.Project.IsNativeAot()
+	if err != nil {
+		return err
+	}
+
+	if !(isFDD || (f.frameworkDependent() && !isNativeAot) || runsDll) {
 		dirsToRemove = append(dirsToRemove, "dotnet-sdk")
 	}
 
-	if os.Getenv("INSTALL_NODE") != "true" {
+	if !f.keepNode() {
 		dirsToRemove = append(dirsToRemove, "node")
 	}
 
@@ -143,6 +206,30 @@ func (f *Finalizer) CleanStagingArea() error {
 	return nil
 }
 
+// runsDll reports whether the app or any of its processes is started
+// through dotnet, which then has to stay in the droplet
+func (f *Finalizer) runsDll() (bool, error) {
+	processes, err := f.Project.Processes()
+	if err != nil {
+		return false, err
+	}
+
+	if len(processes) == 0 {
+		startCmd, err := f.Project.StartCommand()
+		return strings.HasSuffix(startCmd, ".dll"), err
+	}
+
+	for _, process := range processes {
+		startCmd, err := f.Project.ProcessStartCommand(process)
+		if err != nil {
+			return false, err
+		} else if strings.HasSuffix(startCmd, ".dll") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 func (f *Finalizer) removeSymlinksTo(dir string) error {
 	for _, name := range []string{"bin", "lib"} {
 		files, err := os.ReadDir(filepath.Join(f.Stager.DepDir(), name))
@@ -181,19 +268,200 @@ export DOTNET_ROOT=%s
 	return f.Stager.WriteProfileD("startup.sh", scriptContents)
 }
 
+// WriteSBOM writes a CycloneDX SBOM of the dependencies left in the droplet
+// and the NuGet packages and runtime packs of the app, and echoes it to the
+// staging log with BP_DEBUG. It runs after CleanStagingArea, so that
+// dependencies only needed for the build are not listed.
+func (f *Finalizer) WriteSBOM() error {
+	libraryFiles, err := f.sbomLibraryFiles()
+	if err != nil {
+		return err
+	}
+
+	var installed []config.Dependency
+	for _, dep := range f.Config.InstalledDependencies {
+		if found, err := libbuildpack.FileExists(filepath.Join(f.Stager.DepDir(), dependencyDir(dep.Name))); err != nil {
+			return err
+		} else if found {
+			installed = append(installed, dep)
+		}
+	}
+
+	bom, err := sbom.New(f.Manifest, installed, libraryFiles)
+	if err != nil {
+		return err
+	}
+
+	data, err := bom.JSON()
+	if err != nil {
+		return err
+	}
+
+	sbomPath := filepath.Join(f.Stager.DepDir(), "sbom.cdx.json")
+	if err := os.WriteFile(sbomPath, data, 0644); err != nil {
+		return err
+	}
+
+	f.Log.BeginStep("Wrote SBOM with %d components to %s", len(bom.Components), filepath.Join("/home", "vcap", "deps", f.Stager.DepsIdx(), "sbom.cdx.json"))
+	f.Log.Debug("SBOM:\n%s", strings.TrimSpace(string(data)))
+	return nil
+}
+
+// dependencyDir is the directory of the dep dir a dependency is installed
+// into: the runtimes share the one of the SDK, bower is installed with npm
+func dependencyDir(name string) string {
+	switch {
+	case strings.HasPrefix(name, "dotnet-"):
+		return "dotnet-sdk"
+	case name == "bower":
+		return "node"
+	default:
+		return name
+	}
+}
+
+// sbomLibraryFiles returns the deps.json files of the published app, falling
+// back to the project.assets.json files written by dotnet restore
+func (f *Finalizer) sbomLibraryFiles() ([]string, error) {
+	var patterns []string
+	if published, err := f.Project.IsPublished(); err != nil {
+		return nil, err
+	} else if published {
+		publishedDir, err := f.Project.PublishedDir()
+		if err != nil {
+			return nil, err
+		}
+		patterns = []string{filepath.Join(publishedDir, "*.deps.json")}
+	} else {
+		patterns = []string{
+			filepath.Join(f.Stager.DepDir(), "dotnet_publish", "*.deps.json"),
+			filepath.Join(f.Stager.DepDir(), "dotnet_publish", "*", "*.deps.json"),
+		}
+	}
+
+	var files []string
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	if len(files) > 0 {
+		return files, nil
+	}
+
+	err := filepath.Walk(f.Stager.BuildDir(), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && (info.Name() == "node_modules" || info.Name() == ".git") {
+			return filepath.SkipDir
+		}
+		if !info.IsDir() && info.Name() == "project.assets.json" && filepath.Base(filepath.Dir(path)) == "obj" {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// Placeholders process commands in the Procfile and buildpack.yml may use
+const (
+	publishDirPlaceholder    = "{publish_dir}"
+	entryAssemblyPlaceholder = "{entry_assembly}"
+)
+
 func (f *Finalizer) GenerateReleaseYaml() (map[string]map[string]string, error) {
-	startCmd, err := f.Project.StartCommand()
+	processTypes, err := f.processTypes(f.Project.StartCommand, func(process project.Process) (string, error) {
+		startCmd, err := f.Project.ProcessStartCommand(process)
+		if err == nil && startCmd == "" {
+			err = fmt.Errorf("could not find the published entry point of process %s in %s", process.Name, f.relativePath(process.ProjectPath))
+		}
+		return startCmd, err
+	})
 	if err != nil {
 		return nil, err
 	}
-	directory := filepath.Dir(startCmd)
-	startCmd = "./" + filepath.Base(startCmd)
-	if strings.HasSuffix(startCmd, ".dll") {
-		startCmd = "dotnet " + startCmd
+	return map[string]map[string]string{"default_process_types": processTypes}, nil
+}
+
+// processTypes computes the command of every process type: the web process
+// of the main project or one per process project, each running its
+// published entry point unless buildpack.yml gives it a command, merged with
+// the commands of the Procfile and of processes without a project, whose
+// placeholders refer to the main project
+func (f *Finalizer) processTypes(startCommand func() (string, error), processStartCommand func(project.Process) (string, error)) (map[string]string, error) {
+	processes, err := f.Project.Processes()
+	if err != nil {
+		return nil, err
 	}
-	return map[string]map[string]string{
-		"default_process_types": {"web": fmt.Sprintf("cd %s && exec %s", directory, startCmd)},
-	}, nil
+
+	processTypes := map[string]string{}
+	if len(processes) == 0 {
+		startCmd, err := startCommand()
+		if err != nil {
+			return nil, err
+		}
+		processTypes["web"] = processCommand(startCmd)
+	}
+
+	for _, process := range processes {
+		startCmd, err := processStartCommand(process)
+		if err != nil {
+			return nil, err
+		}
+		if process.Command != "" {
+			processTypes[process.Name] = expandCommand(process.Command, startCmd)
+		} else {
+			processTypes[process.Name] = processCommand(startCmd)
+		}
+	}
+
+	commands, err := f.Project.ProcessCommands()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var mainStartCmd string
+	for _, name := range names {
+		command := commands[name]
+		if usesPlaceholders(command) && mainStartCmd == "" {
+			if mainStartCmd, err = startCommand(); err != nil {
+				return nil, err
+			} else if mainStartCmd == "" {
+				return nil, fmt.Errorf("process %s uses %s or %s, but the app has no published entry point", name, publishDirPlaceholder, entryAssemblyPlaceholder)
+			}
+		}
+		processTypes[name] = expandCommand(command, mainStartCmd)
+	}
+	return processTypes, nil
+}
+
+// processCommand turns the path of a published executable or dll into a
+// command that runs it from its own directory
+func processCommand(startCmd string) string {
+	return expandCommand(fmt.Sprintf("cd %s && exec %s", publishDirPlaceholder, entryAssemblyPlaceholder), startCmd)
+}
+
+// expandCommand replaces {publish_dir} in a process command with the
+// directory of a published executable or dll, and {entry_assembly} with what
+// runs it from that directory
+func expandCommand(command, startCmd string) string {
+	entryAssembly := "./" + filepath.Base(startCmd)
+	if strings.HasSuffix(entryAssembly, ".dll") {
+		entryAssembly = "dotnet " + entryAssembly
+	}
+	return strings.NewReplacer(publishDirPlaceholder, filepath.Dir(startCmd), entryAssemblyPlaceholder, entryAssembly).Replace(command)
+}
+
+func usesPlaceholders(command string) bool {
+	return strings.Contains(command, publishDirPlaceholder) || strings.Contains(command, entryAssemblyPlaceholder)
 }
 
 func (f *Finalizer) DotnetPublish(stackRID string) error {
@@ -205,52 +473,212 @@ func (f *Finalizer) DotnetPublish(stackRID string) error {
 
 	f.Log.BeginStep("Publish dotnet")
 
-	mainProject, err := f.Project.MainPath()
+	processes, err := f.Project.Processes()
 	if err != nil {
 		return err
 	}
 
-	env := f.shellEnvironment()
-	env = append(env, "PATH="+filepath.Join(filepath.Dir(mainProject), "node_modules", ".bin")+":"+os.Getenv("PATH"))
+	if len(processes) == 0 {
+		mainProject, err := f.Project.MainPath()
+		if err != nil {
+			return err
+		}
+		return f.publishProject(mainProject, filepath.Join(f.Stager.DepDir(), "dotnet_publish"), stackRID)
+	}
+
+	for _, process := range processes {
+		f.Log.Info("Publishing %s for process %s", f.relativePath(process.ProjectPath), process.Name)
+		if err := f.publishProject(process.ProjectPath, f.Project.ProcessPublishDir(process), stackRID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *Finalizer) publishProject(projectPath, publishPath, stackRID string) error {
+	env, err := f.shellEnvironment()
+	if err != nil {
+		return err
+	}
+	env = append(env, "PATH="+filepath.Join(filepath.Dir(projectPath), "node_modules", ".bin")+":"+os.Getenv("PATH"))
 
-	publishPath := filepath.Join(f.Stager.DepDir(), "dotnet_publish")
 	if err := os.MkdirAll(publishPath, 0755); err != nil {
 		return err
 	}
-	args := []string{"publish", mainProject, "-o", publishPath, "-c", f.publicConfig(), "--self-contained"}
-	args = append(args, "-r", stackRID)
+
+	args, err := f.publishArgs(projectPath, publishPath, stackRID)
+	if err != nil {
+		return err
+	}
+
+	warnings := &trimWarnings{}
 	cmd := exec.Command("dotnet", args...)
 	cmd.Dir = f.Stager.BuildDir()
 	cmd.Env = env
-	cmd.Stdout = indentWriter(os.Stdout)
+	cmd.Stdout = io.MultiWriter(indentWriter(os.Stdout), warnings)
 	cmd.Stderr = indentWriter(os.Stderr)
 
 	f.Log.Debug("Running command: %v", cmd)
-	if err := f.Command.Run(cmd); err != nil {
+	err = f.Command.Run(cmd)
+	warnings.Report(f.Log)
+	return err
+}
+
+// publishArgs are the arguments to dotnet that publish a project into
+// publishPath
+func (f *Finalizer) publishArgs(projectPath, publishPath, stackRID string) ([]string, error) {
+	configuration := f.publicConfig()
+	frameworkDependent := f.frameworkDependent()
+
+	targetFramework, err := f.Project.PublishTargetFramework(projectPath)
+	if err != nil {
+		return nil, err
+	}
+
+	aot, err := f.Project.PublishesAot(projectPath)
+	if err != nil {
+		return nil, err
+	} else if aot && frameworkDependent {
+		f.Log.Warning("%s is published with Native AOT, ignoring framework-dependent", f.relativePath(projectPath))
+		frameworkDependent = false
+	}
+
+	flags, err := f.BuildpackYAML.PublishFlagArgs()
+	if err != nil {
+		return nil, err
+	}
+	properties := f.BuildpackYAML.PublishPropertyArgs()
+
+	overrides, err := f.publishFlagOverrides(append(append([]string{}, properties...), flags...))
+	if err != nil {
+		return nil, err
+	}
+	if selfContained, overridden := overrides["--self-contained"]; overridden {
+		frameworkDependent = selfContained == "false"
+	}
+
+	optimizations, err := f.BuildpackYAML.PublishOptimizations()
+	if err != nil {
+		return nil, err
+	} else if optimizations.Trimmed && frameworkDependent {
+		return nil, fmt.Errorf("trimming requires a self-contained publish and cannot be combined with framework-dependent")
+	}
+
+	args := []string{"publish", projectPath, "-o", publishPath, "-c", configuration}
+	if targetFramework != "" {
+		args = append(args, "-f", targetFramework)
+	}
+	if _, overridden := overrides["--self-contained"]; !overridden {
+		if frameworkDependent {
+			args = append(args, "--self-contained", "false")
+		} else {
+			args = append(args, "--self-contained")
+		}
+	}
+	if _, overridden := overrides["--runtime"]; !overridden {
+		args = append(args, "-r", stackRID)
+	}
+	args = append(args, optimizations.Args()...)
+	args = append(args, properties...)
+	return append(args, flags...), nil
+}
+
+func (f *Finalizer) relativePath(path string) string {
+	if relPath, err := filepath.Rel(f.Stager.BuildDir(), path); err == nil {
+		return relPath
+	}
+	return path
+}
+
+func (f *Finalizer) publicConfig() string {
+	return f.BuildpackYAML.PublishConfiguration()
+}
+
+// PruneDotnetInstall strips the dotnet-sdk dir down to the host and the
+// shared frameworks that a framework-dependent publish output references.
+func (f *Finalizer) PruneDotnetInstall() error {
+	frameworks, err := f.Project.PublishedFrameworks()
+	if err != nil {
+		return err
+	}
+
+	optimizations, err := f.BuildpackYAML.PublishOptimizations()
+	if err != nil {
 		return err
 	}
 
+	// Every shared framework is built on top of Microsoft.NETCore.App
+	needed := map[string]bool{"Microsoft.NETCore.App": true}
+	for _, fw := range frameworks {
+		needed[fw.Name] = true
+	}
+
+	dotnetRoot := filepath.Join(f.Stager.DepDir(), "dotnet-sdk")
+	sharedFrameworks, err := os.ReadDir(filepath.Join(dotnetRoot, "shared"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for _, fw := range sharedFrameworks {
+		// A single-file bundle embeds its runtimeconfig.json, so the
+		// frameworks it needs are unknown
+		if needed[fw.Name()] || optimizations.SingleFile {
+			continue
+		}
+		f.Log.Info("Removing unused shared framework %s", fw.Name())
+		if err := os.RemoveAll(filepath.Join(dotnetRoot, "shared", fw.Name())); err != nil {
+			return err
+		}
+	}
+
+	for _, dir := range []string{"sdk", "sdk-manifests", "packs", "templates", "library-packs"} {
+		if err := os.RemoveAll(filepath.Join(dotnetRoot, dir)); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (f *Finalizer) publicConfig() string {
-	if os.Getenv("PUBLISH_RELEASE_CONFIG") == "true" {
-		return "Release"
+// frameworkDependent reports whether source-based apps are published
+// against the shared runtime, set through buildpack.yml or
+// BP_DOTNET_FRAMEWORK_DEPENDENT.
+func (f *Finalizer) frameworkDependent() bool {
+	if f.BuildpackYAML.FrameworkDependent != nil {
+		return *f.BuildpackYAML.FrameworkDependent
+	}
+
+	return os.Getenv("BP_DOTNET_FRAMEWORK_DEPENDENT") == "true"
+}
+
+func (f *Finalizer) keepNode() bool {
+	if f.BuildpackYAML.KeepNode != nil {
+		return *f.BuildpackYAML.KeepNode
 	}
 
-	return "Debug"
+	return os.Getenv("INSTALL_NODE") == "true"
 }
 
-func (f *Finalizer) shellEnvironment() []string {
+func (f *Finalizer) shellEnvironment() ([]string, error) {
+	feeds, err := nuget.FeedsFromBindings()
+	if err != nil {
+		return nil, err
+	}
+
 	env := os.Environ()
 	for _, v := range []string{
 		"DOTNET_SKIP_FIRST_TIME_EXPERIENCE=true",
 		"DefaultItemExcludes=.cloudfoundry/**/*.*",
 		"HOME=" + f.Stager.DepDir(),
+		"NUGET_PACKAGES=" + filepath.Join(f.nugetDir(), "packages"),
+		"NUGET_HTTP_CACHE_PATH=" + filepath.Join(f.nugetDir(), "http-cache"),
 	} {
 		env = append(env, v)
 	}
-	return env
+	credentials, err := nuget.CredentialEnv(feeds)
+	if err != nil {
+		return nil, err
+	}
+	return append(env, credentials...), nil
 }
 
 func indentWriter(writer io.Writer) io.Writer {
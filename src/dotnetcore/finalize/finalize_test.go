@@ -2,11 +2,14 @@ package finalize_test
 
 import (
 	"bytes"
+	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
 
 	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/config"
 	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/finalize"
+	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/plan"
 	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/project"
 	"github.com/cloudfoundry/libbuildpack"
 	"github.com/cloudfoundry/libbuildpack/ansicleaner"
@@ -21,6 +24,7 @@ var _ = Describe("Finalize", func() {
 	var (
 		err         error
 		buildDir    string
+		cacheDir    string
 		depsDir     string
 		depsIdx     string
 		finalizer   *finalize.Finalizer
@@ -35,6 +39,9 @@ var _ = Describe("Finalize", func() {
 		buildDir, err = os.MkdirTemp("", "dotnet-core-buildpack.build.")
 		Expect(err).To(BeNil())
 
+		cacheDir, err = os.MkdirTemp("", "dotnet-core-buildpack.cache.")
+		Expect(err).To(BeNil())
+
 		depsDir, err = os.MkdirTemp("", "dotnet-core-buildpack.deps.")
 		Expect(err).To(BeNil())
 
@@ -48,10 +55,10 @@ var _ = Describe("Finalize", func() {
 		mockCtrl = gomock.NewController(GinkgoT())
 		mockCommand = NewMockCommand(mockCtrl)
 
-		args := []string{buildDir, "", depsDir, depsIdx}
+		args := []string{buildDir, cacheDir, depsDir, depsIdx}
 		stager := libbuildpack.NewStager(args, logger, &libbuildpack.Manifest{})
 		project := project.New(stager.BuildDir(), filepath.Join(depsDir, depsIdx), depsIdx, &libbuildpack.Manifest{}, libbuildpack.NewInstaller(&libbuildpack.Manifest{}), logger)
-		cfg := &config.Config{}
+		cfg := &config.Config{DotnetSdkVersion: "8.0.401"}
 
 		finalizer = &finalize.Finalizer{
 			Stager:  stager,
@@ -64,16 +71,38 @@ var _ = Describe("Finalize", func() {
 		stackRID = "linux-x64"
 	})
 
+	// writeBuildpackYAML writes buildpack.yml and hands it to the finalizer
+	// and its project parsed, the way the finalize CLI does
+	writeBuildpackYAML := func(content string) {
+		Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte(content), 0644)).To(Succeed())
+		buildpackYAML, err := config.LoadBuildpackYAML(buildDir)
+		Expect(err).NotTo(HaveOccurred())
+		finalizer.BuildpackYAML = buildpackYAML
+		finalizer.Project.BuildpackYAML = buildpackYAML
+	}
+
 	AfterEach(func() {
 		mockCtrl.Finish()
 
 		err = os.RemoveAll(buildDir)
 		Expect(err).To(BeNil())
 
+		err = os.RemoveAll(cacheDir)
+		Expect(err).To(BeNil())
+
 		err = os.RemoveAll(depsDir)
 		Expect(err).To(BeNil())
 	})
 
+	Describe("Run", func() {
+		It("fails on a stack the manifest does not declare", func() {
+			Expect(os.Setenv("CF_STACK", "cflinuxfs5")).To(Succeed())
+			defer os.Unsetenv("CF_STACK")
+
+			Expect(finalize.Run(finalizer)).To(MatchError("unsupported stack: cflinuxfs5"))
+		})
+	})
+
 	Describe("DotnetPublish", func() {
 		Context("The project is already published", func() {
 			BeforeEach(func() {
@@ -88,6 +117,293 @@ var _ = Describe("Finalize", func() {
 				mockCommand.EXPECT().Run(gomock.Any())
 				Expect(finalizer.DotnetPublish(stackRID)).To(Succeed())
 			})
+
+			Context("a NuGet feed is bound to the app", func() {
+				BeforeEach(func() {
+					Expect(os.Setenv("VCAP_SERVICES", `{"nuget": [{"name": "private-feed", "label": "nuget", "credentials": {"url": "https://nuget.example.com/v3/index.json", "username": "bob", "password": "secret"}}]}`)).To(Succeed())
+				})
+
+				AfterEach(func() {
+					Expect(os.Unsetenv("VCAP_SERVICES")).To(Succeed())
+				})
+
+				It("passes the credentials to dotnet publish through the environment only", func() {
+					mockCommand.EXPECT().Run(gomock.Any()).Do(func(cmd *exec.Cmd) {
+						Expect(cmd.Env).To(ContainElement("NuGetPackageSourceCredentials_cf_private_feed=Username=bob;Password=secret"))
+						Expect(cmd.Args).NotTo(ContainElement(ContainSubstring("secret")))
+					})
+					Expect(finalizer.DotnetPublish(stackRID)).To(Succeed())
+					Expect(buffer.String()).NotTo(ContainSubstring("secret"))
+				})
+			})
+
+			Context("framework-dependent publishing is enabled", func() {
+				BeforeEach(func() {
+					Expect(os.WriteFile(filepath.Join(buildDir, "test_app.csproj"), []byte("<Project />"), 0644)).To(Succeed())
+					writeBuildpackYAML("dotnet-core:\n  framework-dependent: true\n")
+				})
+
+				It("publishes without a private runtime", func() {
+					mockCommand.EXPECT().Run(gomock.Any()).Do(func(cmd *exec.Cmd) {
+						Expect(cmd.Args).To(ContainElements("--self-contained", "false"))
+					})
+					Expect(finalizer.DotnetPublish(stackRID)).To(Succeed())
+				})
+			})
+
+			Context("buildpack.yml sets the configuration and publish properties", func() {
+				BeforeEach(func() {
+					Expect(os.WriteFile(filepath.Join(buildDir, "test_app.csproj"), []byte("<Project />"), 0644)).To(Succeed())
+					writeBuildpackYAML("dotnet-core:\n  configuration: Staging\n  publish-properties:\n    InvariantGlobalization: true\n")
+				})
+
+				It("passes them to dotnet publish", func() {
+					mockCommand.EXPECT().Run(gomock.Any()).Do(func(cmd *exec.Cmd) {
+						Expect(cmd.Args).To(Equal([]string{
+							"dotnet", "publish", filepath.Join(buildDir, "test_app.csproj"),
+							"-o", filepath.Join(depsDir, depsIdx, "dotnet_publish"),
+							"-c", "Staging",
+							"--self-contained",
+							"-r", stackRID,
+							"-p:InvariantGlobalization=true",
+						}))
+					})
+					Expect(finalizer.DotnetPublish(stackRID)).To(Succeed())
+				})
+			})
+
+			Context("buildpack.yml turns on publish optimizations", func() {
+				BeforeEach(func() {
+					Expect(os.WriteFile(filepath.Join(buildDir, "test_app.csproj"), []byte("<Project />"), 0644)).To(Succeed())
+					writeBuildpackYAML("dotnet-core:\n  ready-to-run: true\n  trim-mode: partial\n  single-file: true\n")
+				})
+
+				It("passes them to dotnet publish", func() {
+					mockCommand.EXPECT().Run(gomock.Any()).Do(func(cmd *exec.Cmd) {
+						Expect(cmd.Args).To(ContainElements("-p:PublishReadyToRun=true", "-p:PublishTrimmed=true", "-p:TrimMode=partial", "-p:PublishSingleFile=true"))
+					})
+					Expect(finalizer.DotnetPublish(stackRID)).To(Succeed())
+				})
+
+				It("summarizes the trimming warnings", func() {
+					mockCommand.EXPECT().Run(gomock.Any()).DoAndReturn(func(cmd *exec.Cmd) error {
+						warning := "/app/Program.cs(12,5): warning IL2026: Using member 'Json.Serialize' which has 'RequiresUnreferencedCodeAttribute' can break functionality when trimming application code. [/app/test_app.csproj]\n"
+						fmt.Fprint(cmd.Stdout, "  Restored /app/test_app.csproj\n"+warning)
+						fmt.Fprint(cmd.Stdout, "/app/Data.cs(3,1): warning IL2075: 'this' argument does not satisfy 'DynamicallyAccessedMembers'. [/app/test_app.csproj]\n")
+						fmt.Fprint(cmd.Stdout, "Build succeeded.\n"+warning)
+						return nil
+					})
+					Expect(finalizer.DotnetPublish(stackRID)).To(Succeed())
+					Expect(buffer.String()).To(ContainSubstring("-----> Trimming produced 2 warnings"))
+					Expect(buffer.String()).To(ContainSubstring("       IL2026 (1):\n         /app/Program.cs(12,5): Using member 'Json.Serialize'"))
+					Expect(buffer.String()).To(ContainSubstring("       IL2075 (1):"))
+				})
+			})
+
+			Context("buildpack.yml adds publish flags", func() {
+				BeforeEach(func() {
+					Expect(os.WriteFile(filepath.Join(buildDir, "test_app.csproj"), []byte("<Project />"), 0644)).To(Succeed())
+					writeBuildpackYAML("dotnet-core:\n  publish-flags: --verbosity minimal '-p:Product=My App'\n")
+				})
+
+				It("appends them to dotnet publish", func() {
+					mockCommand.EXPECT().Run(gomock.Any()).Do(func(cmd *exec.Cmd) {
+						Expect(cmd.Args[len(cmd.Args)-3:]).To(Equal([]string{"--verbosity", "minimal", "-p:Product=My App"}))
+					})
+					Expect(finalizer.DotnetPublish(stackRID)).To(Succeed())
+				})
+			})
+
+			Context("publish flags override the runtime and self-contained options", func() {
+				BeforeEach(func() {
+					Expect(os.WriteFile(filepath.Join(buildDir, "test_app.csproj"), []byte("<Project />"), 0644)).To(Succeed())
+					Expect(os.Setenv("BP_DOTNET_PUBLISH_FLAGS", "--runtime linux-musl-x64 --self-contained=false")).To(Succeed())
+				})
+
+				AfterEach(func() {
+					Expect(os.Unsetenv("BP_DOTNET_PUBLISH_FLAGS")).To(Succeed())
+				})
+
+				It("drops the buildpack's own and warns", func() {
+					mockCommand.EXPECT().Run(gomock.Any()).Do(func(cmd *exec.Cmd) {
+						Expect(cmd.Args).To(Equal([]string{
+							"dotnet", "publish", filepath.Join(buildDir, "test_app.csproj"),
+							"-o", filepath.Join(depsDir, depsIdx, "dotnet_publish"),
+							"-c", "Debug",
+							"--runtime", "linux-musl-x64", "--self-contained=false",
+						}))
+					})
+					Expect(finalizer.DotnetPublish(stackRID)).To(Succeed())
+					Expect(buffer.String()).To(ContainSubstring("Publish flags override the --runtime option set by the buildpack"))
+					Expect(buffer.String()).To(ContainSubstring("Publish flags override the --self-contained option set by the buildpack"))
+				})
+			})
+
+			Context("publish flags set the output directory", func() {
+				BeforeEach(func() {
+					Expect(os.WriteFile(filepath.Join(buildDir, "test_app.csproj"), []byte("<Project />"), 0644)).To(Succeed())
+					writeBuildpackYAML("dotnet-core:\n  publish-flags: -o /tmp/out\n")
+				})
+
+				It("returns an error", func() {
+					Expect(finalizer.DotnetPublish(stackRID)).To(MatchError("publish flags must not set -o, the buildpack chooses the publish directory"))
+				})
+			})
+
+			Context("publish flags set the MSBuild properties behind the buildpack's options", func() {
+				BeforeEach(func() {
+					Expect(os.WriteFile(filepath.Join(buildDir, "test_app.csproj"), []byte("<Project />"), 0644)).To(Succeed())
+				})
+
+				for flags, message := range map[string]string{
+					"-p:PublishDir=/tmp/out":                "publish flags must not set -p:PublishDir, the buildpack chooses the publish directory",
+					"/p:OutputPath=/tmp/out":                "publish flags must not set /p:OutputPath, the buildpack chooses the publish directory",
+					"--property:Configuration=Release":      "publish flags must not set --property:Configuration, set configuration in buildpack.yml instead",
+					"-p:Product=App;TargetFramework=net8.0": "publish flags must not set -p:TargetFramework, set target-framework in buildpack.yml instead",
+					"-p Configuration=Release":              "publish flags must not set -p:Configuration, set configuration in buildpack.yml instead",
+				} {
+					flags, message := flags, message
+					It("rejects "+flags, func() {
+						writeBuildpackYAML("dotnet-core:\n  publish-flags: " + flags + "\n")
+						Expect(finalizer.DotnetPublish(stackRID)).To(MatchError(message))
+					})
+				}
+
+				It("rejects them in publish-properties", func() {
+					writeBuildpackYAML("dotnet-core:\n  publish-properties:\n    publishdir: /tmp/out\n")
+					Expect(finalizer.DotnetPublish(stackRID)).To(MatchError("publish flags must not set -p:publishdir, the buildpack chooses the publish directory"))
+				})
+
+				It("drops the buildpack's runtime and self-contained options when they are overridden", func() {
+					writeBuildpackYAML("dotnet-core:\n  publish-flags: -p:RuntimeIdentifier=linux-musl-x64 /p:SelfContained=true\n")
+					mockCommand.EXPECT().Run(gomock.Any()).Do(func(cmd *exec.Cmd) {
+						Expect(cmd.Args).To(Equal([]string{
+							"dotnet", "publish", filepath.Join(buildDir, "test_app.csproj"),
+							"-o", filepath.Join(depsDir, depsIdx, "dotnet_publish"),
+							"-c", "Debug",
+							"-p:RuntimeIdentifier=linux-musl-x64", "/p:SelfContained=true",
+						}))
+					})
+					Expect(finalizer.DotnetPublish(stackRID)).To(Succeed())
+					Expect(buffer.String()).To(ContainSubstring("Publish flags override the --runtime option set by the buildpack"))
+					Expect(buffer.String()).To(ContainSubstring("Publish flags override the --self-contained option set by the buildpack"))
+				})
+			})
+
+			Context("trimming is combined with a framework-dependent publish through publish flags", func() {
+				BeforeEach(func() {
+					Expect(os.WriteFile(filepath.Join(buildDir, "test_app.csproj"), []byte("<Project />"), 0644)).To(Succeed())
+				})
+
+				for _, flags := range []string{"--self-contained false", "--no-self-contained", "-p:SelfContained=false"} {
+					flags := flags
+					It("returns an error for "+flags, func() {
+						writeBuildpackYAML("dotnet-core:\n  trimmed: true\n  publish-flags: " + flags + "\n")
+						Expect(finalizer.DotnetPublish(stackRID)).To(MatchError("trimming requires a self-contained publish and cannot be combined with framework-dependent"))
+					})
+				}
+			})
+
+			Context("trimming is combined with framework-dependent publishing", func() {
+				BeforeEach(func() {
+					Expect(os.WriteFile(filepath.Join(buildDir, "test_app.csproj"), []byte("<Project />"), 0644)).To(Succeed())
+					writeBuildpackYAML("dotnet-core:\n  trimmed: true\n  framework-dependent: true\n")
+				})
+
+				It("returns an error", func() {
+					Expect(finalizer.DotnetPublish(stackRID)).To(MatchError("trimming requires a self-contained publish and cannot be combined with framework-dependent"))
+				})
+			})
+
+			Context("the project is multi-targeted and buildpack.yml pins a target framework", func() {
+				BeforeEach(func() {
+					Expect(os.WriteFile(filepath.Join(buildDir, "test_app.csproj"), []byte("<Project><PropertyGroup><TargetFrameworks>net8.0;net9.0</TargetFrameworks></PropertyGroup></Project>"), 0644)).To(Succeed())
+					writeBuildpackYAML("dotnet-core:\n  target-framework: net8.0\n")
+				})
+
+				It("publishes for that framework", func() {
+					mockCommand.EXPECT().Run(gomock.Any()).Do(func(cmd *exec.Cmd) {
+						Expect(cmd.Args).To(ContainElements("-f", "net8.0"))
+					})
+					Expect(finalizer.DotnetPublish(stackRID)).To(Succeed())
+				})
+			})
+		})
+	})
+
+	Describe("RunTests", func() {
+		writeTRX := func(cmd *exec.Cmd, results string) {
+			resultsDir := cmd.Args[len(cmd.Args)-1]
+			Expect(os.MkdirAll(resultsDir, 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(resultsDir, "run.trx"), []byte(`<?xml version="1.0" encoding="utf-8"?>
+<TestRun xmlns="http://microsoft.com/schemas/VisualStudio/TeamTest/2010">
+  <Results>`+results+`</Results>
+</TestRun>`), 0644)).To(Succeed())
+		}
+
+		BeforeEach(func() {
+			Expect(os.MkdirAll(filepath.Join(buildDir, "src", "Web"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "src", "Web", "Web.csproj"), []byte(`<Project Sdk="Microsoft.NET.Sdk.Web" />`), 0644)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(buildDir, "test", "Web.Tests"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "test", "Web.Tests", "Web.Tests.csproj"), []byte(`<Project Sdk="Microsoft.NET.Sdk"><PropertyGroup><IsTestProject>true</IsTestProject></PropertyGroup></Project>`), 0644)).To(Succeed())
+		})
+
+		It("does nothing unless enabled", func() {
+			Expect(finalizer.RunTests()).To(Succeed())
+			Expect(buffer.String()).NotTo(ContainSubstring("dotnet test"))
+		})
+
+		Context("buildpack.yml enables tests", func() {
+			BeforeEach(func() {
+				writeBuildpackYAML("dotnet-core:\n  run-tests: true\n")
+			})
+
+			It("runs dotnet test on the test projects and summarizes the results", func() {
+				mockCommand.EXPECT().Run(gomock.Any()).DoAndReturn(func(cmd *exec.Cmd) error {
+					Expect(cmd.Args[:5]).To(Equal([]string{"dotnet", "test", filepath.Join(buildDir, "test", "Web.Tests", "Web.Tests.csproj"), "-c", "Debug"}))
+					Expect(cmd.Args).To(ContainElements("--logger", "trx"))
+					writeTRX(cmd, `<UnitTestResult testName="Adds" outcome="Passed" /><UnitTestResult testName="Skips" outcome="NotExecuted" />`)
+					return nil
+				})
+
+				Expect(finalizer.RunTests()).To(Succeed())
+				Expect(buffer.String()).To(ContainSubstring("Testing test/Web.Tests/Web.Tests.csproj"))
+				Expect(buffer.String()).To(ContainSubstring("Tests: 1 passed, 0 failed, 1 skipped"))
+			})
+
+			It("fails staging when a test fails", func() {
+				mockCommand.EXPECT().Run(gomock.Any()).DoAndReturn(func(cmd *exec.Cmd) error {
+					writeTRX(cmd, `<UnitTestResult testName="Adds" outcome="Passed" />
+<UnitTestResult testName="Divides" outcome="Failed"><Output><ErrorInfo><Message>Assert.Equal() Failure
+Expected: 2</Message></ErrorInfo></Output></UnitTestResult>`)
+					return fmt.Errorf("exit status 1")
+				})
+
+				Expect(finalizer.RunTests()).To(MatchError("dotnet test failed for test/Web.Tests/Web.Tests.csproj"))
+				Expect(buffer.String()).To(ContainSubstring("Tests: 1 passed, 1 failed, 0 skipped"))
+				Expect(buffer.String()).To(ContainSubstring("Failed Divides: Assert.Equal() Failure\n"))
+			})
+
+			Context("a test project targets a framework whose runtime is not installed", func() {
+				BeforeEach(func() {
+					Expect(os.MkdirAll(filepath.Join(depsDir, depsIdx, "dotnet-sdk", "shared", "Microsoft.NETCore.App", "8.0.8"), 0755)).To(Succeed())
+					Expect(os.WriteFile(filepath.Join(buildDir, "test", "Web.Tests", "Web.Tests.csproj"), []byte(`<Project Sdk="Microsoft.NET.Sdk"><PropertyGroup><TargetFrameworks>net8.0;net6.0</TargetFrameworks><IsTestProject>true</IsTestProject></PropertyGroup></Project>`), 0644)).To(Succeed())
+				})
+
+				It("fails before running any test", func() {
+					Expect(finalizer.RunTests()).To(MatchError("test project test/Web.Tests/Web.Tests.csproj targets net6.0, but the installed Microsoft.NETCore.App runtimes are: 8.0.8; target the app's runtime or set run-tests: false in buildpack.yml"))
+				})
+			})
+
+			Context("a test project targets a framework that does not run on Linux", func() {
+				BeforeEach(func() {
+					Expect(os.WriteFile(filepath.Join(buildDir, "test", "Web.Tests", "Web.Tests.csproj"), []byte(`<Project Sdk="Microsoft.NET.Sdk"><PropertyGroup><TargetFramework>net48</TargetFramework><IsTestProject>true</IsTestProject></PropertyGroup></Project>`), 0644)).To(Succeed())
+				})
+
+				It("fails before running any test", func() {
+					Expect(finalizer.RunTests()).To(MatchError("test project test/Web.Tests/Web.Tests.csproj targets net48, which does not run on Linux; set run-tests: false in buildpack.yml to stage without running tests"))
+				})
+			})
 		})
 	})
 
@@ -132,4 +448,456 @@ var _ = Describe("Finalize", func() {
 			})
 		})
 	})
+
+	Describe("Native AOT", func() {
+		BeforeEach(func() {
+			Expect(os.WriteFile(filepath.Join(buildDir, "test_app.csproj"), []byte("<Project><PropertyGroup><PublishAot>true</PublishAot></PropertyGroup></Project>"), 0644)).To(Succeed())
+			writeBuildpackYAML("dotnet-core:\n  framework-dependent: true\n")
+		})
+
+		It("publishes self-contained even when framework-dependent is requested", func() {
+			mockCommand.EXPECT().Run(gomock.Any()).Do(func(cmd *exec.Cmd) {
+				Expect(cmd.Args).To(ContainElement("--self-contained"))
+				Expect(cmd.Args).NotTo(ContainElement("false"))
+			})
+			Expect(finalizer.DotnetPublish(stackRID)).To(Succeed())
+			Expect(buffer.String()).To(ContainSubstring("test_app.csproj is published with Native AOT, ignoring framework-dependent"))
+		})
+
+		It("removes the SDK and runtimes from the droplet", func() {
+			Expect(os.MkdirAll(filepath.Join(depsDir, depsIdx, "bin"), 0755)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(depsDir, depsIdx, "lib"), 0755)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(depsDir, depsIdx, "dotnet-sdk", "shared", "Microsoft.NETCore.App", "8.0.8"), 0755)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(depsDir, depsIdx, "dotnet_publish"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(depsDir, depsIdx, "dotnet_publish", "test_app"), []byte(""), 0755)).To(Succeed())
+
+			Expect(finalizer.CleanStagingArea()).To(Succeed())
+			Expect(filepath.Join(depsDir, depsIdx, "dotnet-sdk")).NotTo(BeADirectory())
+		})
+	})
+
+	Describe("PruneDotnetInstall", func() {
+		BeforeEach(func() {
+			Expect(os.WriteFile(filepath.Join(buildDir, "test_app.csproj"), []byte("<Project />"), 0644)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(depsDir, depsIdx, "dotnet_publish"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(depsDir, depsIdx, "dotnet_publish", "test_app.runtimeconfig.json"),
+				[]byte(`{"runtimeOptions": {"framework": {"name": "Microsoft.NETCore.App", "version": "8.0.0"}}}`), 0644)).To(Succeed())
+			for _, dir := range []string{
+				"host/fxr/8.0.8",
+				"shared/Microsoft.NETCore.App/8.0.8",
+				"shared/Microsoft.AspNetCore.App/8.0.8",
+				"sdk/8.0.401",
+				"packs/Microsoft.NETCore.App.Ref",
+			} {
+				Expect(os.MkdirAll(filepath.Join(depsDir, depsIdx, "dotnet-sdk", dir), 0755)).To(Succeed())
+			}
+		})
+
+		It("keeps only the host and the shared frameworks the app uses", func() {
+			Expect(finalizer.PruneDotnetInstall()).To(Succeed())
+
+			dotnetRoot := filepath.Join(depsDir, depsIdx, "dotnet-sdk")
+			Expect(filepath.Join(dotnetRoot, "host", "fxr", "8.0.8")).To(BeADirectory())
+			Expect(filepath.Join(dotnetRoot, "shared", "Microsoft.NETCore.App", "8.0.8")).To(BeADirectory())
+			Expect(filepath.Join(dotnetRoot, "shared", "Microsoft.AspNetCore.App")).NotTo(BeADirectory())
+			Expect(filepath.Join(dotnetRoot, "sdk")).NotTo(BeADirectory())
+			Expect(filepath.Join(dotnetRoot, "packs")).NotTo(BeADirectory())
+			Expect(buffer.String()).To(ContainSubstring("Removing unused shared framework Microsoft.AspNetCore.App"))
+		})
+
+		Context("the app is published as a single file", func() {
+			BeforeEach(func() {
+				Expect(os.Remove(filepath.Join(depsDir, depsIdx, "dotnet_publish", "test_app.runtimeconfig.json"))).To(Succeed())
+				writeBuildpackYAML("dotnet-core:\n  single-file: true\n")
+			})
+
+			It("keeps every shared framework", func() {
+				Expect(finalizer.PruneDotnetInstall()).To(Succeed())
+
+				dotnetRoot := filepath.Join(depsDir, depsIdx, "dotnet-sdk")
+				Expect(filepath.Join(dotnetRoot, "shared", "Microsoft.AspNetCore.App", "8.0.8")).To(BeADirectory())
+				Expect(filepath.Join(dotnetRoot, "sdk")).NotTo(BeADirectory())
+			})
+		})
+
+		Context("framework-dependent publishing is enabled", func() {
+			BeforeEach(func() {
+				Expect(os.MkdirAll(filepath.Join(depsDir, depsIdx, "bin"), 0755)).To(Succeed())
+				Expect(os.MkdirAll(filepath.Join(depsDir, depsIdx, "lib"), 0755)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(depsDir, depsIdx, "dotnet_publish", "test_app"), []byte(""), 0755)).To(Succeed())
+				Expect(os.Setenv("BP_DOTNET_FRAMEWORK_DEPENDENT", "true")).To(Succeed())
+			})
+
+			AfterEach(func() {
+				Expect(os.Unsetenv("BP_DOTNET_FRAMEWORK_DEPENDENT")).To(Succeed())
+			})
+
+			It("keeps the shared runtime when cleaning the staging area", func() {
+				Expect(finalizer.CleanStagingArea()).To(Succeed())
+				Expect(filepath.Join(depsDir, depsIdx, "dotnet-sdk", "shared", "Microsoft.NETCore.App")).To(BeADirectory())
+			})
+		})
+	})
+
+	Describe("WriteSBOM", func() {
+		BeforeEach(func() {
+			finalizer.Manifest = sbomManifest{}
+			finalizer.Config.RecordInstalled("dotnet-sdk", "8.0.401")
+			Expect(os.MkdirAll(filepath.Join(depsDir, depsIdx, "dotnet-sdk"), 0755)).To(Succeed())
+
+			Expect(os.MkdirAll(filepath.Join(depsDir, depsIdx, "dotnet_publish"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(depsDir, depsIdx, "dotnet_publish", "app.deps.json"), []byte(`{"libraries": {"app/1.0.0": {"type": "project"}, "Serilog/3.1.1": {"type": "package"}}}`), 0644)).To(Succeed())
+		})
+
+		It("writes the SBOM into the dep dir and logs where", func() {
+			Expect(finalizer.WriteSBOM()).To(Succeed())
+
+			contents, err := os.ReadFile(filepath.Join(depsDir, depsIdx, "sbom.cdx.json"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(contents)).To(ContainSubstring(`"purl": "pkg:generic/dotnet-sdk@8.0.401"`))
+			Expect(string(contents)).To(ContainSubstring(`"purl": "pkg:nuget/Serilog@3.1.1"`))
+			Expect(string(contents)).NotTo(ContainSubstring("pkg:nuget/app@"))
+
+			Expect(buffer.String()).To(ContainSubstring("-----> Wrote SBOM with 2 components to /home/vcap/deps/9/sbom.cdx.json"))
+			Expect(buffer.String()).NotTo(ContainSubstring("CycloneDX"))
+		})
+
+		Context("the staging area was cleaned", func() {
+			BeforeEach(func() {
+				finalizer.Config.RecordInstalled("node", "20.17.0")
+			})
+
+			It("leaves out the dependencies that were removed", func() {
+				Expect(finalizer.WriteSBOM()).To(Succeed())
+
+				contents, err := os.ReadFile(filepath.Join(depsDir, depsIdx, "sbom.cdx.json"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(contents)).To(ContainSubstring(`"purl": "pkg:generic/dotnet-sdk@8.0.401"`))
+				Expect(string(contents)).NotTo(ContainSubstring("pkg:generic/node@"))
+			})
+		})
+
+		Context("BP_DEBUG is set", func() {
+			BeforeEach(func() {
+				Expect(os.Setenv("BP_DEBUG", "true")).To(Succeed())
+			})
+
+			AfterEach(func() {
+				Expect(os.Unsetenv("BP_DEBUG")).To(Succeed())
+			})
+
+			It("echoes the SBOM to the staging log", func() {
+				Expect(finalizer.WriteSBOM()).To(Succeed())
+				Expect(buffer.String()).To(ContainSubstring(`"bomFormat": "CycloneDX"`))
+				Expect(buffer.String()).To(ContainSubstring(`"purl": "pkg:nuget/Serilog@3.1.1"`))
+			})
+		})
+
+		Context("the app was published self-contained", func() {
+			BeforeEach(func() {
+				// CleanStagingArea removed the SDK along with its runtime
+				Expect(os.RemoveAll(filepath.Join(depsDir, depsIdx, "dotnet-sdk"))).To(Succeed())
+				finalizer.Config.RecordInstalled("dotnet-runtime", "8.0.8")
+				Expect(os.WriteFile(filepath.Join(depsDir, depsIdx, "dotnet_publish", "app.deps.json"), []byte(`{
+  "libraries": {
+    "app/1.0.0": {"type": "project"},
+    "runtimepack.Microsoft.NETCore.App.Runtime.linux-x64/8.0.8": {"type": "runtimepack", "serviceable": false, "sha512": ""},
+    "runtimepack.Microsoft.AspNetCore.App.Runtime.linux-x64/8.0.8": {"type": "runtimepack", "serviceable": false, "sha512": ""},
+    "Serilog/3.1.1": {"type": "package"}
+  }
+}`), 0644)).To(Succeed())
+			})
+
+			It("lists the runtime packs the app carries", func() {
+				Expect(finalizer.WriteSBOM()).To(Succeed())
+
+				contents, err := os.ReadFile(filepath.Join(depsDir, depsIdx, "sbom.cdx.json"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(contents)).NotTo(ContainSubstring("pkg:generic/dotnet-"))
+				Expect(string(contents)).To(ContainSubstring(`"purl": "pkg:nuget/Microsoft.NETCore.App.Runtime.linux-x64@8.0.8"`))
+				Expect(string(contents)).To(ContainSubstring(`"purl": "pkg:nuget/Microsoft.AspNetCore.App.Runtime.linux-x64@8.0.8"`))
+				Expect(string(contents)).To(ContainSubstring(`"type": "framework"`))
+				Expect(buffer.String()).To(ContainSubstring("-----> Wrote SBOM with 3 components"))
+			})
+		})
+
+		Context("the app has not been published", func() {
+			BeforeEach(func() {
+				Expect(os.RemoveAll(filepath.Join(depsDir, depsIdx, "dotnet_publish"))).To(Succeed())
+				Expect(os.MkdirAll(filepath.Join(buildDir, "src", "app", "obj"), 0755)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(buildDir, "src", "app", "obj", "project.assets.json"), []byte(`{"libraries": {"Dapper/2.1.24": {"type": "package"}}}`), 0644)).To(Succeed())
+			})
+
+			It("lists the packages from project.assets.json", func() {
+				Expect(finalizer.WriteSBOM()).To(Succeed())
+
+				contents, err := os.ReadFile(filepath.Join(depsDir, depsIdx, "sbom.cdx.json"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(contents)).To(ContainSubstring(`"purl": "pkg:nuget/Dapper@2.1.24"`))
+			})
+		})
+	})
+
+	Describe("multiple processes", func() {
+		BeforeEach(func() {
+			for _, name := range []string{"src/Api/Api.csproj", "src/Worker/Worker.csproj"} {
+				Expect(os.MkdirAll(filepath.Dir(filepath.Join(buildDir, name)), 0755)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(buildDir, name), []byte("<Project />"), 0644)).To(Succeed())
+			}
+			writeBuildpackYAML(`dotnet-core:
+  processes:
+    web:
+      project: src/Api/Api.csproj
+    worker:
+      project: src/Worker/Worker.csproj
+`)
+		})
+
+		It("publishes every process into its own directory", func() {
+			var published []string
+			mockCommand.EXPECT().Run(gomock.Any()).Times(2).Do(func(cmd *exec.Cmd) {
+				published = append(published, cmd.Args[2]+" -> "+cmd.Args[4])
+			})
+
+			Expect(finalizer.DotnetPublish(stackRID)).To(Succeed())
+			Expect(published).To(Equal([]string{
+				filepath.Join(buildDir, "src", "Api", "Api.csproj") + " -> " + filepath.Join(depsDir, depsIdx, "dotnet_publish", "web"),
+				filepath.Join(buildDir, "src", "Worker", "Worker.csproj") + " -> " + filepath.Join(depsDir, depsIdx, "dotnet_publish", "worker"),
+			}))
+		})
+
+		It("declares a process type for every process", func() {
+			Expect(os.MkdirAll(filepath.Join(depsDir, depsIdx, "dotnet_publish", "web"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(depsDir, depsIdx, "dotnet_publish", "web", "Api"), []byte(""), 0755)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(depsDir, depsIdx, "dotnet_publish", "worker"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(depsDir, depsIdx, "dotnet_publish", "worker", "Worker.dll"), []byte(""), 0644)).To(Succeed())
+
+			releaseYAML, err := finalizer.GenerateReleaseYaml()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(releaseYAML).To(Equal(map[string]map[string]string{
+				"default_process_types": {
+					"web":    fmt.Sprintf("cd ${DEPS_DIR}/%s/dotnet_publish/web && exec ./Api", depsIdx),
+					"worker": fmt.Sprintf("cd ${DEPS_DIR}/%s/dotnet_publish/worker && exec dotnet ./Worker.dll", depsIdx),
+				},
+			}))
+		})
+
+		It("runs the command buildpack.yml gives a process from its own publish directory", func() {
+			writeBuildpackYAML(`dotnet-core:
+  processes:
+    web:
+      project: src/Api/Api.csproj
+      command: cd {publish_dir} && exec {entry_assembly} --urls http://0.0.0.0:$PORT
+`)
+			Expect(os.MkdirAll(filepath.Join(depsDir, depsIdx, "dotnet_publish", "web"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(depsDir, depsIdx, "dotnet_publish", "web", "Api"), []byte(""), 0755)).To(Succeed())
+
+			releaseYAML, err := finalizer.GenerateReleaseYaml()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(releaseYAML["default_process_types"]).To(Equal(map[string]string{
+				"web": fmt.Sprintf("cd ${DEPS_DIR}/%s/dotnet_publish/web && exec ./Api --urls http://0.0.0.0:$PORT", depsIdx),
+			}))
+		})
+
+		It("fails when a process has no published entry point", func() {
+			_, err := finalizer.GenerateReleaseYaml()
+			Expect(err).To(MatchError("could not find the published entry point of process web in src/Api/Api.csproj"))
+		})
+	})
+
+	Describe("custom process commands", func() {
+		BeforeEach(func() {
+			Expect(os.WriteFile(filepath.Join(buildDir, "App.runtimeconfig.json"), []byte("{}"), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "App.dll"), []byte(""), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "Procfile"), []byte("worker: cd {publish_dir} && exec {entry_assembly} --worker\n"), 0644)).To(Succeed())
+			writeBuildpackYAML("dotnet-core:\n  processes:\n    migrate:\n      command: dotnet {publish_dir}/App.dll --migrate\n")
+		})
+
+		It("merges them with the computed web process, expanding the placeholders", func() {
+			releaseYAML, err := finalizer.GenerateReleaseYaml()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(releaseYAML).To(Equal(map[string]map[string]string{
+				"default_process_types": {
+					"web":     "cd ${HOME} && exec dotnet ./App.dll",
+					"worker":  "cd ${HOME} && exec dotnet ./App.dll --worker",
+					"migrate": "dotnet ${HOME}/App.dll --migrate",
+				},
+			}))
+		})
+
+		It("lets the Procfile replace the web process, expanding it only in the release YAML", func() {
+			procfile := []byte("web: cd {publish_dir} && exec {entry_assembly} --urls http://0.0.0.0:$PORT\n")
+			Expect(os.WriteFile(filepath.Join(buildDir, "Procfile"), procfile, 0644)).To(Succeed())
+
+			releaseYAML, err := finalizer.GenerateReleaseYaml()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(releaseYAML["default_process_types"]["web"]).To(Equal("cd ${HOME} && exec dotnet ./App.dll --urls http://0.0.0.0:$PORT"))
+			Expect(os.ReadFile(filepath.Join(buildDir, "Procfile"))).To(Equal(procfile))
+		})
+
+		It("names the first process in order when the app has no published entry point", func() {
+			Expect(os.Remove(filepath.Join(buildDir, "App.runtimeconfig.json"))).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "App.csproj"), []byte("<Project />"), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "Procfile"), []byte("worker: {entry_assembly} --worker\nbeat: {entry_assembly} --beat\n"), 0644)).To(Succeed())
+			writeBuildpackYAML("dotnet-core:\n  processes:\n    web:\n      command: ./App\n")
+
+			_, err := finalizer.GenerateReleaseYaml()
+			Expect(err).To(MatchError("process beat uses {publish_dir} or {entry_assembly}, but the app has no published entry point"))
+		})
+	})
+
+	Describe("Plan", func() {
+		BeforeEach(func() {
+			for name, content := range map[string]string{
+				"src/Api/Api.csproj":       `<Project Sdk="Microsoft.NET.Sdk.Web"><PropertyGroup><TargetFramework>net8.0</TargetFramework><PublishAot>true</PublishAot></PropertyGroup></Project>`,
+				"src/Worker/Worker.csproj": `<Project Sdk="Microsoft.NET.Sdk.Worker"><PropertyGroup><TargetFramework>net8.0</TargetFramework><UseAppHost>false</UseAppHost></PropertyGroup></Project>`,
+			} {
+				Expect(os.MkdirAll(filepath.Dir(filepath.Join(buildDir, name)), 0755)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(buildDir, name), []byte(content), 0644)).To(Succeed())
+			}
+			writeBuildpackYAML(`dotnet-core:
+  processes:
+    web:
+      project: src/Api/Api.csproj
+    worker:
+      project: src/Worker/Worker.csproj
+`)
+
+			finalizer.Project = project.New(buildDir, filepath.Join(depsDir, depsIdx), depsIdx, planManifest{
+				"dotnet-runtime":    {"8.0.7", "8.0.8"},
+				"dotnet-aspnetcore": {"8.0.7", "8.0.8"},
+			}, nil, logger)
+			finalizer.Project.BuildpackYAML = finalizer.BuildpackYAML
+			finalizer.Arch = "arm64"
+			finalizer.Stack = config.Stack{Name: "cflinuxfs4", RuntimeOS: "linux"}
+		})
+
+		It("describes the publish runs and process types without publishing", func() {
+			stagingPlan := plan.Plan{}
+			Expect(finalizer.Plan(&stagingPlan)).To(Succeed())
+
+			Expect(stagingPlan.Stack).To(Equal("cflinuxfs4"))
+			Expect(stagingPlan.Architecture).To(Equal("arm64"))
+			Expect(stagingPlan.Published).To(BeFalse())
+			Expect(stagingPlan.NativeAot).To(BeFalse())
+			Expect(stagingPlan.Dependencies).To(Equal([]config.Resolution{
+				{Name: "dotnet-aspnetcore", Constraint: "8.0.8", Source: "dotnet-runtime", Steps: []string{"same version as dotnet-runtime"}, Version: "8.0.8"},
+				{Name: "dotnet-runtime", Constraint: "net8.0", Source: "src/Api/Api.csproj", Steps: []string{"8.0.x: 8.0.8"}, Version: "8.0.8"},
+			}))
+			Expect(stagingPlan.Publish).To(Equal([]plan.Publish{
+				{Process: "web", Project: "src/Api/Api.csproj", Command: []string{"dotnet", "publish", filepath.Join(buildDir, "src", "Api", "Api.csproj"), "-o", filepath.Join(depsDir, depsIdx, "dotnet_publish", "web"), "-c", "Debug", "--self-contained", "-r", "linux-arm64"}},
+				{Process: "worker", Project: "src/Worker/Worker.csproj", Command: []string{"dotnet", "publish", filepath.Join(buildDir, "src", "Worker", "Worker.csproj"), "-o", filepath.Join(depsDir, depsIdx, "dotnet_publish", "worker"), "-c", "Debug", "--self-contained", "-r", "linux-arm64"}},
+			}))
+			Expect(stagingPlan.Processes).To(Equal(map[string]string{
+				"web":    fmt.Sprintf("cd ${DEPS_DIR}/%s/dotnet_publish/web && exec ./Api", depsIdx),
+				"worker": fmt.Sprintf("cd ${DEPS_DIR}/%s/dotnet_publish/worker && exec dotnet ./Worker.dll", depsIdx),
+			}))
+
+			Expect(filepath.Join(depsDir, depsIdx, "dotnet_publish")).NotTo(BeADirectory())
+		})
+	})
+
+	Describe("CleanStagingArea with node installed", func() {
+		BeforeEach(func() {
+			for _, dir := range []string{"bin", "lib", "node/bin"} {
+				Expect(os.MkdirAll(filepath.Join(depsDir, depsIdx, dir), 0755)).To(Succeed())
+			}
+		})
+
+		It("removes node by default", func() {
+			Expect(finalizer.CleanStagingArea()).To(Succeed())
+			Expect(filepath.Join(depsDir, depsIdx, "node")).NotTo(BeADirectory())
+		})
+
+		Context("buildpack.yml asks to keep node", func() {
+			BeforeEach(func() {
+				writeBuildpackYAML("dotnet-core:\n  keep-node: true\n")
+			})
+
+			It("keeps node", func() {
+				Expect(finalizer.CleanStagingArea()).To(Succeed())
+				Expect(filepath.Join(depsDir, depsIdx, "node")).To(BeADirectory())
+			})
+		})
+	})
+
+	Describe("NuGet cache", func() {
+		BeforeEach(func() {
+			Expect(os.WriteFile(filepath.Join(buildDir, "test_app.csproj"), []byte("<Project />"), 0644)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(depsDir, depsIdx, ".nuget", "packages", "newtonsoft.json"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(depsDir, depsIdx, ".nuget", "packages", "newtonsoft.json", "package.nupkg"), []byte("nupkg"), 0644)).To(Succeed())
+		})
+
+		It("saves the restored packages to the app cache", func() {
+			key, err := finalizer.RestoreNugetCache()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(finalizer.SaveNugetCache(key)).To(Succeed())
+
+			Expect(filepath.Join(cacheDir, "nuget", "packages", "newtonsoft.json", "package.nupkg")).To(BeARegularFile())
+			Expect(os.ReadFile(filepath.Join(cacheDir, "nuget", "cache-key"))).To(Equal([]byte(key)))
+		})
+
+		Context("packages were cached by a previous staging", func() {
+			var key string
+
+			BeforeEach(func() {
+				key, err = finalizer.RestoreNugetCache()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(finalizer.SaveNugetCache(key)).To(Succeed())
+				Expect(os.RemoveAll(filepath.Join(depsDir, depsIdx, ".nuget"))).To(Succeed())
+			})
+
+			It("restores them when nothing changed", func() {
+				restoredKey, err := finalizer.RestoreNugetCache()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(restoredKey).To(Equal(key))
+
+				Expect(filepath.Join(depsDir, depsIdx, ".nuget", "packages", "newtonsoft.json", "package.nupkg")).To(BeARegularFile())
+				Expect(buffer.String()).To(ContainSubstring("Restoring NuGet packages from cache"))
+			})
+
+			It("discards them when a project file changed", func() {
+				Expect(os.WriteFile(filepath.Join(buildDir, "test_app.csproj"), []byte("<Project><ItemGroup /></Project>"), 0644)).To(Succeed())
+
+				restoredKey, err := finalizer.RestoreNugetCache()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(restoredKey).NotTo(Equal(key))
+
+				Expect(filepath.Join(depsDir, depsIdx, ".nuget")).NotTo(BeADirectory())
+				Expect(filepath.Join(cacheDir, "nuget")).NotTo(BeADirectory())
+			})
+
+			It("discards them when a lock file was added", func() {
+				Expect(os.WriteFile(filepath.Join(buildDir, "packages.lock.json"), []byte("{}"), 0644)).To(Succeed())
+
+				restoredKey, err := finalizer.RestoreNugetCache()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(restoredKey).NotTo(Equal(key))
+				Expect(filepath.Join(cacheDir, "nuget")).NotTo(BeADirectory())
+			})
+
+			It("discards them when the SDK version changed", func() {
+				finalizer.Config.DotnetSdkVersion = "8.0.402"
+
+				restoredKey, err := finalizer.RestoreNugetCache()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(restoredKey).NotTo(Equal(key))
+				Expect(filepath.Join(cacheDir, "nuget")).NotTo(BeADirectory())
+			})
+		})
+	})
 })
+
+type sbomManifest struct{}
+
+func (sbomManifest) AllDependencyVersions(string) []string { return nil }
+
+func (sbomManifest) GetEntry(dep libbuildpack.Dependency) (*libbuildpack.ManifestEntry, error) {
+	return nil, fmt.Errorf("dependency %s %s not found", dep.Name, dep.Version)
+}
+
+func (sbomManifest) Version() (string, error) { return "1.2.3", nil }
+
+type planManifest map[string][]string
+
+func (m planManifest) AllDependencyVersions(name string) []string { return m[name] }
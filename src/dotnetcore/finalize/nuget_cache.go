@@ -0,0 +1,181 @@
+package finalize
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// nugetCacheDirs are the directories below <dep dir>/.nuget that are kept in
+// the app cache between stagings.
+var nugetCacheDirs = []string{"packages", "http-cache"}
+
+func (f *Finalizer) nugetDir() string {
+	return filepath.Join(f.Stager.DepDir(), ".nuget")
+}
+
+func (f *Finalizer) nugetAppCacheDir() string {
+	return filepath.Join(f.Stager.CacheDir(), "nuget")
+}
+
+// RestoreNugetCache moves the NuGet package and HTTP caches saved by a
+// previous staging into the dep dir, as long as they were produced by the same
+// SDK from the same project and lock files. It returns the cache key of the
+// current build so that SaveNugetCache can store the result under it.
+func (f *Finalizer) RestoreNugetCache() (string, error) {
+	key, err := f.nugetCacheKey()
+	if err != nil {
+		return "", err
+	}
+
+	cacheDir := f.nugetAppCacheDir()
+	if exists, err := libbuildpack.FileExists(cacheDir); err != nil {
+		return "", err
+	} else if !exists {
+		return key, nil
+	}
+
+	cachedKey, err := os.ReadFile(filepath.Join(cacheDir, "cache-key"))
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	if string(cachedKey) != key {
+		f.Log.Info("Project files or SDK changed, discarding cached NuGet packages")
+		return key, os.RemoveAll(cacheDir)
+	}
+
+	f.Log.BeginStep("Restoring NuGet packages from cache")
+	for _, dir := range nugetCacheDirs {
+		if exists, err := libbuildpack.FileExists(filepath.Join(cacheDir, dir)); err != nil {
+			return "", err
+		} else if !exists {
+			continue
+		}
+
+		if err := os.RemoveAll(filepath.Join(f.nugetDir(), dir)); err != nil {
+			return "", err
+		}
+		if err := moveDirectory(filepath.Join(cacheDir, dir), filepath.Join(f.nugetDir(), dir)); err != nil {
+			return "", err
+		}
+	}
+	return key, nil
+}
+
+// SaveNugetCache moves the NuGet package and HTTP caches from the dep dir into
+// the app cache, replacing whatever was cached before.
+func (f *Finalizer) SaveNugetCache(key string) error {
+	cacheDir := f.nugetAppCacheDir()
+	if err := os.RemoveAll(cacheDir); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return err
+	}
+
+	f.Log.BeginStep("Saving NuGet packages to cache")
+	for _, dir := range nugetCacheDirs {
+		if exists, err := libbuildpack.FileExists(filepath.Join(f.nugetDir(), dir)); err != nil {
+			return err
+		} else if !exists {
+			continue
+		}
+
+		if err := moveDirectory(filepath.Join(f.nugetDir(), dir), filepath.Join(cacheDir, dir)); err != nil {
+			return err
+		}
+	}
+
+	return os.WriteFile(filepath.Join(cacheDir, "cache-key"), []byte(key), 0644)
+}
+
+// nugetCacheKey hashes the SDK version together with every file that
+// influences package restore, so the cache is dropped whenever a package
+// reference, lock file or feed configuration changes.
+func (f *Finalizer) nugetCacheKey() (string, error) {
+	var paths []string
+	err := filepath.Walk(f.Stager.BuildDir(), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			if info.Name() == ".cloudfoundry" || info.Name() == "node_modules" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if isRestoreInput(info.Name()) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(paths)
+
+	h := sha256.New()
+	fmt.Fprintf(h, "dotnet-sdk:%s\n", f.Config.DotnetSdkVersion)
+	for _, path := range paths {
+		relPath, err := filepath.Rel(f.Stager.BuildDir(), path)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s\n", relPath)
+
+		file, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(h, file)
+		file.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+func isRestoreInput(name string) bool {
+	switch strings.ToLower(name) {
+	case "packages.lock.json", "directory.build.props", "directory.build.targets", "directory.packages.props", "nuget.config", "global.json":
+		return true
+	}
+
+	switch filepath.Ext(name) {
+	case ".csproj", ".fsproj", ".vbproj":
+		return true
+	}
+	return false
+}
+
+// moveDirectory renames src to dest, falling back to a copy when they live on
+// different filesystems.
+func moveDirectory(src, dest string) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+
+	if err := os.Rename(src, dest); err == nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+	if err := libbuildpack.CopyDirectory(src, dest); err != nil {
+		return err
+	}
+	return os.RemoveAll(src)
+}
@@ -0,0 +1,78 @@
+package finalize
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/plan"
+)
+
+// Plan records the frameworks finalize would install, the dotnet publish
+// runs and the resulting process types, resolved the same way Run resolves
+// them but without installing, publishing or writing anything
+func (f *Finalizer) Plan(p *plan.Plan) error {
+	if f.Stack.RuntimeOS == "" {
+		return fmt.Errorf("unsupported stack: %s", os.Getenv("CF_STACK"))
+	}
+	stackRID := f.Stack.RuntimeIdentifier(f.Arch)
+	p.Stack = f.Stack.Name
+	p.Architecture = f.Arch
+
+	var err error
+	if p.Published, err = f.Project.IsPublished(); err != nil {
+		return err
+	}
+
+	if isFrameworkDependent, err := f.Project.IsFDD(); err != nil {
+		return err
+	} else if isFrameworkDependent {
+		frameworks, err := f.Project.FDDFrameworkVersions()
+		if err != nil {
+			return err
+		}
+		p.Dependencies = append(p.Dependencies, frameworks...)
+	}
+
+	processes, err := f.Project.Processes()
+	if err != nil {
+		return err
+	}
+
+	if !p.Published {
+		if p.NativeAot, err = f.Project.IsNativeAot(); err != nil {
+			return err
+		} else if !p.NativeAot {
+			runtime, aspNetCore, err := f.Project.SourceRuntimeVersions()
+			if err != nil {
+				return err
+			}
+			p.Dependencies = append(p.Dependencies, aspNetCore, runtime)
+		}
+
+		if len(processes) == 0 {
+			mainProject, err := f.Project.MainPath()
+			if err != nil {
+				return err
+			}
+			p.MainProject = f.relativePath(mainProject)
+
+			args, err := f.publishArgs(mainProject, filepath.Join(f.Stager.DepDir(), "dotnet_publish"), stackRID)
+			if err != nil {
+				return err
+			}
+			p.Publish = append(p.Publish, plan.Publish{Project: p.MainProject, Command: append([]string{"dotnet"}, args...)})
+		}
+
+		for _, process := range processes {
+			args, err := f.publishArgs(process.ProjectPath, f.Project.ProcessPublishDir(process), stackRID)
+			if err != nil {
+				return err
+			}
+			p.Publish = append(p.Publish, plan.Publish{Process: process.Name, Project: f.relativePath(process.ProjectPath), Command: append([]string{"dotnet"}, args...)})
+		}
+	}
+
+	p.Processes, err = f.processTypes(f.Project.PlannedStartCommand, f.Project.PlannedProcessStartCommand)
+	return err
+}
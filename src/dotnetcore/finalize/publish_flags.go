@@ -0,0 +1,130 @@
+package finalize
+
+import (
+	"fmt"
+	"strings"
+)
+
+// buildpackPublishOptions are the dotnet publish options that the buildpack
+// sets itself, by alias
+var buildpackPublishOptions = map[string]string{
+	"-o":                  "--output",
+	"--output":            "--output",
+	"-c":                  "--configuration",
+	"--configuration":     "--configuration",
+	"-f":                  "--framework",
+	"--framework":         "--framework",
+	"-r":                  "--runtime",
+	"--runtime":           "--runtime",
+	"-a":                  "--runtime",
+	"--arch":              "--runtime",
+	"--os":                "--runtime",
+	"--sc":                "--self-contained",
+	"--self-contained":    "--self-contained",
+	"--no-self-contained": "--self-contained",
+}
+
+// buildpackPublishProperties are the MSBuild properties behind the options
+// the buildpack sets, by lower-case name
+var buildpackPublishProperties = map[string]string{
+	"publishdir":        "--output",
+	"outputpath":        "--output",
+	"outdir":            "--output",
+	"configuration":     "--configuration",
+	"targetframework":   "--framework",
+	"targetframeworks":  "--framework",
+	"runtimeidentifier": "--runtime",
+	"selfcontained":     "--self-contained",
+}
+
+// msbuildPropertySwitches set MSBuild properties, as in -p:Name=Value
+var msbuildPropertySwitches = map[string]bool{
+	"-p":         true,
+	"/p":         true,
+	"-property":  true,
+	"/property":  true,
+	"--property": true,
+}
+
+// publishFlagOverrides checks extra dotnet publish flags against the options
+// the buildpack sets, whether given as a switch or as the MSBuild property
+// behind it. The output directory, configuration and framework are rejected
+// since the buildpack relies on them or offers its own setting, while the
+// runtime and self-contained options may be overridden. It returns the
+// overridden options with the lower-case value they are set to, "true" or
+// "false" for --self-contained.
+func (f *Finalizer) publishFlagOverrides(flags []string) (map[string]string, error) {
+	overrides := map[string]string{}
+	for i := 0; i < len(flags); i++ {
+		name, value, hasValue := splitSwitch(flags[i])
+
+		if msbuildPropertySwitches[name] {
+			if !hasValue && i+1 < len(flags) {
+				i++
+				value = flags[i]
+			}
+			for _, property := range strings.Split(value, ";") {
+				parts := strings.SplitN(property, "=", 2)
+				if len(parts) != 2 {
+					continue
+				}
+				propertyName := strings.TrimSpace(parts[0])
+				option := buildpackPublishProperties[strings.ToLower(propertyName)]
+				if err := f.overridePublishOption(overrides, option, name+":"+propertyName, parts[1]); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+
+		option := buildpackPublishOptions[name]
+		if option == "--self-contained" {
+			switch {
+			case name == "--no-self-contained":
+				value = "false"
+			case hasValue:
+			case i+1 < len(flags) && isBoolValue(flags[i+1]):
+				i++
+				value = flags[i]
+			default:
+				value = "true"
+			}
+		}
+		if err := f.overridePublishOption(overrides, option, name, value); err != nil {
+			return nil, err
+		}
+	}
+	return overrides, nil
+}
+
+func (f *Finalizer) overridePublishOption(overrides map[string]string, option, setting, value string) error {
+	switch option {
+	case "":
+		return nil
+	case "--output":
+		return fmt.Errorf("publish flags must not set %s, the buildpack chooses the publish directory", setting)
+	case "--configuration":
+		return fmt.Errorf("publish flags must not set %s, set configuration in buildpack.yml instead", setting)
+	case "--framework":
+		return fmt.Errorf("publish flags must not set %s, set target-framework in buildpack.yml instead", setting)
+	}
+
+	if _, overridden := overrides[option]; !overridden {
+		f.Log.Warning("Publish flags override the %s option set by the buildpack", option)
+	}
+	overrides[option] = strings.ToLower(strings.TrimSpace(value))
+	return nil
+}
+
+// splitSwitch splits a flag like --self-contained=false or -p:Name=Value
+// into its lower-case name and its value
+func splitSwitch(flag string) (string, string, bool) {
+	if i := strings.IndexAny(flag, ":="); i >= 0 {
+		return strings.ToLower(flag[:i]), flag[i+1:], true
+	}
+	return strings.ToLower(flag), "", false
+}
+
+func isBoolValue(value string) bool {
+	return strings.EqualFold(value, "true") || strings.EqualFold(value, "false")
+}
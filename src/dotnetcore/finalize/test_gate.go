@@ -0,0 +1,190 @@
+package finalize
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/project"
+)
+
+// maxReportedTestFailures caps the failure messages printed after dotnet test
+const maxReportedTestFailures = 5
+
+type trxTestRun struct {
+	Results []trxTestResult `xml:"Results>UnitTestResult"`
+}
+
+type trxTestResult struct {
+	TestName string `xml:"testName,attr"`
+	Outcome  string `xml:"outcome,attr"`
+	Message  string `xml:"Output>ErrorInfo>Message"`
+}
+
+type testSummary struct {
+	passed, failed, skipped int
+	failures                []trxTestResult
+}
+
+// RunTests runs dotnet test on the test projects of the app when enabled
+// through buildpack.yml or BP_DOTNET_RUN_TESTS, and fails staging when a test
+// fails. The TRX results are summarized in the staging log.
+func (f *Finalizer) RunTests() error {
+	if !f.runTests() {
+		return nil
+	}
+
+	f.Log.BeginStep("Running dotnet test")
+
+	testProjects, err := f.Project.TestProjects()
+	if err != nil {
+		return err
+	}
+	if len(testProjects) == 0 {
+		f.Log.Warning("Running tests is enabled but the app has no test projects")
+		return nil
+	}
+
+	if err := f.checkTestRuntimes(testProjects); err != nil {
+		return err
+	}
+
+	env, err := f.shellEnvironment()
+	if err != nil {
+		return err
+	}
+
+	configuration := f.publicConfig()
+
+	resultsDir, err := os.MkdirTemp("", "dotnet-core-buildpack.test-results.")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(resultsDir)
+
+	var failedProjects []string
+	for i, testProject := range testProjects {
+		projectResultsDir := filepath.Join(resultsDir, fmt.Sprint(i))
+
+		f.Log.Info("Testing %s", f.relativePath(testProject))
+		cmd := exec.Command("dotnet", "test", testProject, "-c", configuration, "--logger", "trx", "--results-directory", projectResultsDir)
+		cmd.Dir = f.Stager.BuildDir()
+		cmd.Env = env
+		cmd.Stdout = indentWriter(os.Stdout)
+		cmd.Stderr = indentWriter(os.Stderr)
+
+		f.Log.Debug("Running command: %v", cmd)
+		if err := f.Command.Run(cmd); err != nil {
+			failedProjects = append(failedProjects, f.relativePath(testProject))
+		}
+	}
+
+	summary, err := summarizeTestResults(resultsDir)
+	if err != nil {
+		return err
+	}
+
+	f.Log.Info("Tests: %d passed, %d failed, %d skipped", summary.passed, summary.failed, summary.skipped)
+	for i, failure := range summary.failures {
+		if i == maxReportedTestFailures {
+			f.Log.Info("... and %d more failures", len(summary.failures)-maxReportedTestFailures)
+			break
+		}
+		f.Log.Info("Failed %s: %s", failure.TestName, strings.SplitN(strings.TrimSpace(failure.Message), "\n", 2)[0])
+	}
+
+	if len(failedProjects) > 0 {
+		return fmt.Errorf("dotnet test failed for %s", strings.Join(failedProjects, ", "))
+	}
+	return nil
+}
+
+// checkTestRuntimes fails before any test runs when a test project targets a
+// framework whose runtime staging did not install, which dotnet test would
+// only report once it fails to start the test host
+func (f *Finalizer) checkTestRuntimes(testProjects []string) error {
+	var installed []string
+	versions, err := os.ReadDir(filepath.Join(f.Stager.DepDir(), "dotnet-sdk", "shared", "Microsoft.NETCore.App"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, version := range versions {
+		installed = append(installed, version.Name())
+	}
+
+	for _, testProject := range testProjects {
+		frameworks, err := f.Project.TargetFrameworks(testProject)
+		if err != nil {
+			return err
+		}
+
+		for _, framework := range frameworks {
+			line, ok := project.RuntimeLine(framework)
+			if !ok {
+				return fmt.Errorf("test project %s targets %s, which does not run on Linux; set run-tests: false in buildpack.yml to stage without running tests", f.relativePath(testProject), framework)
+			}
+
+			if !hasRuntimeLine(installed, line) {
+				available := "none"
+				if len(installed) > 0 {
+					available = strings.Join(installed, ", ")
+				}
+				return fmt.Errorf("test project %s targets %s, but the installed Microsoft.NETCore.App runtimes are: %s; target the app's runtime or set run-tests: false in buildpack.yml", f.relativePath(testProject), framework, available)
+			}
+		}
+	}
+	return nil
+}
+
+func hasRuntimeLine(versions []string, line string) bool {
+	for _, version := range versions {
+		if strings.HasPrefix(version, line+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Finalizer) runTests() bool {
+	if f.BuildpackYAML.RunTests != nil {
+		return *f.BuildpackYAML.RunTests
+	}
+
+	return os.Getenv("BP_DOTNET_RUN_TESTS") == "true"
+}
+
+func summarizeTestResults(resultsDir string) (testSummary, error) {
+	var summary testSummary
+	err := filepath.Walk(resultsDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Ext(path) != ".trx" {
+			return err
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		var run trxTestRun
+		if err := xml.Unmarshal(content, &run); err != nil {
+			return fmt.Errorf("unable to read test results %s: %v", filepath.Base(path), err)
+		}
+
+		for _, result := range run.Results {
+			switch result.Outcome {
+			case "Passed":
+				summary.passed++
+			case "Failed":
+				summary.failed++
+				summary.failures = append(summary.failures, result)
+			default:
+				summary.skipped++
+			}
+		}
+		return nil
+	})
+	return summary, err
+}
@@ -0,0 +1,93 @@
+package finalize
+
+import (
+	"bytes"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// maxReportedTrimWarnings caps the trimming warnings printed per code
+const maxReportedTrimWarnings = 3
+
+var trimWarningRe = regexp.MustCompile(`^(?:(.*?)\s*:\s*)?warning (IL2\d{3}):\s*(.*?)(?:\s+\[[^\]]*\])?\s*$`)
+
+// trimWarnings collects the IL2xxx trimming analysis warnings from the output
+// of dotnet publish. MSBuild repeats warnings in its final summary, so every
+// message is kept once.
+type trimWarnings struct {
+	partial  []byte
+	seen     map[string]bool
+	messages map[string][]string
+}
+
+func (t *trimWarnings) Write(p []byte) (int, error) {
+	t.partial = append(t.partial, p...)
+	for {
+		i := bytes.IndexByte(t.partial, '\n')
+		if i < 0 {
+			break
+		}
+		t.add(string(t.partial[:i]))
+		t.partial = t.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+func (t *trimWarnings) add(line string) {
+	matches := trimWarningRe.FindStringSubmatch(strings.TrimSpace(line))
+	if matches == nil {
+		return
+	}
+
+	if t.seen == nil {
+		t.seen = map[string]bool{}
+		t.messages = map[string][]string{}
+	}
+
+	code, message := matches[2], matches[3]
+	if matches[1] != "" {
+		message = matches[1] + ": " + message
+	}
+	if t.seen[code+message] {
+		return
+	}
+	t.seen[code+message] = true
+	t.messages[code] = append(t.messages[code], message)
+}
+
+// Report prints the warnings grouped by code, with the first few messages of
+// each
+func (t *trimWarnings) Report(log *libbuildpack.Logger) {
+	if len(t.partial) > 0 {
+		t.add(string(t.partial))
+		t.partial = nil
+	}
+
+	if len(t.messages) == 0 {
+		return
+	}
+
+	var codes []string
+	total := 0
+	for code, messages := range t.messages {
+		codes = append(codes, code)
+		total += len(messages)
+	}
+	sort.Strings(codes)
+
+	log.BeginStep("Trimming produced %d warnings, the app may fail at runtime where trimmed code is used", total)
+	for _, code := range codes {
+		messages := t.messages[code]
+		log.Info("%s (%d):", code, len(messages))
+		for i, message := range messages {
+			if i == maxReportedTrimWarnings {
+				log.Info("  ... and %d more", len(messages)-maxReportedTrimWarnings)
+				break
+			}
+			log.Info("  %s", message)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package nuget
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Feed is a private package source taken from a bound service
+type Feed struct {
+	Name     string
+	URL      string
+	Username string
+	Password string
+}
+
+type vcapService struct {
+	Name        string                 `json:"name"`
+	Label       string                 `json:"label"`
+	Tags        []string               `json:"tags"`
+	Credentials map[string]interface{} `json:"credentials"`
+}
+
+var invalidNameChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// FeedsFromBindings collects NuGet feeds from VCAP_SERVICES and from the
+// bindings below SERVICE_BINDING_ROOT. A VCAP_SERVICES entry is a feed when its
+// label, name or tags mention nuget; a binding directory is a feed when its
+// type is nuget.
+func FeedsFromBindings() ([]Feed, error) {
+	var feeds []Feed
+
+	if vcapServices := os.Getenv("VCAP_SERVICES"); vcapServices != "" {
+		services := map[string][]vcapService{}
+		if err := json.Unmarshal([]byte(vcapServices), &services); err != nil {
+			return nil, fmt.Errorf("could not parse VCAP_SERVICES: %v", err)
+		}
+
+		var labels []string
+		for label := range services {
+			labels = append(labels, label)
+		}
+		sort.Strings(labels)
+
+		for _, label := range labels {
+			for _, service := range services[label] {
+				if !service.isNugetFeed() {
+					continue
+				}
+
+				feed := Feed{
+					Name:     service.Name,
+					URL:      stringCredential(service.Credentials, "url", "uri", "source"),
+					Username: stringCredential(service.Credentials, "username", "user"),
+					Password: stringCredential(service.Credentials, "password", "token", "api_key"),
+				}
+				if feed.URL == "" {
+					return nil, fmt.Errorf("bound service %s has no url in its credentials", service.Name)
+				}
+				feeds = append(feeds, feed.withURLCredentials())
+			}
+		}
+	}
+
+	if bindingRoot := os.Getenv("SERVICE_BINDING_ROOT"); bindingRoot != "" {
+		bindings, err := os.ReadDir(bindingRoot)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		for _, binding := range bindings {
+			if !binding.IsDir() {
+				continue
+			}
+
+			dir := filepath.Join(bindingRoot, binding.Name())
+			if bindingType, err := readBindingFile(dir, "type"); err != nil {
+				return nil, err
+			} else if !strings.EqualFold(bindingType, "nuget") {
+				continue
+			}
+
+			feed := Feed{Name: binding.Name()}
+			for _, field := range []struct {
+				value *string
+				files []string
+			}{
+				{&feed.URL, []string{"url", "uri", "source"}},
+				{&feed.Username, []string{"username", "user"}},
+				{&feed.Password, []string{"password", "token", "api_key"}},
+			} {
+				for _, file := range field.files {
+					value, err := readBindingFile(dir, file)
+					if err != nil {
+						return nil, err
+					}
+					if value != "" {
+						*field.value = value
+						break
+					}
+				}
+			}
+
+			if feed.URL == "" {
+				return nil, fmt.Errorf("service binding %s has no url", binding.Name())
+			}
+			feeds = append(feeds, feed.withURLCredentials())
+		}
+	}
+
+	if err := checkSourceNames(feeds); err != nil {
+		return nil, err
+	}
+	return feeds, nil
+}
+
+// checkSourceNames fails when two feeds map to the same package source key,
+// as one would silently take over the source and credentials of the other.
+// NuGet compares source keys without regard to case.
+func checkSourceNames(feeds []Feed) error {
+	names := map[string]string{}
+	for _, feed := range feeds {
+		key := strings.ToLower(feed.SourceName())
+		if other, found := names[key]; found {
+			return fmt.Errorf("NuGet feeds %s and %s both map to package source %s; rename one of the services", other, feed.Name, feed.SourceName())
+		}
+		names[key] = feed.Name
+	}
+	return nil
+}
+
+func (s vcapService) isNugetFeed() bool {
+	if strings.Contains(strings.ToLower(s.Label), "nuget") || strings.Contains(strings.ToLower(s.Name), "nuget") {
+		return true
+	}
+	for _, tag := range s.Tags {
+		if strings.EqualFold(tag, "nuget") {
+			return true
+		}
+	}
+	return false
+}
+
+func stringCredential(credentials map[string]interface{}, keys ...string) string {
+	for _, key := range keys {
+		if value, ok := credentials[key].(string); ok && value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func readBindingFile(dir, name string) (string, error) {
+	content, err := os.ReadFile(filepath.Join(dir, name))
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+// withURLCredentials moves credentials embedded in the feed URL into the
+// Username and Password fields, unless those are already set.
+func (f Feed) withURLCredentials() Feed {
+	u, err := url.Parse(f.URL)
+	if err != nil || u.User == nil {
+		return f
+	}
+
+	if f.Username == "" && f.Password == "" {
+		f.Username = u.User.Username()
+		f.Password, _ = u.User.Password()
+	}
+	return f
+}
+
+// SourceName is the package source key used for the feed in NuGet.Config. It
+// only contains characters that are valid in an environment variable name, so
+// that credentials can be passed as NuGetPackageSourceCredentials_<name>.
+func (f Feed) SourceName() string {
+	return "cf_" + invalidNameChars.ReplaceAllString(f.Name, "_")
+}
+
+// RedactedURL is the feed URL without any user info and with the values of
+// its query masked, as those often carry tokens. It is safe to print in logs.
+func (f Feed) RedactedURL() string {
+	u, err := url.Parse(f.URL)
+	if err != nil {
+		return "<invalid url>"
+	}
+	u.User = nil
+
+	query := u.Query()
+	for key := range query {
+		query[key] = []string{"redacted"}
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// sourceURL is the feed URL NuGet.Config points at: without user info, which
+// is passed through CredentialEnv, but with the query the feed may need.
+func (f Feed) sourceURL() string {
+	u, err := url.Parse(f.URL)
+	if err != nil {
+		return f.URL
+	}
+	u.User = nil
+	return u.String()
+}
+
+// CredentialEnv returns the NuGetPackageSourceCredentials_* variables for the
+// feeds that have credentials. They are only meant for the environment of the
+// dotnet process and must never be written to disk. NuGet splits the value
+// at ';' without any way to escape it, so credentials containing one are
+// rejected rather than passed on truncated.
+func CredentialEnv(feeds []Feed) ([]string, error) {
+	var env []string
+	for _, feed := range feeds {
+		if feed.Username == "" && feed.Password == "" {
+			continue
+		}
+		if strings.Contains(feed.Username, ";") || strings.Contains(feed.Password, ";") {
+			return nil, fmt.Errorf("the credentials of NuGet feed %s contain a ';', which NuGet cannot read from NuGetPackageSourceCredentials_%s; use a token without ';'", feed.Name, feed.SourceName())
+		}
+		env = append(env, fmt.Sprintf("NuGetPackageSourceCredentials_%s=Username=%s;Password=%s", feed.SourceName(), feed.Username, feed.Password))
+	}
+	return env, nil
+}
+
+type nugetConfig struct {
+	XMLName        xml.Name `xml:"configuration"`
+	PackageSources struct {
+		Add []nugetSource `xml:"add"`
+	} `xml:"packageSources"`
+}
+
+type nugetSource struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:"value,attr"`
+}
+
+// WriteConfig writes a NuGet.Config that adds the feeds as package sources.
+// Credentials are deliberately left out, see CredentialEnv.
+func WriteConfig(path string, feeds []Feed) error {
+	config := nugetConfig{}
+	for _, feed := range feeds {
+		config.PackageSources.Add = append(config.PackageSources.Add, nugetSource{Key: feed.SourceName(), Value: feed.sourceURL()})
+	}
+
+	content, err := xml.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append([]byte(xml.Header), content...), 0644)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/config"
+	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/finalize"
+	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/plan"
+	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/project"
+	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/supply"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// stagingDepsDir and stagingDepsIdx are where a single buildpack stages on a
+// cell, used in the publish and start commands of the plan. Nothing is read
+// from them.
+const (
+	stagingDepsDir = "/tmp/deps"
+	stagingDepsIdx = "0"
+)
+
+// dryRunCommand stands in for the commands supply runs to look for tools on
+// the PATH, as if no earlier buildpack supplied them; the plan lists that
+// under assumptions
+type dryRunCommand struct{}
+
+func (dryRunCommand) Execute(string, io.Writer, io.Writer, string, ...string) error {
+	return errors.New("commands are not run in a dry run")
+}
+
+func (dryRunCommand) Output(string, string, ...string) (string, error) {
+	return "", errors.New("commands are not run in a dry run")
+}
+
+func main() {
+	// stdout is reserved for the plan, the staging log goes to stderr
+	logger := libbuildpack.NewLogger(os.Stderr)
+
+	stackName := flag.String("stack", os.Getenv("CF_STACK"), "stack to plan for, defaults to $CF_STACK")
+	arch := flag.String("arch", config.Architecture(), "architecture to plan for, x64 or arm64")
+	depsDir := flag.String("deps-dir", "", "deps dir whose <index>/override.yml files to apply, as supply does")
+	cacheDir := flag.String("cache-dir", "", "app cache holding the dotnet-buildpack.lock of an earlier staging, for lock: true")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-stack <stack>] [-arch <arch>] [-deps-dir <dir>] [-cache-dir <dir>] <app-dir>\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 || *stackName == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	// libbuildpack selects manifest dependencies by CF_STACK
+	os.Setenv("CF_STACK", *stackName)
+
+	buildDir, err := filepath.Abs(flag.Arg(0))
+	if err != nil {
+		logger.Error("Unable to find app directory: %s", err.Error())
+		os.Exit(3)
+	}
+
+	buildpackDir, err := libbuildpack.GetBuildpackDir()
+	if err != nil {
+		logger.Error("Unable to determine buildpack directory: %s", err.Error())
+		os.Exit(9)
+	}
+
+	manifest, err := libbuildpack.NewManifest(buildpackDir, logger, time.Now())
+	if err != nil {
+		logger.Error("Unable to load buildpack manifest: %s", err.Error())
+		os.Exit(10)
+	}
+
+	if *depsDir != "" {
+		if err := manifest.ApplyOverride(*depsDir); err != nil {
+			logger.Error("Unable to apply override.yml files: %s", err)
+			os.Exit(18)
+		}
+	}
+
+	manifestExtensions, err := config.LoadManifestExtensions(buildpackDir, *depsDir)
+	if err != nil {
+		logger.Error("Unable to load manifest.yml and override.yml extensions: %s", err.Error())
+		os.Exit(11)
+	}
+	manifestExtensions.SelectArchitecture(manifest, *arch)
+
+	stack, err := manifestExtensions.Stack(*stackName)
+	if err != nil {
+		logger.Error("%s", err.Error())
+		os.Exit(12)
+	}
+
+	buildpackYAML, err := config.LoadBuildpackYAML(buildDir)
+	if err != nil {
+		logger.Error("Unable to parse buildpack.yml: %s", err.Error())
+		os.Exit(13)
+	}
+
+	cfg := &config.Config{}
+	policyInstaller := config.NewInstaller(libbuildpack.NewInstaller(manifest), manifest, cfg, *arch)
+	policyInstaller.EOL, err = config.NewEOLEnforcer(manifestExtensions.EOLPolicy, manifest, buildpackYAML, logger, time.Now())
+	if err != nil {
+		logger.Error("Unable to load the EOL policy: %s", err.Error())
+		os.Exit(19)
+	}
+	if policyInstaller.Lock, err = config.LoadLock(buildDir, *cacheDir, buildpackYAML, logger); err != nil {
+		logger.Error("Unable to load %s: %s", config.LockFile, err.Error())
+		os.Exit(20)
+	}
+
+	stager := libbuildpack.NewStager([]string{buildDir, "", stagingDepsDir, stagingDepsIdx}, logger, manifest)
+	dotnetProject := project.New(buildDir, stager.DepDir(), stager.DepsIdx(), manifest, nil, logger)
+	dotnetProject.SharedFrameworks = manifestExtensions.AllSharedFrameworks()
+	dotnetProject.BuildpackYAML = buildpackYAML
+	dotnetProject.Environ = os.Environ()
+
+	s := supply.Supplier{
+		Stager:   stager,
+		Manifest: manifest,
+		Log:      logger,
+		Command:  dryRunCommand{},
+		Config:   &config.Config{},
+		Project:  dotnetProject,
+		Stack:    stack,
+
+		BuildpackYAML: buildpackYAML,
+	}
+
+	f := finalize.Finalizer{
+		Stager:   stager,
+		Log:      logger,
+		Config:   &config.Config{},
+		Project:  dotnetProject,
+		Manifest: manifest,
+		Arch:     *arch,
+		Stack:    stack,
+
+		BuildpackYAML: buildpackYAML,
+	}
+
+	detection, err := dotnetProject.Detect()
+	if err != nil {
+		logger.Error("Unable to detect the app: %s", err.Error())
+		os.Exit(17)
+	}
+	stagingPlan := plan.Plan{Detected: detection.Detected, DetectReasons: detection.Reasons}
+	if err := s.Plan(&stagingPlan); err != nil {
+		logger.Error("Unable to plan supply: %s", err.Error())
+		os.Exit(14)
+	}
+	if err := f.Plan(&stagingPlan); err != nil {
+		logger.Error("Unable to plan finalize: %s", err.Error())
+		os.Exit(15)
+	}
+
+	for i, resolution := range stagingPlan.Dependencies {
+		if stagingPlan.Dependencies[i], err = policyInstaller.Plan(resolution); err != nil {
+			logger.Error("%s", err.Error())
+			os.Exit(21)
+		}
+	}
+
+	out, err := stagingPlan.JSON()
+	if err != nil {
+		logger.Error("Unable to render the plan: %s", err.Error())
+		os.Exit(16)
+	}
+	os.Stdout.Write(out)
+}
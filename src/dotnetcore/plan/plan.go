@@ -0,0 +1,49 @@
+// Package plan describes what staging an app would do, so that it can be
+// checked without downloading or installing anything.
+package plan
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/config"
+)
+
+// Plan is the outcome of staging an app as far as it can be known up front.
+// Supply and finalize each fill in their part. Assumptions lists what the
+// plan takes for granted where staging would look at the cell instead.
+type Plan struct {
+	Detected      bool                `json:"detected"`
+	DetectReasons []string            `json:"detect_reasons"`
+	Stack         string              `json:"stack"`
+	Architecture  string              `json:"architecture"`
+	Published     bool                `json:"published"`
+	NativeAot     bool                `json:"native_aot"`
+	Node          bool                `json:"node"`
+	Bower         bool                `json:"bower"`
+	Libgdiplus    bool                `json:"libgdiplus"`
+	Dependencies  []config.Resolution `json:"dependencies"`
+	MainProject   string              `json:"main_project,omitempty"`
+	Publish       []Publish           `json:"publish,omitempty"`
+	Processes     map[string]string   `json:"processes"`
+	Assumptions   []string            `json:"assumptions,omitempty"`
+}
+
+// Publish is a dotnet publish run, for the main project or for a process
+type Publish struct {
+	Process string   `json:"process,omitempty"`
+	Project string   `json:"project"`
+	Command []string `json:"command"`
+}
+
+// JSON renders the plan for CI checks
+func (p Plan) JSON() ([]byte, error) {
+	out := &bytes.Buffer{}
+	encoder := json.NewEncoder(out)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(p); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,212 @@
+package project
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// bundleSignature marks the executable of a single-file bundle, it is the
+// signature the .NET host looks for in the apphost
+var bundleSignature = []byte{
+	0x8b, 0x12, 0x02, 0xb9, 0x6a, 0x61, 0x20, 0x38,
+	0x72, 0x7b, 0x93, 0x02, 0x14, 0xd7, 0xa0, 0x32,
+	0x13, 0xf5, 0xb9, 0xe6, 0xef, 0xae, 0x33, 0x18,
+	0xee, 0x3b, 0x2d, 0xce, 0x24, 0xb3, 0x6a, 0xae,
+}
+
+// detectSkipDirs are never searched for project files, they hold
+// dependencies or build output rather than the app
+var detectSkipDirs = map[string]bool{
+	".cloudfoundry": true,
+	".git":          true,
+	"node_modules":  true,
+	"bin":           true,
+	"obj":           true,
+}
+
+// Detection is the outcome of Detect together with the reasons for it
+type Detection struct {
+	Detected bool
+	Reasons  []string
+}
+
+func (d *Detection) detected(format string, args ...interface{}) {
+	d.Detected = true
+	d.Reasons = append(d.Reasons, fmt.Sprintf(format, args...))
+}
+
+func (d *Detection) skipped(format string, args ...interface{}) {
+	d.Reasons = append(d.Reasons, fmt.Sprintf(format, args...))
+}
+
+// Detect decides whether the app is a .NET app this buildpack can stage: a
+// published app that RuntimeConfigPath finds, at the root, in published-dir
+// or in a subdirectory, or a project that is not only a test project. With a
+// solution at the root only the projects it lists count. A solution that
+// lists no project of the app, or a global.json without any project, is
+// detected too, so that staging explains what is missing. Single-file bundles are reported but not detected, since staging needs the
+// *.runtimeconfig.json of the publish output.
+func (p *Project) Detect() (Detection, error) {
+	var detection Detection
+
+	if runtimeConfig, err := p.RuntimeConfigPath(); err != nil {
+		detection.skipped("ignored the published app: %v", err)
+	} else if runtimeConfig != "" {
+		detection.detected("found published app %s", p.relativePath(runtimeConfig))
+	}
+
+	bundles, err := p.singleFileBundles()
+	if err != nil {
+		return Detection{}, err
+	}
+	for _, path := range bundles {
+		detection.skipped("ignored single-file bundle %s, staging needs the publish output with its *.runtimeconfig.json", p.relativePath(path))
+	}
+
+	projects, err := p.detectProjectFiles()
+	if err != nil {
+		return Detection{}, err
+	}
+
+	solutionPath, err := p.solutionPath()
+	if err != nil {
+		return Detection{}, err
+	}
+	if solutionPath != "" {
+		solutionProjects, _, err := p.solutionProjects()
+		if err != nil {
+			// Still detected, so that staging reports what is wrong with the
+			// solution rather than another buildpack picking the app up
+			detection.detected("found solution %s, but could not read it: %v", p.relativePath(solutionPath), err)
+			return detection, nil
+		}
+		if len(solutionProjects) == 0 {
+			detection.detected("found solution %s, but none of the projects it lists exist in the app", p.relativePath(solutionPath))
+			return detection, nil
+		}
+
+		projects = nil
+		for _, project := range solutionProjects {
+			projects = append(projects, project.Path)
+		}
+	}
+
+	for _, path := range projects {
+		evaluation, err := p.evaluate(path)
+		if err != nil {
+			// Still detected, so that staging reports what is wrong with the
+			// project rather than another buildpack picking the app up
+			detection.detected("found project %s, but could not evaluate it: %v", p.relativePath(path), err)
+			continue
+		}
+		if isTest, reason := isTestProject(evaluation); isTest {
+			detection.skipped("ignored %s, it %s", p.relativePath(path), reason)
+			continue
+		}
+		detection.detected("found project %s", p.relativePath(path))
+	}
+
+	if !detection.Detected && len(projects) == 0 {
+		if exists, err := libbuildpack.FileExists(filepath.Join(p.buildDir, "global.json")); err != nil {
+			return Detection{}, err
+		} else if exists {
+			detection.detected("found global.json, but no project or published app; staging needs one of them")
+			return detection, nil
+		}
+	}
+
+	if !detection.Detected {
+		detection.skipped("found no *.runtimeconfig.json at the root, in published-dir or in a subdirectory, and no project file outside of node_modules, bin and obj")
+	}
+	return detection, nil
+}
+
+func (p *Project) detectProjectFiles() ([]string, error) {
+	var paths []string
+	err := filepath.Walk(p.buildDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != p.buildDir && detectSkipDirs[info.Name()] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if projectFileRe.MatchString(path) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	return paths, err
+}
+
+// singleFileBundles returns the executables at the root of the app that are
+// single-file bundles
+func (p *Project) singleFileBundles() ([]string, error) {
+	entries, err := os.ReadDir(p.buildDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var bundles []string
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		if !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+			continue
+		}
+
+		path := filepath.Join(p.buildDir, entry.Name())
+		if isBundle, err := isSingleFileBundle(path); err != nil {
+			return nil, err
+		} else if isBundle {
+			bundles = append(bundles, path)
+		}
+	}
+	return bundles, nil
+}
+
+func isSingleFileBundle(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReaderSize(file, 1<<16)
+	magic, err := reader.Peek(4)
+	if err != nil || !bytes.Equal(magic, []byte("\x7fELF")) {
+		return false, nil
+	}
+
+	// Search in overlapping chunks so a signature split across reads is found
+	chunk := make([]byte, 1<<16)
+	var tail []byte
+	for {
+		n, err := reader.Read(chunk)
+		window := append(tail, chunk[:n]...)
+		if bytes.Contains(window, bundleSignature) {
+			return true, nil
+		}
+		if len(window) >= len(bundleSignature) {
+			tail = append([]byte{}, window[len(window)-len(bundleSignature)+1:]...)
+		} else {
+			tail = window
+		}
+
+		if err == io.EOF {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+	}
+}
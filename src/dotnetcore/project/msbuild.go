@@ -0,0 +1,468 @@
+package project
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// msbuildNode is a generic element of an MSBuild project file
+type msbuildNode struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr    `xml:",any,attr"`
+	Content  string        `xml:",chardata"`
+	Children []msbuildNode `xml:",any"`
+}
+
+func (n msbuildNode) attr(name string) string {
+	for _, a := range n.Attrs {
+		if strings.EqualFold(a.Name.Local, name) {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+func (n msbuildNode) child(name string) (msbuildNode, bool) {
+	for _, c := range n.Children {
+		if strings.EqualFold(c.XMLName.Local, name) {
+			return c, true
+		}
+	}
+	return msbuildNode{}, false
+}
+
+type packageReference struct {
+	Include string
+	Version string
+}
+
+// msbuildEvaluator is a lightweight stand-in for MSBuild's evaluation phase.
+// It reads Directory.Build.props, the project file and
+// Directory.Build.targets in order, follows imports that stay inside the app,
+// and collects properties and package references from every PropertyGroup
+// and ItemGroup whose condition holds. Anything it cannot evaluate, such as
+// property functions, is left alone.
+type msbuildEvaluator struct {
+	rootDir           string
+	projectPath       string
+	sdk               string
+	global            map[string]bool
+	properties        map[string]string
+	environment       map[string]string
+	packageReferences []packageReference
+	imported          map[string]bool
+}
+
+var (
+	propertyRefRe        = regexp.MustCompile(`\$\(([A-Za-z_][A-Za-z0-9_.-]*)\)`)
+	getPathOfFileAboveRe = regexp.MustCompile(`^\$\(\[MSBuild\]::GetPathOfFileAbove\(\s*'?([^',)]+)'?\s*(?:,\s*'([^']*)'\s*)?\)\)$`)
+)
+
+// sdkDefaultProperties are set by the props of Microsoft.NET.Sdk after
+// Directory.Build.props and before the project file, unless already set
+var sdkDefaultProperties = []struct{ name, value string }{
+	{"Configuration", "Debug"},
+	{"Platform", "AnyCPU"},
+}
+
+// evaluateProject evaluates the project file at projectPath, which must be
+// inside rootDir, with the given global properties. Properties that are not
+// set fall back to the environment, given as KEY=value pairs, like MSBuild
+// does.
+func evaluateProject(rootDir, projectPath string, globalProperties map[string]string, environ []string) (*msbuildEvaluator, error) {
+	e := &msbuildEvaluator{
+		rootDir:     rootDir,
+		projectPath: projectPath,
+		global:      map[string]bool{},
+		properties:  map[string]string{},
+		environment: map[string]string{},
+		imported:    map[string]bool{},
+	}
+
+	for _, pair := range environ {
+		if name, value, found := strings.Cut(pair, "="); found {
+			e.environment[strings.ToLower(name)] = value
+		}
+	}
+
+	projectDir := filepath.Dir(projectPath)
+	projectFile := filepath.Base(projectPath)
+	e.set("MSBuildProjectDirectory", projectDir)
+	e.set("MSBuildProjectFullPath", projectPath)
+	e.set("MSBuildProjectFile", projectFile)
+	e.set("MSBuildProjectName", strings.TrimSuffix(projectFile, filepath.Ext(projectFile)))
+	e.set("MSBuildProjectExtension", filepath.Ext(projectFile))
+	e.set("MSBuildProjectDirectoryNoRoot", strings.TrimPrefix(projectDir, filepath.VolumeName(projectDir)+string(filepath.Separator)))
+	for name, value := range globalProperties {
+		e.set(name, value)
+		e.global[strings.ToLower(name)] = true
+	}
+
+	if props := e.fileAbove("Directory.Build.props", projectDir); props != "" {
+		if err := e.importFile(props); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, property := range sdkDefaultProperties {
+		if e.Property(property.name) == "" {
+			e.set(property.name, property.value)
+		}
+	}
+
+	if err := e.importFile(projectPath); err != nil {
+		return nil, err
+	}
+
+	if targets := e.fileAbove("Directory.Build.targets", projectDir); targets != "" {
+		if err := e.importFile(targets); err != nil {
+			return nil, err
+		}
+	}
+
+	return e, nil
+}
+
+// Sdk returns the MSBuild SDK named on the project element, e.g.
+// Microsoft.NET.Sdk.Web
+func (e *msbuildEvaluator) Sdk() string {
+	return e.sdk
+}
+
+// Property returns the evaluated value of a property, falling back to the
+// environment the evaluation was given like MSBuild does.
+func (e *msbuildEvaluator) Property(name string) string {
+	if value, found := e.properties[strings.ToLower(name)]; found {
+		return value
+	}
+	return e.environment[strings.ToLower(name)]
+}
+
+func (e *msbuildEvaluator) set(name, value string) {
+	if e.global[strings.ToLower(name)] {
+		return
+	}
+	e.properties[strings.ToLower(name)] = value
+}
+
+func (e *msbuildEvaluator) importFile(path string) error {
+	path = filepath.Clean(path)
+	if e.imported[path] || !e.insideRoot(path) {
+		return nil
+	}
+	e.imported[path] = true
+
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	root := msbuildNode{}
+	if err := xml.Unmarshal(content, &root); err != nil {
+		return err
+	}
+
+	if path == filepath.Clean(e.projectPath) {
+		e.sdk = root.attr("Sdk")
+	}
+
+	thisFileDir := filepath.Dir(path) + string(filepath.Separator)
+	previousDir, previousFile := e.properties["msbuildthisfiledirectory"], e.properties["msbuildthisfile"]
+	e.properties["msbuildthisfiledirectory"] = thisFileDir
+	e.properties["msbuildthisfile"] = filepath.Base(path)
+	defer func() {
+		e.properties["msbuildthisfiledirectory"] = previousDir
+		e.properties["msbuildthisfile"] = previousFile
+	}()
+
+	return e.evaluateChildren(root, thisFileDir)
+}
+
+func (e *msbuildEvaluator) evaluateChildren(parent msbuildNode, thisFileDir string) error {
+	for _, node := range parent.Children {
+		if !e.condition(node.attr("Condition"), thisFileDir) {
+			continue
+		}
+
+		switch strings.ToLower(node.XMLName.Local) {
+		case "propertygroup":
+			for _, property := range node.Children {
+				if e.condition(property.attr("Condition"), thisFileDir) {
+					e.set(property.XMLName.Local, e.expand(strings.TrimSpace(property.Content)))
+				}
+			}
+		case "itemgroup":
+			for _, item := range node.Children {
+				if !strings.EqualFold(item.XMLName.Local, "PackageReference") || !e.condition(item.attr("Condition"), thisFileDir) {
+					continue
+				}
+
+				version := item.attr("Version")
+				if v, found := item.child("Version"); found {
+					version = strings.TrimSpace(v.Content)
+				}
+				e.packageReferences = append(e.packageReferences, packageReference{
+					Include: e.expand(item.attr("Include")),
+					Version: e.expand(version),
+				})
+			}
+		case "import":
+			if path := e.importPath(node.attr("Project"), thisFileDir); path != "" {
+				if err := e.importFile(path); err != nil {
+					return err
+				}
+			}
+		case "importgroup":
+			if err := e.evaluateChildren(node, thisFileDir); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// importPath resolves the Project attribute of an Import. Besides plain paths
+// it understands the GetPathOfFileAbove idiom used to chain
+// Directory.Build.props files.
+func (e *msbuildEvaluator) importPath(project, thisFileDir string) string {
+	if matches := getPathOfFileAboveRe.FindStringSubmatch(strings.TrimSpace(project)); matches != nil {
+		startDir := thisFileDir
+		if matches[2] != "" {
+			startDir = e.expand(matches[2])
+		}
+		if !filepath.IsAbs(startDir) {
+			startDir = filepath.Join(thisFileDir, startDir)
+		}
+		return e.fileAbove(matches[1], filepath.Clean(startDir))
+	}
+
+	path := e.expand(project)
+	if path == "" || strings.ContainsAny(path, "$*?") {
+		return ""
+	}
+
+	path = filepath.FromSlash(strings.ReplaceAll(path, `\`, "/"))
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(thisFileDir, path)
+	}
+	return path
+}
+
+// fileAbove finds the nearest file with the given name in dir or one of its
+// parents, without leaving the app.
+func (e *msbuildEvaluator) fileAbove(name, dir string) string {
+	for e.insideRoot(dir) {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	return ""
+}
+
+func (e *msbuildEvaluator) insideRoot(path string) bool {
+	rel, err := filepath.Rel(e.rootDir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func (e *msbuildEvaluator) expand(value string) string {
+	return propertyRefRe.ReplaceAllStringFunc(value, func(ref string) string {
+		return e.Property(propertyRefRe.FindStringSubmatch(ref)[1])
+	})
+}
+
+// condition evaluates the simple conditions found in most project files:
+// string comparisons, booleans and Exists() combined with 'and', 'or', '!'
+// and parentheses. Conditions it does not understand are treated as false.
+func (e *msbuildEvaluator) condition(condition, thisFileDir string) bool {
+	if strings.TrimSpace(condition) == "" {
+		return true
+	}
+
+	tokens, ok := tokenizeCondition(condition)
+	if !ok {
+		return false
+	}
+
+	parser := &conditionParser{evaluator: e, tokens: tokens, thisFileDir: thisFileDir}
+	holds, ok := parser.or()
+	return ok && parser.pos == len(parser.tokens) && holds
+}
+
+type conditionToken struct {
+	value  string
+	quoted bool
+}
+
+// tokenizeCondition splits a condition into quoted strings, operators,
+// parentheses and bare words. Quoted strings are kept whole, so that 'and'
+// or 'or' inside them are not taken for operators.
+func tokenizeCondition(condition string) ([]conditionToken, bool) {
+	var tokens []conditionToken
+	for i := 0; i < len(condition); {
+		switch c := condition[i]; {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '\'':
+			end := strings.IndexByte(condition[i+1:], '\'')
+			if end < 0 {
+				return nil, false
+			}
+			tokens = append(tokens, conditionToken{value: condition[i+1 : i+1+end], quoted: true})
+			i += end + 2
+		case c == '(' || c == ')':
+			tokens = append(tokens, conditionToken{value: string(c)})
+			i++
+		case strings.HasPrefix(condition[i:], "==") || strings.HasPrefix(condition[i:], "!="):
+			tokens = append(tokens, conditionToken{value: condition[i : i+2]})
+			i += 2
+		case c == '!':
+			tokens = append(tokens, conditionToken{value: "!"})
+			i++
+		default:
+			start := i
+			for i < len(condition) && !strings.ContainsRune(" \t\r\n'()=!", rune(condition[i])) {
+				if strings.HasPrefix(condition[i:], "$(") {
+					end := strings.IndexByte(condition[i:], ')')
+					if end < 0 {
+						return nil, false
+					}
+					i += end + 1
+					continue
+				}
+				i++
+			}
+			if i == start {
+				return nil, false
+			}
+			tokens = append(tokens, conditionToken{value: condition[start:i]})
+		}
+	}
+	return tokens, true
+}
+
+// conditionParser evaluates tokens by recursive descent, 'and' binding
+// tighter than 'or'. Every method reports false as its second value when the
+// condition is not understood.
+type conditionParser struct {
+	evaluator   *msbuildEvaluator
+	tokens      []conditionToken
+	pos         int
+	thisFileDir string
+}
+
+func (p *conditionParser) peek(value string) bool {
+	return p.pos < len(p.tokens) && !p.tokens[p.pos].quoted && strings.EqualFold(p.tokens[p.pos].value, value)
+}
+
+func (p *conditionParser) or() (bool, bool) {
+	holds, ok := p.and()
+	for ok && p.peek("or") {
+		p.pos++
+		var next bool
+		next, ok = p.and()
+		holds = holds || next
+	}
+	return holds, ok
+}
+
+func (p *conditionParser) and() (bool, bool) {
+	holds, ok := p.unary()
+	for ok && p.peek("and") {
+		p.pos++
+		var next bool
+		next, ok = p.unary()
+		holds = holds && next
+	}
+	return holds, ok
+}
+
+func (p *conditionParser) unary() (bool, bool) {
+	if p.peek("!") {
+		p.pos++
+		holds, ok := p.unary()
+		return !holds, ok
+	}
+
+	if p.peek("(") {
+		p.pos++
+		holds, ok := p.or()
+		if !ok || !p.peek(")") {
+			return false, false
+		}
+		p.pos++
+		return holds, true
+	}
+
+	if p.peek("exists") {
+		p.pos++
+		if !p.peek("(") {
+			return false, false
+		}
+		p.pos++
+		path, ok := p.operand()
+		if !ok || !p.peek(")") {
+			return false, false
+		}
+		p.pos++
+
+		path = filepath.FromSlash(strings.ReplaceAll(path, `\`, "/"))
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(p.thisFileDir, path)
+		}
+		_, err := os.Stat(path)
+		return err == nil, true
+	}
+
+	left, ok := p.operand()
+	if !ok {
+		return false, false
+	}
+
+	for _, operator := range []string{"==", "!="} {
+		if p.peek(operator) {
+			p.pos++
+			right, ok := p.operand()
+			if !ok {
+				return false, false
+			}
+			return strings.EqualFold(left, right) == (operator == "=="), true
+		}
+	}
+
+	switch strings.ToLower(left) {
+	case "true":
+		return true, true
+	case "false":
+		return false, true
+	}
+	return false, false
+}
+
+// operand returns the expanded value of a quoted string or a bare word
+func (p *conditionParser) operand() (string, bool) {
+	if p.pos >= len(p.tokens) {
+		return "", false
+	}
+
+	token := p.tokens[p.pos]
+	if !token.quoted {
+		switch strings.ToLower(token.value) {
+		case "(", ")", "==", "!=", "!", "and", "or":
+			return "", false
+		}
+	}
+	p.pos++
+	return p.evaluator.expand(token.value), true
+}
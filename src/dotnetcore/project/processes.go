@@ -0,0 +1,221 @@
+package project
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/config"
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/go-ini/ini"
+)
+
+// procfileLineRe matches a process type of a Procfile and its command
+var procfileLineRe = regexp.MustCompile(`^([A-Za-z0-9_-]+):\s*(.+)$`)
+
+// Process is a process type that is published from its own project into its
+// own directory below dotnet_publish. Command is the command it declares in
+// buildpack.yml, if any.
+type Process struct {
+	Name        string
+	ProjectPath string
+	Command     string
+}
+
+// Processes returns the process types with a project declared under
+// processes in buildpack.yml or in the [processes] section of .deployment,
+// sorted by name. It is empty when the app declares none and is published as
+// a single web process, and for apps that were pushed already published.
+func (p *Project) Processes() ([]Process, error) {
+	if published, err := p.IsPublished(); err != nil || published {
+		return nil, err
+	}
+
+	source := "buildpack.yml"
+	projects := map[string]string{}
+	for name, process := range p.BuildpackYAML.Processes {
+		if process.Project != "" {
+			projects[name] = process.Project
+		}
+	}
+
+	if len(projects) == 0 {
+		source = ".deployment"
+		var err error
+		if projects, err = p.deploymentProcesses(); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(projects) == 0 {
+		return nil, nil
+	}
+
+	solutionProjects, hasSolution, err := p.solutionProjects()
+	if err != nil {
+		return nil, err
+	}
+
+	var processes []Process
+	for name, project := range projects {
+		projectPath, err := p.resolveProjectPath(source, project, solutionProjects, hasSolution)
+		if err != nil {
+			return nil, fmt.Errorf("process %s: %v", name, err)
+		}
+		processes = append(processes, Process{Name: name, ProjectPath: projectPath, Command: p.BuildpackYAML.Processes[name].Command})
+	}
+	sort.Slice(processes, func(i, j int) bool { return processes[i].Name < processes[j].Name })
+
+	return processes, nil
+}
+
+func (p *Project) deploymentProcesses() (map[string]string, error) {
+	deploymentPath := filepath.Join(p.buildDir, ".deployment")
+	if exists, err := libbuildpack.FileExists(deploymentPath); err != nil || !exists {
+		return nil, err
+	}
+
+	deployment, err := ini.Load(deploymentPath)
+	if err != nil {
+		return nil, err
+	}
+
+	section, err := deployment.GetSection("processes")
+	if err != nil {
+		return nil, nil
+	}
+
+	projects := map[string]string{}
+	for _, key := range section.Keys() {
+		if !config.ValidProcessName(key.Name()) {
+			return nil, fmt.Errorf("process %q in .deployment may only contain letters, digits, '-' and '_'", key.Name())
+		}
+		projects[key.Name()] = filepath.Clean(key.String())
+	}
+	return projects, nil
+}
+
+// ProcessCommands returns the commands of the process types declared in the
+// Procfile at the root of the app and of those declared under processes in
+// buildpack.yml with a command but no project. A process type may only be
+// declared in one of them.
+func (p *Project) ProcessCommands() (map[string]string, error) {
+	commands, err := p.Procfile()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for name := range p.BuildpackYAML.Processes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		process := p.BuildpackYAML.Processes[name]
+		if _, inProcfile := commands[name]; inProcfile {
+			return nil, fmt.Errorf("process %s is declared in both Procfile and buildpack.yml", name)
+		}
+		if process.Project == "" {
+			commands[name] = strings.TrimSpace(process.Command)
+		}
+	}
+	return commands, nil
+}
+
+// Procfile returns the process types and commands of the Procfile at the
+// root of the app, empty when there is none
+func (p *Project) Procfile() (map[string]string, error) {
+	content, err := os.ReadFile(filepath.Join(p.buildDir, "Procfile"))
+	if os.IsNotExist(err) {
+		return map[string]string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	commands := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		matches := procfileLineRe.FindStringSubmatch(text)
+		if matches == nil {
+			return nil, fmt.Errorf("invalid Procfile: line %d: expected <process type>: <command>", line)
+		}
+		if _, exists := commands[matches[1]]; exists {
+			return nil, fmt.Errorf("invalid Procfile: line %d: process type %s is declared twice", line, matches[1])
+		}
+		commands[matches[1]] = matches[2]
+	}
+	return commands, scanner.Err()
+}
+
+// ProcessPublishDir is where dotnet publish writes the output of a process
+func (p *Project) ProcessPublishDir(process Process) string {
+	return filepath.Join(p.depDir, "dotnet_publish", process.Name)
+}
+
+// ProcessStartCommand returns the command that starts the published entry
+// point of a process
+func (p *Project) ProcessStartCommand(process Process) (string, error) {
+	assemblyName, err := p.projectAssemblyName(process.ProjectPath)
+	if err != nil {
+		return "", err
+	}
+
+	return p.startCommandIn(
+		p.ProcessPublishDir(process),
+		filepath.Join("${DEPS_DIR}", p.depsIdx, "dotnet_publish", process.Name),
+		assemblyName,
+	)
+}
+
+// PlannedStartCommand predicts the command StartCommand returns once the app
+// has been published, for when it has not been published yet
+func (p *Project) PlannedStartCommand() (string, error) {
+	if published, err := p.IsPublished(); err != nil {
+		return "", err
+	} else if published {
+		return p.StartCommand()
+	}
+
+	projectPath, err := p.MainPath()
+	if err != nil || projectPath == "" {
+		return "", err
+	}
+	return p.plannedStartCommandIn(filepath.Join("${DEPS_DIR}", p.depsIdx, "dotnet_publish"), projectPath)
+}
+
+// PlannedProcessStartCommand predicts the command ProcessStartCommand returns
+// once the process has been published
+func (p *Project) PlannedProcessStartCommand(process Process) (string, error) {
+	return p.plannedStartCommandIn(filepath.Join("${DEPS_DIR}", p.depsIdx, "dotnet_publish", process.Name), process.ProjectPath)
+}
+
+// plannedStartCommandIn runs the apphost dotnet publish writes for the
+// runtime identifier it is given, or the dll when the project turns the
+// apphost off
+func (p *Project) plannedStartCommandIn(runtimePath, projectPath string) (string, error) {
+	assemblyName, err := p.projectAssemblyName(projectPath)
+	if err != nil {
+		return "", err
+	}
+
+	evaluation, err := p.evaluate(projectPath)
+	if err != nil {
+		return "", err
+	}
+
+	if strings.EqualFold(evaluation.Property("UseAppHost"), "false") && !strings.EqualFold(evaluation.Property("PublishAot"), "true") {
+		return filepath.Join(runtimePath, assemblyName+".dll"), nil
+	}
+	return filepath.Join(runtimePath, assemblyName), nil
+}
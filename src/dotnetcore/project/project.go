@@ -3,36 +3,52 @@ package project
 import (
 	"bytes"
 	"encoding/json"
-	"encoding/xml"
 	"errors"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/blang/semver"
+	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/config"
 	"github.com/cloudfoundry/libbuildpack"
 	"github.com/go-ini/ini"
 	jsm "github.com/gravityblast/go-jsmin"
 	werrors "github.com/pkg/errors"
 )
 
+// CSProj holds the evaluated properties and package references of a project
+// file that the buildpack cares about
 type CSProj struct {
 	PropertyGroup struct {
-		TargetFramework         string `xml:"TargetFramework"`
-		RuntimeFrameworkVersion string `xml:"RuntimeFrameworkVersion"`
-		AssemblyName            string `xml:"AssemblyName"`
+		TargetFramework         string
+		RuntimeFrameworkVersion string
+		AssemblyName            string
 	}
-	ItemGroups []struct {
-		PackageReferences []struct {
-			Include string `xml:"Include,attr"`
-			Version string `xml:"Version,attr"`
-		} `xml:"PackageReference"`
-	} `xml:"ItemGroup"`
+	ItemGroups []ItemGroup
+
+	path       string
+	properties map[string]string
+}
+
+type ItemGroup struct {
+	PackageReferences []PackageReference
+}
+
+type PackageReference struct {
+	Include string
+	Version string
+}
+
+// Property returns the evaluated value of any property of the project
+func (c CSProj) Property(name string) string {
+	return c.properties[strings.ToLower(name)]
 }
 
+var targetFrameworkRE = regexp.MustCompile(`net(?:coreapp)?(\d+\.\d+)`)
+
 type Framework struct {
 	Name    string `json:"name"`
 	Version string `json:"version"`
@@ -61,6 +77,21 @@ type Project struct {
 	manifest  Manifest
 	installer Installer
 	Log       *libbuildpack.Logger
+
+	chosenTargetFrameworks map[string]string
+	startupProject         string
+	resolutions            []config.Resolution
+
+	// SharedFrameworks maps runtimeconfig.json framework names to manifest
+	// dependencies, config.DefaultSharedFrameworks is used when it is nil
+	SharedFrameworks []config.SharedFramework
+
+	// BuildpackYAML is the dotnet-core section of the app's buildpack.yml
+	BuildpackYAML config.BuildpackYAML
+
+	// Environ is the environment dotnet runs in, as KEY=value pairs. Project
+	// files fall back to it for properties they do not set.
+	Environ []string
 }
 
 func New(buildDir, depDir, depsIdx string, manifest Manifest, installer Installer, logger *libbuildpack.Logger) *Project {
@@ -71,6 +102,8 @@ func New(buildDir, depDir, depsIdx string, manifest Manifest, installer Installe
 		manifest:  manifest,
 		installer: installer,
 		Log:       logger,
+
+		chosenTargetFrameworks: map[string]string{},
 	}
 }
 
@@ -83,6 +116,19 @@ func (p *Project) IsPublished() (bool, error) {
 }
 
 func (p *Project) StartCommand() (string, error) {
+	assemblyName, err := p.assemblyName()
+	if err != nil {
+		return "", err
+	} else if assemblyName == "" {
+		return "", nil
+	}
+
+	return p.publishedStartCommand(assemblyName)
+}
+
+// assemblyName is the base name of the entry assembly, taken from the
+// runtimeconfig.json of a published app or from the main project file
+func (p *Project) assemblyName() (string, error) {
 	projectPath, err := p.MainPath()
 	if err != nil {
 		return "", err
@@ -90,26 +136,74 @@ func (p *Project) StartCommand() (string, error) {
 		return "", nil
 	}
 	runtimeConfigRe := regexp.MustCompile(`\.(runtimeconfig\.json)$`)
-	projRe := regexp.MustCompile(`\.([a-z]+proj)$`)
 
 	if runtimeConfigRe.MatchString(projectPath) {
 		projectPath = runtimeConfigRe.ReplaceAllString(projectPath, "")
-		projectPath = filepath.Base(projectPath)
-	} else if projRe.MatchString(projectPath) {
-		proj, err := p.parseProj()
+		return filepath.Base(projectPath), nil
+	} else if regexp.MustCompile(`\.([a-z]+proj)$`).MatchString(projectPath) {
+		return p.projectAssemblyName(projectPath)
+	}
+
+	return projectPath, nil
+}
+
+// projectAssemblyName is the AssemblyName of a project file, which defaults
+// to the name of the file
+func (p *Project) projectAssemblyName(projectPath string) (string, error) {
+	proj, err := p.parseProjAt(projectPath)
+	if err != nil {
+		return "", err
+	}
+
+	projRe := regexp.MustCompile(`\.([a-z]+proj)$`)
+	if proj.PropertyGroup.AssemblyName != "" {
+		return projRe.ReplaceAllString(proj.PropertyGroup.AssemblyName, ""), nil
+	}
+	return filepath.Base(projRe.ReplaceAllString(projectPath, "")), nil
+}
+
+// PublishedFrameworks returns the shared frameworks listed in the
+// runtimeconfig.json files that dotnet publish wrote for the main project or
+// for every process
+func (p *Project) PublishedFrameworks() ([]Framework, error) {
+	processes, err := p.Processes()
+	if err != nil {
+		return nil, err
+	}
+
+	var runtimeConfigPaths []string
+	if len(processes) == 0 {
+		assemblyName, err := p.assemblyName()
 		if err != nil {
-			return "", err
+			return nil, err
 		}
-
-		if proj.PropertyGroup.AssemblyName != "" {
-			projectPath = projRe.ReplaceAllString(proj.PropertyGroup.AssemblyName, "")
-		} else {
-			projectPath = projRe.ReplaceAllString(projectPath, "")
-			projectPath = filepath.Base(projectPath)
+		runtimeConfigPaths = append(runtimeConfigPaths, filepath.Join(p.depDir, "dotnet_publish", assemblyName+".runtimeconfig.json"))
+	}
+	for _, process := range processes {
+		assemblyName, err := p.projectAssemblyName(process.ProjectPath)
+		if err != nil {
+			return nil, err
 		}
+		runtimeConfigPaths = append(runtimeConfigPaths, filepath.Join(p.ProcessPublishDir(process), assemblyName+".runtimeconfig.json"))
 	}
 
-	return p.publishedStartCommand(projectPath)
+	var frameworks []Framework
+	for _, path := range runtimeConfigPaths {
+		runtimeConfig, err := parseRuntimeConfig(path)
+		if os.IsNotExist(err) {
+			// Single-file bundles embed their runtimeconfig.json
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		for _, fw := range append([]Framework{runtimeConfig.RuntimeOptions.Framework}, runtimeConfig.RuntimeOptions.Frameworks...) {
+			if fw.Name != "" {
+				frameworks = append(frameworks, fw)
+			}
+		}
+	}
+	return frameworks, nil
 }
 
 func (p *Project) FindMatchingFrameworkVersion(name, version string, applyPatches *bool) (string, error) {
@@ -131,7 +225,12 @@ func (p *Project) FindMatchingFrameworkVersionWithPreview(name, version string,
 }
 
 func (p *Project) GetVersionFromDepsJSON(library string) (string, error) {
-	depsJSONFiles, err := filepath.Glob(filepath.Join(p.buildDir, "*.deps.json"))
+	publishedDir, err := p.PublishedDir()
+	if err != nil {
+		return "", err
+	}
+
+	depsJSONFiles, err := filepath.Glob(filepath.Join(publishedDir, "*.deps.json"))
 	if err != nil {
 		return "", err
 	}
@@ -221,15 +320,112 @@ func (p *Project) IsFsharp() (bool, error) {
 	return false, nil
 }
 
+// PublishedDir is the directory of the app that holds the publish output of
+// an app that was pushed already published: the published-dir of
+// buildpack.yml, else the root of the app, or the one subdirectory holding a
+// *.runtimeconfig.json when the app has neither one at the root nor a
+// project file
+func (p *Project) PublishedDir() (string, error) {
+	publishedDir, _, err := p.publishedDir()
+	return publishedDir, err
+}
+
+func (p *Project) publishedDir() (string, bool, error) {
+	if p.BuildpackYAML.PublishedDir != "" {
+		return filepath.Join(p.buildDir, p.BuildpackYAML.PublishedDir), true, nil
+	}
+
+	if configFiles, err := filepath.Glob(filepath.Join(p.buildDir, "*.runtimeconfig.json")); err != nil || len(configFiles) > 0 {
+		return p.buildDir, false, err
+	}
+
+	nestedConfigFiles, err := filepath.Glob(filepath.Join(p.buildDir, "*", "*.runtimeconfig.json"))
+	if err != nil {
+		return "", false, err
+	}
+	var dirs []string
+	for _, path := range nestedConfigFiles {
+		dir := filepath.Dir(path)
+		if !detectSkipDirs[filepath.Base(dir)] && (len(dirs) == 0 || dirs[len(dirs)-1] != dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	if len(dirs) == 0 {
+		return p.buildDir, false, nil
+	}
+
+	// An app with project files is built from source, whatever output it
+	// carries along
+	if projects, err := p.detectProjectFiles(); err != nil || len(projects) > 0 {
+		return p.buildDir, false, err
+	}
+
+	if len(dirs) > 1 {
+		var names []string
+		for _, dir := range dirs {
+			names = append(names, p.relativePath(dir))
+		}
+		return "", false, fmt.Errorf("found published apps in %s, set published-dir in buildpack.yml to pick one", strings.Join(names, ", "))
+	}
+	return dirs[0], false, nil
+}
+
+// RuntimeConfigPath returns the runtimeconfig.json of the entry assembly of
+// an app that was pushed already published. When the published directory
+// holds several, for example of tools or plugins, the entry one is the one
+// with an apphost, else with a deps.json of the same name.
 func (p *Project) RuntimeConfigPath() (string, error) {
-	if configFiles, err := filepath.Glob(filepath.Join(p.buildDir, "*.runtimeconfig.json")); err != nil {
+	publishedDir, configured, err := p.publishedDir()
+	if err != nil {
+		return "", err
+	}
+
+	configFiles, err := filepath.Glob(filepath.Join(publishedDir, "*.runtimeconfig.json"))
+	if err != nil {
 		return "", err
+	} else if len(configFiles) == 0 && configured {
+		return "", fmt.Errorf("published-dir %s in buildpack.yml holds no *.runtimeconfig.json", p.relativePath(publishedDir))
+	} else if len(configFiles) == 0 {
+		return "", nil
 	} else if len(configFiles) == 1 {
 		return configFiles[0], nil
-	} else if len(configFiles) > 1 {
-		return "", fmt.Errorf("multiple *.runtimeconfig.json files present")
 	}
-	return "", nil
+
+	for _, companion := range []func(base string) (bool, error){isAppHost, hasDepsJSON} {
+		var matches []string
+		for _, path := range configFiles {
+			if found, err := companion(strings.TrimSuffix(path, ".runtimeconfig.json")); err != nil {
+				return "", err
+			} else if found {
+				matches = append(matches, path)
+			}
+		}
+		if len(matches) == 1 {
+			return matches[0], nil
+		} else if len(matches) > 1 {
+			configFiles = matches
+		}
+	}
+
+	var names []string
+	for _, path := range configFiles {
+		names = append(names, p.relativePath(path))
+	}
+	return "", fmt.Errorf("multiple *.runtimeconfig.json files present, could not tell which of %s belongs to the entry assembly", strings.Join(names, ", "))
+}
+
+func isAppHost(path string) (bool, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return info.Mode().IsRegular() && info.Mode().Perm()&0111 != 0, nil
+}
+
+func hasDepsJSON(base string) (bool, error) {
+	return libbuildpack.FileExists(base + ".deps.json")
 }
 
 func (p *Project) MainPath() (string, error) {
@@ -240,7 +436,27 @@ func (p *Project) MainPath() (string, error) {
 		return runtimeConfigFile, nil
 	}
 
-	paths, err := p.ProjectFilePaths()
+	solutionProjects, hasSolution, err := p.solutionProjects()
+	if err != nil {
+		return "", err
+	}
+
+	if p.BuildpackYAML.Project != "" {
+		return p.resolveProjectPath("buildpack.yml", p.BuildpackYAML.Project, solutionProjects, hasSolution)
+	}
+
+	if processes, err := p.Processes(); err != nil {
+		return "", err
+	} else if len(processes) > 0 {
+		for _, process := range processes {
+			if process.Name == "web" {
+				return process.ProjectPath, nil
+			}
+		}
+		return processes[0].ProjectPath, nil
+	}
+
+	paths, err := p.appProjectPaths()
 	if err != nil {
 		return "", err
 	}
@@ -269,12 +485,90 @@ func (p *Project) MainPath() (string, error) {
 			return filepath.Join(p.buildDir, strings.Trim(project.String(), ".")), nil
 		}
 
-		return "", fmt.Errorf("multiple paths: %v contain a project file, but no .deployment file was used", paths)
+		return p.selectStartupProject(paths)
 	}
 
 	return "", nil
 }
 
+// appProjectPaths returns the projects of the solution at the root of the
+// app, or every project file when there is none. A solution none of whose
+// projects are in the app is an error rather than an app without projects.
+func (p *Project) appProjectPaths() ([]string, error) {
+	solutionProjects, hasSolution, err := p.solutionProjects()
+	if err != nil {
+		return nil, err
+	} else if !hasSolution {
+		return p.ProjectFilePaths()
+	}
+
+	if len(solutionProjects) == 0 {
+		solutionPath, err := p.solutionPath()
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("none of the projects listed in %s exist in the app; fix the solution or set project in buildpack.yml", p.relativePath(solutionPath))
+	}
+
+	var paths []string
+	for _, project := range solutionProjects {
+		paths = append(paths, project.Path)
+	}
+	return paths, nil
+}
+
+// resolveProjectPath resolves a project named in buildpack.yml or
+// .deployment, either a path relative to the app root or the name of a
+// project in the solution
+func (p *Project) resolveProjectPath(source, project string, solutionProjects []solutionProject, hasSolution bool) (string, error) {
+	if projectFileRe.MatchString(project) {
+		projectPath := filepath.Join(p.buildDir, project)
+		if inside, err := p.insideBuildDir(projectPath); err != nil {
+			return "", err
+		} else if !inside {
+			return "", fmt.Errorf("project %s specified in %s is outside of the app", project, source)
+		}
+		if exists, err := libbuildpack.FileExists(projectPath); err != nil {
+			return "", err
+		} else if !exists {
+			return "", fmt.Errorf("project %s specified in %s does not exist", project, source)
+		}
+		return projectPath, nil
+	}
+
+	if !hasSolution {
+		return "", fmt.Errorf("project %s specified in %s is not a project file and there is no solution file to look it up in", project, source)
+	}
+
+	var names []string
+	for _, solutionProject := range solutionProjects {
+		if strings.EqualFold(solutionProject.Name, project) {
+			return solutionProject.Path, nil
+		}
+		names = append(names, p.relativePath(solutionProject.Path))
+	}
+	return "", fmt.Errorf("project %s specified in %s is not in the solution, it contains: %s", project, source, strings.Join(names, ", "))
+}
+
+// insideBuildDir reports whether path stays inside the app, also once
+// symlinks are followed
+func (p *Project) insideBuildDir(path string) (bool, error) {
+	buildDir, err := filepath.EvalSymlinks(p.buildDir)
+	if err != nil {
+		return false, err
+	}
+
+	resolved, err := filepath.EvalSymlinks(path)
+	if os.IsNotExist(err) {
+		resolved, buildDir = filepath.Clean(path), filepath.Clean(p.buildDir)
+	} else if err != nil {
+		return false, err
+	}
+
+	rel, err := filepath.Rel(buildDir, resolved)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)), nil
+}
+
 func (p *Project) IsFDD() (bool, error) {
 	path, err := p.RuntimeConfigPath()
 	if err != nil {
@@ -315,70 +609,263 @@ func (p *Project) FDDInstallFrameworks() error {
 	}
 
 	applyPatches := runtimeConfig.RuntimeOptions.ApplyPatches
+	installed := map[string]bool{}
 
 	for _, fw := range append([]Framework{runtimeConfig.RuntimeOptions.Framework}, runtimeConfig.RuntimeOptions.Frameworks...) {
-		switch fw.Name {
-		case "":
+		if fw.Name == "" {
 			continue
-		case "Microsoft.NETCore.App":
-			if err := p.fddInstallFrameworksNETCoreApp(fw.Name, fw.Version, applyPatches); err != nil {
-				return err
-			}
-		case "Microsoft.AspNetCore.App":
-			if err := p.fddInstallFrameworksAspNetCoreApp(fw.Name, fw.Version, applyPatches); err != nil {
+		}
+
+		if _, found := p.sharedFrameworkDependency(fw.Name); !found {
+			return fmt.Errorf("invalid framework '%s' specified in %s, add it to shared_frameworks in override.yml to install it", fw.Name, filepath.Base(path))
+		}
+
+		if err := p.fddInstallSharedFramework(fw, applyPatches, filepath.Base(path), installed); err != nil {
+			return err
+		}
+
+		if fw.Name == "Microsoft.NETCore.App" {
+			if err := p.fddInstallLegacyAspNetCore(); err != nil {
 				return err
 			}
-		default:
-			return fmt.Errorf("invalid framework '%s' specified in %s", fw.Name, filepath.Base(path))
 		}
 	}
 	return nil
 }
 
 func (p *Project) SourceInstallDotnetRuntime() error {
-	proj, err := p.parseProj()
+	runtime, aspNetCore, err := p.SourceRuntimeVersions()
 	if err != nil {
 		return err
 	}
 
-	runtimeVersion := proj.PropertyGroup.RuntimeFrameworkVersion
-	if runtimeVersion != "" {
-		matches := regexp.MustCompile(`\d\.\d\.\d`).FindStringSubmatch(runtimeVersion)
+	err = p.installer.InstallDependency(
+		libbuildpack.Dependency{Name: "dotnet-aspnetcore", Version: aspNetCore.Version},
+		filepath.Join(p.depDir, "dotnet-sdk"),
+	)
+
+	if err != nil {
+		return err
+	}
+	p.recordResolution(aspNetCore)
+
+	if err := p.installer.InstallDependency(
+		libbuildpack.Dependency{Name: "dotnet-runtime", Version: runtime.Version},
+		filepath.Join(p.depDir, "dotnet-sdk"),
+	); err != nil {
+		return err
+	}
+	p.recordResolution(runtime)
+	return nil
+}
+
+// SourceRuntimeVersions resolves the dotnet-runtime and dotnet-aspnetcore
+// versions a source-based app runs on, from buildpack.yml or the main
+// project's RuntimeFrameworkVersion or target framework
+func (p *Project) SourceRuntimeVersions() (config.Resolution, config.Resolution, error) {
+	proj, err := p.parseProj()
+	if err != nil {
+		return config.Resolution{}, config.Resolution{}, err
+	}
+
+	runtime, err := p.buildpackYAMLResolution("dotnet-runtime")
+	if err != nil {
+		return config.Resolution{}, config.Resolution{}, err
+	}
+
+	if runtime.Version != "" {
+		p.Log.Info("Using dotnet-runtime %s from buildpack.yml", runtime.Version)
+	} else if runtimeFrameworkVersion := proj.PropertyGroup.RuntimeFrameworkVersion; runtimeFrameworkVersion != "" {
+		runtime = config.Resolution{Name: "dotnet-runtime", Constraint: runtimeFrameworkVersion, Source: p.relativePath(proj.path), Version: runtimeFrameworkVersion, Steps: []string{"exact version"}}
+		matches := regexp.MustCompile(`\d\.\d\.\d`).FindStringSubmatch(runtimeFrameworkVersion)
 		if len(matches) != 1 {
-			runtimeVersion, err = p.rollForward("dotnet-runtime", runtimeVersion)
+			runtime.Version, runtime.Steps, err = p.rollForwardSteps("dotnet-runtime", runtimeFrameworkVersion)
 			if err != nil {
-				return err
+				return config.Resolution{}, config.Resolution{}, err
 			}
 		}
 	} else {
-		// This regular expression matches on 'net<x>.<y>',
-		// 'net<x>.<y>-<platform>' & 'netcoreapp<x>.<y>'
-		targetFrameworkRE := regexp.MustCompile(`net(?:coreapp)?(\d\.\d)(?:\w+)?`)
-		matches := targetFrameworkRE.FindStringSubmatch(proj.PropertyGroup.TargetFramework)
-		if len(matches) == 2 {
-			runtimeVersionMinor := matches[1]
-			runtimeVersion, err = p.rollForward("dotnet-runtime", runtimeVersionMinor)
-			if err != nil {
-				return err
+		targetFramework, err := p.targetFramework(proj)
+		if err != nil {
+			return config.Resolution{}, config.Resolution{}, err
+		}
+
+		minor, ok := targetFrameworkMinor(targetFramework)
+		if !ok {
+			return config.Resolution{}, config.Resolution{}, errors.New("could not find a version of dotnet-runtime to install")
+		}
+
+		runtime = config.Resolution{Name: "dotnet-runtime", Constraint: targetFramework, Source: p.relativePath(proj.path)}
+		runtime.Version, runtime.Steps, err = p.rollForwardSteps("dotnet-runtime", minor)
+		if err != nil {
+			return config.Resolution{}, config.Resolution{}, err
+		}
+	}
+
+	aspNetCore, err := p.buildpackYAMLResolution("dotnet-aspnetcore")
+	if err != nil {
+		return config.Resolution{}, config.Resolution{}, err
+	} else if aspNetCore.Version == "" {
+		aspNetCore = config.Resolution{Name: "dotnet-aspnetcore", Constraint: runtime.Version, Source: "dotnet-runtime", Version: runtime.Version, Steps: []string{"same version as dotnet-runtime"}}
+	}
+
+	return runtime, aspNetCore, nil
+}
+
+// PublishesAot reports whether a project sets PublishAot, so that dotnet
+// publish compiles it into a native executable that needs no runtime
+func (p *Project) PublishesAot(projectPath string) (bool, error) {
+	evaluation, err := p.evaluate(projectPath)
+	if err != nil {
+		return false, err
+	}
+	return strings.EqualFold(evaluation.Property("PublishAot"), "true"), nil
+}
+
+// IsNativeAot reports whether every project the app is published from uses
+// Native AOT, in which case the droplet needs neither the SDK nor a runtime
+func (p *Project) IsNativeAot() (bool, error) {
+	if published, err := p.IsPublished(); err != nil || published {
+		return false, err
+	}
+
+	processes, err := p.Processes()
+	if err != nil {
+		return false, err
+	}
+
+	var projectPaths []string
+	for _, process := range processes {
+		projectPaths = append(projectPaths, process.ProjectPath)
+	}
+
+	if len(projectPaths) == 0 {
+		mainPath, err := p.MainPath()
+		if err != nil || mainPath == "" {
+			return false, err
+		}
+		projectPaths = []string{mainPath}
+	}
+
+	for _, projectPath := range projectPaths {
+		if aot, err := p.PublishesAot(projectPath); err != nil || !aot {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// PublishTargetFramework returns the target framework to pass to dotnet
+// publish with -f for a project. It is empty unless the project is
+// multi-targeted or buildpack.yml pins a framework.
+func (p *Project) PublishTargetFramework(projectPath string) (string, error) {
+	proj, err := p.parseProjAt(projectPath)
+	if err != nil {
+		return "", err
+	}
+
+	if p.BuildpackYAML.TargetFramework == "" && len(targetFrameworks(proj)) < 2 {
+		return "", nil
+	}
+
+	return p.targetFramework(proj)
+}
+
+// targetFramework picks the framework to build for: the one pinned in
+// buildpack.yml, the only one the project targets, or the highest one in
+// TargetFrameworks whose runtime is in the manifest.
+func (p *Project) targetFramework(proj CSProj) (string, error) {
+	if chosen, found := p.chosenTargetFrameworks[proj.path]; found {
+		return chosen, nil
+	}
+
+	frameworks := targetFrameworks(proj)
+
+	if pinned := p.BuildpackYAML.TargetFramework; pinned != "" {
+		for _, fw := range frameworks {
+			if strings.EqualFold(fw, pinned) {
+				p.Log.Info("Using target framework %s from buildpack.yml", fw)
+				p.chosenTargetFrameworks[proj.path] = fw
+				return fw, nil
 			}
-		} else {
-			return errors.New("could not find a version of dotnet-runtime to install")
 		}
+		return "", fmt.Errorf("target framework %s in buildpack.yml is not one of the project's target frameworks (%s)", pinned, strings.Join(frameworks, ", "))
 	}
 
-	err = p.installer.InstallDependency(
-		libbuildpack.Dependency{Name: "dotnet-aspnetcore", Version: runtimeVersion},
-		filepath.Join(p.depDir, "dotnet-sdk"),
-	)
+	if len(frameworks) < 2 {
+		return proj.PropertyGroup.TargetFramework, nil
+	}
 
+	type candidate struct {
+		framework string
+		version   semver.Version
+	}
+	var candidates []candidate
+	for _, fw := range frameworks {
+		minor, ok := targetFrameworkMinor(fw)
+		if !ok {
+			continue
+		}
+		version, err := semver.ParseTolerant(minor)
+		if err != nil {
+			continue
+		}
+		candidates = append(candidates, candidate{framework: fw, version: version})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].version.GT(candidates[j].version) })
+
+	for _, c := range candidates {
+		minor, _ := targetFrameworkMinor(c.framework)
+		if _, err := p.rollForward("dotnet-runtime", minor); err == nil {
+			p.Log.Info("Project targets %s, using %s", strings.Join(frameworks, ", "), c.framework)
+			p.chosenTargetFrameworks[proj.path] = c.framework
+			return c.framework, nil
+		}
+	}
+
+	return "", fmt.Errorf("none of the project's target frameworks (%s) has a dotnet-runtime in the manifest", strings.Join(frameworks, ", "))
+}
+
+// TargetFrameworks returns the target frameworks a project file builds for
+func (p *Project) TargetFrameworks(projectPath string) ([]string, error) {
+	proj, err := p.parseProjAt(projectPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return targetFrameworks(proj), nil
+}
 
-	return p.installer.InstallDependency(
-		libbuildpack.Dependency{Name: "dotnet-runtime", Version: runtimeVersion},
-		filepath.Join(p.depDir, "dotnet-sdk"),
-	)
+// RuntimeLine returns the major.minor of Microsoft.NETCore.App a target
+// framework runs on. It is false for frameworks such as netstandard2.0 or
+// net48 that do not run on it.
+func RuntimeLine(targetFramework string) (string, bool) {
+	return targetFrameworkMinor(targetFramework)
+}
+
+// targetFrameworks lists the frameworks in TargetFrameworks, or the single
+// TargetFramework when the project is not multi-targeted
+func targetFrameworks(proj CSProj) []string {
+	var frameworks []string
+	for _, fw := range strings.Split(proj.Property("TargetFrameworks"), ";") {
+		if fw = strings.TrimSpace(fw); fw != "" {
+			frameworks = append(frameworks, fw)
+		}
+	}
+
+	if len(frameworks) == 0 && proj.PropertyGroup.TargetFramework != "" {
+		frameworks = append(frameworks, proj.PropertyGroup.TargetFramework)
+	}
+	return frameworks
+}
+
+// targetFrameworkMinor extracts the runtime version from 'net<x>.<y>',
+// 'net<x>.<y>-<platform>' and 'netcoreapp<x>.<y>' target framework monikers
+func targetFrameworkMinor(targetFramework string) (string, bool) {
+	matches := targetFrameworkRE.FindStringSubmatch(targetFramework)
+	if len(matches) != 2 {
+		return "", false
+	}
+	return matches[1], true
 }
 
 func (p *Project) getVersionFromAssetFile(path, library string) (string, bool, error) {
@@ -479,19 +966,30 @@ func (p *Project) installAspNetCoreDependency(version string, latestPatch bool)
 }
 
 func (p *Project) publishedStartCommand(projectPath string) (string, error) {
-	var publishedPath string
-	var runtimePath string
-
 	if published, err := p.IsPublished(); err != nil {
 		return "", err
 	} else if published {
-		publishedPath = p.buildDir
-		runtimePath = "${HOME}"
-	} else {
-		publishedPath = filepath.Join(p.depDir, "dotnet_publish")
-		runtimePath = filepath.Join("${DEPS_DIR}", p.depsIdx, "dotnet_publish")
+		publishedDir, err := p.PublishedDir()
+		if err != nil {
+			return "", err
+		}
+		relativeDir, err := filepath.Rel(p.buildDir, publishedDir)
+		if err != nil {
+			return "", err
+		}
+		return p.startCommandIn(publishedDir, filepath.Join("${HOME}", relativeDir), projectPath)
 	}
 
+	return p.startCommandIn(
+		filepath.Join(p.depDir, "dotnet_publish"),
+		filepath.Join("${DEPS_DIR}", p.depsIdx, "dotnet_publish"),
+		projectPath,
+	)
+}
+
+// startCommandIn looks for the executable or dll of an assembly in
+// publishedPath and returns the command that runs it from runtimePath
+func (p *Project) startCommandIn(publishedPath, runtimePath, projectPath string) (string, error) {
 	if exists, err := libbuildpack.FileExists(filepath.Join(publishedPath, projectPath)); err != nil {
 		return "", err
 	} else if exists {
@@ -510,10 +1008,17 @@ func (p *Project) publishedStartCommand(projectPath string) (string, error) {
 }
 
 func (p *Project) rollForward(name, version string) (string, error) {
+	rollForwardVersion, _, err := p.rollForwardSteps(name, version)
+	return rollForwardVersion, err
+}
+
+// rollForwardSteps picks the latest patch of a version in the manifest, or
+// the latest minor when there is none, and describes each match it tried
+func (p *Project) rollForwardSteps(name, version string) (string, []string, error) {
 	v := strings.Split(version, ".")
 	length := len(v)
 	if length == 0 {
-		return "", fmt.Errorf("could not find latest patch of %s: version %s not found", name, version)
+		return "", nil, fmt.Errorf("could not find latest patch of %s: version %s not found", name, version)
 	}
 
 	versions := p.manifest.AllDependencyVersions(name)
@@ -524,126 +1029,283 @@ func (p *Project) rollForward(name, version string) (string, error) {
 		v[2] = "x"
 	}
 
+	var steps []string
 	rollForwardVersion, err := FindMatchingVersionWithPreview(strings.Join(v, "."), versions)
 
 	if err == nil {
-		return rollForwardVersion, nil
+		return rollForwardVersion, append(steps, fmt.Sprintf("%s: %s", strings.Join(v, "."), rollForwardVersion)), nil
 	}
+	steps = append(steps, fmt.Sprintf("%s: no match", strings.Join(v, ".")))
 
 	v[1] = "x"
 
 	rollForwardVersion, err = FindMatchingVersionWithPreview(strings.Join(v, "."), versions)
 
 	if err != nil {
-		return "", fmt.Errorf("%s, could not a version of %s: matching %s in manifest", err.Error(), name, version)
+		return "", nil, fmt.Errorf("%s, could not a version of %s: matching %s in manifest", err.Error(), name, version)
 	}
 
-	return rollForwardVersion, nil
+	return rollForwardVersion, append(steps, fmt.Sprintf("%s: %s", strings.Join(v, "."), rollForwardVersion)), nil
 }
 
-func (p *Project) fddInstallFrameworksNETCoreApp(frameworkName, frameworkVersion string, applyPatches *bool) error {
-	runtimeVersion, err := p.FindMatchingFrameworkVersion("dotnet-runtime", frameworkVersion, applyPatches)
-	if err != nil {
-		return err
+// ResolveFrameworkVersion picks the version of dotnet-runtime,
+// dotnet-aspnetcore or another shared framework dependency for a version a
+// framework is referenced with, rolled forward to the latest patch unless
+// applyPatches is false or it is a preview. source names where the
+// reference comes from.
+func (p *Project) ResolveFrameworkVersion(name, version string, applyPatches *bool, source string) (config.Resolution, error) {
+	resolution := config.Resolution{Name: name, Constraint: version, Source: source, Version: version}
+
+	switch {
+	case strings.Contains(version, "preview"):
+		resolution.Steps = []string{"preview, used as is"}
+	case applyPatches != nil && !*applyPatches:
+		resolution.Steps = []string{"applyPatches is false, used as is"}
+	default:
+		rollForwardVersion, steps, err := p.rollForwardSteps(name, version)
+		if err != nil {
+			return config.Resolution{}, err
+		}
+		resolution.Version, resolution.Steps = rollForwardVersion, steps
 	}
+	return resolution, nil
+}
 
-	if err = p.installer.InstallDependency(
-		libbuildpack.Dependency{Name: "dotnet-runtime", Version: runtimeVersion},
-		filepath.Join(p.depDir, "dotnet-sdk"),
-	); err != nil {
-		return err
+// Resolutions lists how the versions of the dependencies the project
+// installed were picked, in the order they were installed
+func (p *Project) Resolutions() []config.Resolution {
+	return p.resolutions
+}
+
+func (p *Project) recordResolution(resolution config.Resolution) {
+	for _, existing := range p.resolutions {
+		if existing.Name == resolution.Name && existing.Version == resolution.Version {
+			return
+		}
 	}
+	p.resolutions = append(p.resolutions, resolution)
+}
 
-	aspNetCoreVersion, err := p.GetVersionFromDepsJSON("Microsoft.AspNetCore.App")
-	if _, ok := err.(*libraryMissingError); err != nil && !ok {
-		return err
-	} else if ok {
-		return nil
+// sharedFrameworkDependency looks up the manifest dependency that provides a
+// shared framework.
+func (p *Project) sharedFrameworkDependency(frameworkName string) (string, bool) {
+	frameworks := p.SharedFrameworks
+	if frameworks == nil {
+		frameworks = config.DefaultSharedFrameworks
 	}
 
-	return p.installAspNetCoreDependency(aspNetCoreVersion, false)
+	for _, fw := range frameworks {
+		if fw.Name == frameworkName {
+			return fw.Dependency, true
+		}
+	}
+	return "", false
 }
 
-func (p *Project) fddInstallFrameworksAspNetCoreApp(frameworkName, frameworkVersion string, applyPatches *bool) error {
-	aspNetCoreVersion, err := p.FindMatchingFrameworkVersionWithPreview("dotnet-aspnetcore", frameworkVersion, applyPatches)
+// fddInstallSharedFramework installs the dependency providing a shared
+// framework, followed by every framework the installed one is built on, as
+// listed in its own runtimeconfig.json.
+func (p *Project) fddInstallSharedFramework(fw Framework, applyPatches *bool, source string, installed map[string]bool) error {
+	resolution, err := p.fddFrameworkVersion(fw, applyPatches, source)
 	if err != nil {
 		return err
 	}
+	version := resolution.Version
+
+	key := resolution.Name + "@" + version
+	if installed[key] {
+		return nil
+	}
 
 	if err = p.installer.InstallDependency(
-		libbuildpack.Dependency{Name: "dotnet-aspnetcore", Version: aspNetCoreVersion},
+		libbuildpack.Dependency{Name: resolution.Name, Version: version},
 		filepath.Join(p.depDir, "dotnet-sdk"),
 	); err != nil {
 		return err
 	}
+	installed[key] = true
+	p.recordResolution(resolution)
 
-	aspNetCorePaths, err := filepath.Glob(filepath.Join(
+	frameworkConfigPaths, err := filepath.Glob(filepath.Join(
 		p.depDir,
 		"dotnet-sdk",
 		"shared",
-		frameworkName,
-		aspNetCoreVersion,
-		fmt.Sprintf("%s.runtimeconfig.json", frameworkName),
+		fw.Name,
+		version,
+		fmt.Sprintf("%s.runtimeconfig.json", fw.Name),
 	))
 	if err != nil {
 		return err
 	}
 
-	if len(aspNetCorePaths) < 1 {
+	if len(frameworkConfigPaths) < 1 {
 		return nil
 	}
 
-	aspNetCoreConfigJSON, err := parseRuntimeConfig(aspNetCorePaths[0])
+	frameworkConfigJSON, err := parseRuntimeConfig(frameworkConfigPaths[0])
 	if err != nil {
 		return err
 	}
 
-	fw := findFramework("Microsoft.NETCore.App", append(aspNetCoreConfigJSON.RuntimeOptions.Frameworks, aspNetCoreConfigJSON.RuntimeOptions.Framework))
-	if fw.Name == "" {
-		return nil
+	for _, base := range append([]Framework{frameworkConfigJSON.RuntimeOptions.Framework}, frameworkConfigJSON.RuntimeOptions.Frameworks...) {
+		if base.Name == "" || base.Name == fw.Name {
+			continue
+		}
+
+		if err := p.fddInstallSharedFramework(base, frameworkConfigJSON.RuntimeOptions.ApplyPatches, fmt.Sprintf("%s %s", fw.Name, version), installed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fddFrameworkVersion resolves the dependency and version that provide a
+// shared framework, from buildpack.yml or the version the framework is
+// referenced with in source
+func (p *Project) fddFrameworkVersion(fw Framework, applyPatches *bool, source string) (config.Resolution, error) {
+	dependency, found := p.sharedFrameworkDependency(fw.Name)
+	if !found {
+		return config.Resolution{}, fmt.Errorf("no dependency provides shared framework '%s', add it to shared_frameworks in override.yml to install it", fw.Name)
 	}
 
-	runtimeVersion, err := p.FindMatchingFrameworkVersion(
-		"dotnet-runtime",
-		fw.Version,
-		aspNetCoreConfigJSON.RuntimeOptions.ApplyPatches,
-	)
+	if resolution, err := p.buildpackYAMLResolution(dependency); err != nil || resolution.Version != "" {
+		return resolution, err
+	}
+
+	return p.ResolveFrameworkVersion(dependency, fw.Version, applyPatches, source)
+}
+
+// FDDFrameworkVersions resolves the shared frameworks that the runtimeconfig.json
+// of a framework-dependent app references. Frameworks those are built on
+// are only known once they are installed, except for ASP.NET Core, which is
+// built on the runtime of the same version.
+func (p *Project) FDDFrameworkVersions() ([]config.Resolution, error) {
+	path, err := p.RuntimeConfigPath()
 	if err != nil {
+		return nil, err
+	}
+
+	runtimeConfig, err := parseRuntimeConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	applyPatches := runtimeConfig.RuntimeOptions.ApplyPatches
+	frameworks := append([]Framework{runtimeConfig.RuntimeOptions.Framework}, runtimeConfig.RuntimeOptions.Frameworks...)
+
+	var resolutions []config.Resolution
+	resolved := map[string]bool{}
+	for _, fw := range frameworks {
+		if fw.Name == "" {
+			continue
+		}
+
+		resolution, err := p.fddFrameworkVersion(fw, applyPatches, filepath.Base(path))
+		if err != nil {
+			return nil, err
+		}
+		resolutions = append(resolutions, resolution)
+		resolved[fw.Name] = true
+	}
+
+	for _, fw := range frameworks {
+		if fw.Name != "Microsoft.AspNetCore.App" || resolved["Microsoft.NETCore.App"] {
+			continue
+		}
+
+		resolution, err := p.fddFrameworkVersion(Framework{Name: "Microsoft.NETCore.App", Version: fw.Version}, applyPatches, fmt.Sprintf("%s %s", fw.Name, fw.Version))
+		if err != nil {
+			return nil, err
+		}
+		resolutions = append(resolutions, resolution)
+	}
+	return resolutions, nil
+}
+
+// fddInstallLegacyAspNetCore installs ASP.NET Core for apps that reference it
+// as a package in their deps.json rather than as a shared framework.
+func (p *Project) fddInstallLegacyAspNetCore() error {
+	aspNetCoreVersion, err := p.GetVersionFromDepsJSON("Microsoft.AspNetCore.App")
+	if _, ok := err.(*libraryMissingError); err != nil && !ok {
 		return err
+	} else if ok {
+		return nil
 	}
 
-	return p.installer.InstallDependency(
-		libbuildpack.Dependency{Name: "dotnet-runtime", Version: runtimeVersion},
-		filepath.Join(p.depDir, "dotnet-sdk"),
-	)
+	return p.installAspNetCoreDependency(aspNetCoreVersion, false)
+}
+
+// buildpackYAMLResolution resolves the version of dotnet-runtime or
+// dotnet-aspnetcore requested in buildpack.yml against the manifest. The
+// version is empty when buildpack.yml does not pin the dependency.
+func (p *Project) buildpackYAMLResolution(name string) (config.Resolution, error) {
+	var constraint string
+	switch name {
+	case "dotnet-runtime":
+		constraint = p.BuildpackYAML.Runtime
+	case "dotnet-aspnetcore":
+		constraint = p.BuildpackYAML.AspNetCore
+	}
+
+	if constraint == "" {
+		return config.Resolution{Name: name}, nil
+	}
+
+	version, err := FindMatchingVersionWithPreview(constraint, p.manifest.AllDependencyVersions(name))
+	if err != nil {
+		return config.Resolution{}, fmt.Errorf("%s %s in buildpack.yml is not available: %v", name, constraint, err)
+	}
+	return config.Resolution{Name: name, Constraint: constraint, Source: "buildpack.yml", Steps: []string{fmt.Sprintf("%s: %s", constraint, version)}, Version: version}, nil
 }
 
+// parseProj evaluates the main project file together with the
+// Directory.Build.props and Directory.Build.targets files around it
 func (p *Project) parseProj() (CSProj, error) {
 	mainPath, err := p.MainPath()
 	if err != nil {
 		return CSProj{}, err
 	}
 
-	if _, err = os.Stat(mainPath); os.IsNotExist(err) {
+	return p.parseProjAt(mainPath)
+}
+
+func (p *Project) parseProjAt(projectPath string) (CSProj, error) {
+	if _, err := os.Stat(projectPath); os.IsNotExist(err) {
 		return CSProj{}, nil
 	}
-	projFile, err := os.Open(mainPath)
-	if err != nil {
-		return CSProj{}, err
-	}
-	defer projFile.Close()
-	projBytes, err := io.ReadAll(projFile)
+
+	evaluation, err := p.evaluate(projectPath)
 	if err != nil {
 		return CSProj{}, err
 	}
-	obj := CSProj{}
 
-	if err := xml.Unmarshal(projBytes, &obj); err != nil {
-		return CSProj{}, err
+	obj := CSProj{path: projectPath, properties: evaluation.properties}
+	obj.PropertyGroup.TargetFramework = evaluation.Property("TargetFramework")
+	obj.PropertyGroup.RuntimeFrameworkVersion = evaluation.Property("RuntimeFrameworkVersion")
+	obj.PropertyGroup.AssemblyName = evaluation.Property("AssemblyName")
+
+	obj.ItemGroups = make([]ItemGroup, 1)
+	for _, reference := range evaluation.packageReferences {
+		obj.ItemGroups[0].PackageReferences = append(obj.ItemGroups[0].PackageReferences, PackageReference{
+			Include: reference.Include,
+			Version: reference.Version,
+		})
 	}
 	return obj, nil
 }
 
+// evaluate evaluates a project file with the configuration it is published
+// with
+func (p *Project) evaluate(projectPath string) (*msbuildEvaluator, error) {
+	evaluation, err := evaluateProject(p.buildDir, projectPath, map[string]string{
+		"Configuration": p.BuildpackYAML.PublishConfiguration(),
+	}, p.Environ)
+	if err != nil {
+		return nil, werrors.Wrapf(err, "unable to evaluate %s", filepath.Base(projectPath))
+	}
+	return evaluation, nil
+}
+
 func sanitizeJsonConfig(runtimeConfigPath string) ([]byte, error) {
 	input, err := os.Open(runtimeConfigPath)
 	if err != nil {
@@ -675,15 +1337,6 @@ func parseRuntimeConfig(runtimeConfigPath string) (ConfigJSON, error) {
 	return obj, nil
 }
 
-func findFramework(name string, frameworks []Framework) Framework {
-	for _, fw := range frameworks {
-		if fw.Name == name {
-			return fw
-		}
-	}
-	return Framework{}
-}
-
 type libraryMissingError struct {
 	s string
 }
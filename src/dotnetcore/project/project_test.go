@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/config"
 	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/project"
 	"github.com/cloudfoundry/libbuildpack"
 	"github.com/cloudfoundry/libbuildpack/ansicleaner"
@@ -92,6 +93,15 @@ var _ = Describe("Project", func() {
 		subject = project.New(buildDir, filepath.Join(depsDir, depsIdx), depsIdx, mockManifest, mockInstaller, logger)
 	})
 
+	// writeBuildpackYAML writes buildpack.yml and hands it to the project
+	// parsed, the way the supply and finalize CLIs do
+	writeBuildpackYAML := func(content string) {
+		Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte(content), 0644)).To(Succeed())
+		buildpackYAML, err := config.LoadBuildpackYAML(buildDir)
+		Expect(err).NotTo(HaveOccurred())
+		subject.BuildpackYAML = buildpackYAML
+	}
+
 	AfterEach(func() {
 		err = os.RemoveAll(buildDir)
 		Expect(err).To(BeNil())
@@ -181,6 +191,31 @@ var _ = Describe("Project", func() {
 				})
 			})
 
+			Context("The project is published with Native AOT", func() {
+				BeforeEach(func() {
+					Expect(os.WriteFile(filepath.Join(buildDir, "fred.csproj"), []byte("<Project><PropertyGroup><PublishAot>true</PublishAot></PropertyGroup></Project>"), 0644)).To(Succeed())
+					Expect(os.MkdirAll(filepath.Join(depsDir, depsIdx, "dotnet_publish"), 0755)).To(Succeed())
+					Expect(os.WriteFile(filepath.Join(depsDir, depsIdx, "dotnet_publish", "fred"), []byte(""), 0644)).To(Succeed())
+					Expect(os.WriteFile(filepath.Join(depsDir, depsIdx, "dotnet_publish", "fred.dbg"), []byte(""), 0644)).To(Succeed())
+				})
+
+				It("is detected from the project properties", func() {
+					aot, err := subject.IsNativeAot()
+					Expect(err).To(BeNil())
+					Expect(aot).To(BeTrue())
+				})
+
+				It("returns the native executable", func() {
+					startCmd, err := subject.StartCommand()
+					Expect(err).To(BeNil())
+					Expect(startCmd).To(Equal(filepath.Join("${DEPS_DIR}", depsIdx, "dotnet_publish", "fred")))
+
+					info, err := os.Stat(filepath.Join(depsDir, depsIdx, "dotnet_publish", "fred"))
+					Expect(err).To(BeNil())
+					Expect(info.Mode().Perm()).To(Equal(os.FileMode(0755)))
+				})
+			})
+
 			Context("The csproj file has an AssemblyName tag", func() {
 				BeforeEach(func() {
 					Expect(os.MkdirAll(filepath.Join(buildDir, "subdir"), 0755)).To(Succeed())
@@ -201,6 +236,35 @@ var _ = Describe("Project", func() {
 					Expect(startCmd).To(Equal(filepath.Join("${DEPS_DIR}", depsIdx, "dotnet_publish", "f.red")))
 				})
 			})
+
+			Context("The AssemblyName is built from properties in Directory.Build.props and a second PropertyGroup", func() {
+				BeforeEach(func() {
+					Expect(os.MkdirAll(filepath.Join(buildDir, "subdir"), 0755)).To(Succeed())
+					Expect(os.WriteFile(filepath.Join(buildDir, "Directory.Build.props"), []byte(`
+<Project>
+	<PropertyGroup>
+		<Company>Contoso</Company>
+	</PropertyGroup>
+</Project>`), 0644)).To(Succeed())
+					Expect(os.WriteFile(filepath.Join(buildDir, "subdir", "fred.csproj"), []byte(`
+<Project Sdk="Microsoft.NET.Sdk.Web">
+	<PropertyGroup>
+		<TargetFramework>net8.0</TargetFramework>
+	</PropertyGroup>
+	<PropertyGroup>
+		<AssemblyName>$(Company).$(MSBuildProjectName)</AssemblyName>
+	</PropertyGroup>
+</Project>`), 0644)).To(Succeed())
+					Expect(os.MkdirAll(filepath.Join(depsDir, depsIdx, "dotnet_publish"), 0755)).To(Succeed())
+					Expect(os.WriteFile(filepath.Join(depsDir, depsIdx, "dotnet_publish", "Contoso.fred"), []byte(""), 0755)).To(Succeed())
+				})
+
+				It("returns a start command with the evaluated AssemblyName", func() {
+					startCmd, err := subject.StartCommand()
+					Expect(err).To(BeNil())
+					Expect(startCmd).To(Equal(filepath.Join("${DEPS_DIR}", depsIdx, "dotnet_publish", "Contoso.fred")))
+				})
+			})
 		})
 
 		Context("mainPath could not be determined", func() {
@@ -362,6 +426,274 @@ var _ = Describe("Project", func() {
 		})
 	})
 
+	Describe("Detect", func() {
+		writeFile := func(path, content string, perm os.FileMode) {
+			Expect(os.MkdirAll(filepath.Dir(filepath.Join(buildDir, path)), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, path), []byte(content), perm)).To(Succeed())
+		}
+
+		Context("the app is published into a subdirectory", func() {
+			BeforeEach(func() {
+				writeFile("publish/fred.runtimeconfig.json", "{}", 0644)
+			})
+
+			It("detects the published app", func() {
+				detection, err := subject.Detect()
+				Expect(err).To(BeNil())
+				Expect(detection.Detected).To(BeTrue())
+				Expect(detection.Reasons).To(ContainElement("found published app publish/fred.runtimeconfig.json"))
+			})
+
+			It("does not detect when several subdirectories hold a published app", func() {
+				writeFile("tools/tool.runtimeconfig.json", "{}", 0644)
+
+				detection, err := subject.Detect()
+				Expect(err).To(BeNil())
+				Expect(detection.Detected).To(BeFalse())
+				Expect(detection.Reasons).To(ContainElement("ignored the published app: found published apps in publish, tools, set published-dir in buildpack.yml to pick one"))
+			})
+		})
+
+		Context("the app is a single-file bundle", func() {
+			BeforeEach(func() {
+				writeFile("fred", "\x7fELF"+strings.Repeat("\x00", 1<<16-10)+"\x8b\x12\x02\xb9\x6a\x61\x20\x38\x72\x7b\x93\x02\x14\xd7\xa0\x32\x13\xf5\xb9\xe6\xef\xae\x33\x18\xee\x3b\x2d\xce\x24\xb3\x6a\xae", 0755)
+				writeFile("other", "\x7fELF", 0755)
+			})
+
+			It("does not detect it, since staging needs the runtimeconfig.json", func() {
+				detection, err := subject.Detect()
+				Expect(err).To(BeNil())
+				Expect(detection.Detected).To(BeFalse())
+				Expect(detection.Reasons).To(ContainElement("ignored single-file bundle fred, staging needs the publish output with its *.runtimeconfig.json"))
+			})
+		})
+
+		Context("the app has a project", func() {
+			BeforeEach(func() {
+				writeFile("src/Web/Web.csproj", `<Project Sdk="Microsoft.NET.Sdk.Web"></Project>`, 0644)
+			})
+
+			It("detects the project", func() {
+				detection, err := subject.Detect()
+				Expect(err).To(BeNil())
+				Expect(detection.Detected).To(BeTrue())
+				Expect(detection.Reasons).To(Equal([]string{"found project src/Web/Web.csproj"}))
+			})
+		})
+
+		Context("a project cannot be evaluated", func() {
+			BeforeEach(func() {
+				writeFile("src/Web/Web.csproj", `<Project Sdk="Microsoft.NET.Sdk.Web">`, 0644)
+			})
+
+			It("detects it and says why it could not evaluate it", func() {
+				detection, err := subject.Detect()
+				Expect(err).To(BeNil())
+				Expect(detection.Detected).To(BeTrue())
+				Expect(detection.Reasons).To(HaveLen(1))
+				Expect(detection.Reasons[0]).To(HavePrefix("found project src/Web/Web.csproj, but could not evaluate it: "))
+			})
+		})
+
+		Context("the only projects are test projects or dependencies", func() {
+			BeforeEach(func() {
+				writeFile("test/Web.Tests/Web.Tests.csproj", `<Project Sdk="Microsoft.NET.Sdk"><PropertyGroup><IsTestProject>true</IsTestProject></PropertyGroup></Project>`, 0644)
+				writeFile("node_modules/edge-js/lib/bootstrap/Edge.csproj", `<Project Sdk="Microsoft.NET.Sdk"></Project>`, 0644)
+				writeFile("bin/Release/fred.runtimeconfig.json", "{}", 0644)
+			})
+
+			It("does not detect and explains why", func() {
+				detection, err := subject.Detect()
+				Expect(err).To(BeNil())
+				Expect(detection.Detected).To(BeFalse())
+				Expect(detection.Reasons).To(Equal([]string{
+					"ignored test/Web.Tests/Web.Tests.csproj, it is a test project",
+					"found no *.runtimeconfig.json at the root, in published-dir or in a subdirectory, and no project file outside of node_modules, bin and obj",
+				}))
+			})
+		})
+
+		Context("a test project sets IsTestProject in Directory.Build.props", func() {
+			BeforeEach(func() {
+				writeFile("test/Directory.Build.props", `<Project><PropertyGroup><IsTestProject>true</IsTestProject></PropertyGroup></Project>`, 0644)
+				writeFile("test/Web.Tests/Web.Tests.csproj", `<Project Sdk="Microsoft.NET.Sdk"></Project>`, 0644)
+			})
+
+			It("does not detect it", func() {
+				detection, err := subject.Detect()
+				Expect(err).To(BeNil())
+				Expect(detection.Detected).To(BeFalse())
+				Expect(detection.Reasons).To(ContainElement("ignored test/Web.Tests/Web.Tests.csproj, it is a test project"))
+			})
+		})
+
+		Context("the root holds several published apps without an entry assembly", func() {
+			BeforeEach(func() {
+				writeFile("fred.runtimeconfig.json", "{}", 0644)
+				writeFile("tool.runtimeconfig.json", "{}", 0644)
+			})
+
+			It("does not detect and says why", func() {
+				detection, err := subject.Detect()
+				Expect(err).To(BeNil())
+				Expect(detection.Detected).To(BeFalse())
+				Expect(detection.Reasons).To(ContainElement("ignored the published app: multiple *.runtimeconfig.json files present, could not tell which of fred.runtimeconfig.json, tool.runtimeconfig.json belongs to the entry assembly"))
+			})
+		})
+
+		Context("the app has a solution", func() {
+			BeforeEach(func() {
+				writeFile("tools/Tool/Tool.csproj", `<Project Sdk="Microsoft.NET.Sdk"></Project>`, 0644)
+			})
+
+			It("only evaluates the projects the solution lists", func() {
+				writeFile("src/Web/Web.csproj", `<Project Sdk="Microsoft.NET.Sdk.Web"></Project>`, 0644)
+				writeFile("App.slnx", `<Solution><Project Path="src/Web/Web.csproj" /></Solution>`, 0644)
+
+				detection, err := subject.Detect()
+				Expect(err).To(BeNil())
+				Expect(detection.Detected).To(BeTrue())
+				Expect(detection.Reasons).To(Equal([]string{"found project src/Web/Web.csproj"}))
+			})
+
+			It("detects a solution none of whose projects exist, so that staging explains it", func() {
+				writeFile("App.slnx", `<Solution><Project Path="src/Web/Web.csproj" /></Solution>`, 0644)
+
+				detection, err := subject.Detect()
+				Expect(err).To(BeNil())
+				Expect(detection.Detected).To(BeTrue())
+				Expect(detection.Reasons).To(Equal([]string{"found solution App.slnx, but none of the projects it lists exist in the app"}))
+			})
+		})
+
+		Context("the app only has a global.json", func() {
+			BeforeEach(func() {
+				writeFile("global.json", `{"sdk": {"version": "8.0.100"}}`, 0644)
+			})
+
+			It("detects it, so that staging explains what is missing", func() {
+				detection, err := subject.Detect()
+				Expect(err).To(BeNil())
+				Expect(detection.Detected).To(BeTrue())
+				Expect(detection.Reasons).To(Equal([]string{"found global.json, but no project or published app; staging needs one of them"}))
+			})
+		})
+	})
+
+	Describe("every layout Detect accepts", func() {
+		layouts := map[string]map[string]string{
+			"published at the root": {
+				"fred.runtimeconfig.json": "{}",
+				"fred.dll":                "",
+			},
+			"published into a subdirectory": {
+				"publish/fred.runtimeconfig.json": "{}",
+				"publish/fred.dll":                "",
+			},
+			"published into published-dir": {
+				"buildpack.yml":                   "dotnet-core:\n  published-dir: out/app\n",
+				"out/app/fred.runtimeconfig.json": "{}",
+				"out/app/fred.dll":                "",
+			},
+			"a project": {
+				"src/Web/Web.csproj": `<Project Sdk="Microsoft.NET.Sdk.Web"></Project>`,
+			},
+		}
+
+		for name, files := range layouts {
+			name, files := name, files
+			It("is staged as "+name, func() {
+				for path, content := range files {
+					if path == "buildpack.yml" {
+						writeBuildpackYAML(content)
+						continue
+					}
+					Expect(os.MkdirAll(filepath.Dir(filepath.Join(buildDir, path)), 0755)).To(Succeed())
+					Expect(os.WriteFile(filepath.Join(buildDir, path), []byte(content), 0644)).To(Succeed())
+				}
+
+				detection, err := subject.Detect()
+				Expect(err).To(BeNil())
+				Expect(detection.Detected).To(BeTrue())
+
+				published, err := subject.IsPublished()
+				Expect(err).To(BeNil())
+				if published {
+					startCmd, err := subject.StartCommand()
+					Expect(err).To(BeNil())
+					Expect(startCmd).To(HaveSuffix("fred.dll"))
+				} else {
+					mainPath, err := subject.MainPath()
+					Expect(err).To(BeNil())
+					Expect(mainPath).To(Equal(filepath.Join(buildDir, "src", "Web", "Web.csproj")))
+				}
+			})
+		}
+	})
+
+	Describe("RuntimeConfigPath with several published assemblies", func() {
+		writeFile := func(name, content string, perm os.FileMode) {
+			Expect(os.MkdirAll(filepath.Dir(filepath.Join(buildDir, name)), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, name), []byte(content), perm)).To(Succeed())
+		}
+
+		BeforeEach(func() {
+			writeFile("fred.runtimeconfig.json", `{"runtimeOptions": {"framework": {"name": "Microsoft.NETCore.App", "version": "8.0.0"}}}`, 0644)
+			writeFile("fred.dll", "", 0644)
+			writeFile("tool.runtimeconfig.json", "{}", 0644)
+			writeFile("tool.dll", "", 0644)
+		})
+
+		It("picks the one with an apphost", func() {
+			writeFile("fred", "", 0755)
+			writeFile("tool.deps.json", "{}", 0644)
+
+			path, err := subject.RuntimeConfigPath()
+			Expect(err).To(BeNil())
+			Expect(path).To(Equal(filepath.Join(buildDir, "fred.runtimeconfig.json")))
+
+			startCmd, err := subject.StartCommand()
+			Expect(err).To(BeNil())
+			Expect(startCmd).To(Equal(filepath.Join("${HOME}", "fred")))
+			Expect(subject.IsFDD()).To(BeTrue())
+		})
+
+		It("picks the one with a deps.json when none has an apphost", func() {
+			writeFile("fred.deps.json", "{}", 0644)
+
+			startCmd, err := subject.StartCommand()
+			Expect(err).To(BeNil())
+			Expect(startCmd).To(Equal(filepath.Join("${HOME}", "fred.dll")))
+		})
+
+		It("fails when it cannot tell them apart", func() {
+			_, err := subject.RuntimeConfigPath()
+			Expect(err).To(MatchError("multiple *.runtimeconfig.json files present, could not tell which of fred.runtimeconfig.json, tool.runtimeconfig.json belongs to the entry assembly"))
+		})
+	})
+
+	Describe("RuntimeConfigPath with published-dir in buildpack.yml", func() {
+		BeforeEach(func() {
+			writeBuildpackYAML("dotnet-core:\n  published-dir: publish\n")
+			Expect(os.MkdirAll(filepath.Join(buildDir, "publish"), 0755)).To(Succeed())
+		})
+
+		It("looks for the published app in that directory", func() {
+			Expect(os.WriteFile(filepath.Join(buildDir, "publish", "fred.runtimeconfig.json"), []byte(`{"runtimeOptions": {"framework": {"name": "Microsoft.NETCore.App", "version": "8.0.0"}}}`), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "publish", "fred"), []byte(""), 0755)).To(Succeed())
+
+			startCmd, err := subject.StartCommand()
+			Expect(err).To(BeNil())
+			Expect(startCmd).To(Equal(filepath.Join("${HOME}", "publish", "fred")))
+			Expect(subject.IsFDD()).To(BeTrue())
+		})
+
+		It("fails when the directory holds no published app", func() {
+			_, err := subject.IsPublished()
+			Expect(err).To(MatchError("published-dir publish in buildpack.yml holds no *.runtimeconfig.json"))
+		})
+	})
+
 	Describe("IsFDD", func() {
 		BeforeEach(func() {
 			for _, name := range []string{
@@ -524,6 +856,30 @@ var _ = Describe("Project", func() {
 				})
 			})
 
+			Context("buildpack.yml names the project", func() {
+				BeforeEach(func() {
+					Expect(os.WriteFile(filepath.Join(buildDir, ".deployment"), []byte("[config]\nproject = ./a/b/first.vbproj"), 0644)).To(Succeed())
+					writeBuildpackYAML("dotnet-core:\n  project: b/c/first.fsproj")
+				})
+
+				It("returns the path from buildpack.yml", func() {
+					path, err := subject.MainPath()
+					Expect(err).To(BeNil())
+					Expect(path).To(Equal(filepath.Join(buildDir, "b", "c", "first.fsproj")))
+				})
+			})
+
+			Context("buildpack.yml names a project that does not exist", func() {
+				BeforeEach(func() {
+					writeBuildpackYAML("dotnet-core:\n  project: missing/missing.csproj")
+				})
+
+				It("returns an error", func() {
+					_, err := subject.MainPath()
+					Expect(err).To(MatchError("project missing/missing.csproj specified in buildpack.yml does not exist"))
+				})
+			})
+
 			Context("There is NOT a .deployment file present", func() {
 
 				It("Returns an error", func() {
@@ -531,6 +887,306 @@ var _ = Describe("Project", func() {
 					Expect(err).ToNot(BeNil())
 				})
 			})
+
+			Context("a .sln file lists exactly one of the projects", func() {
+				BeforeEach(func() {
+					Expect(os.WriteFile(filepath.Join(buildDir, "app.sln"), []byte(`
+Microsoft Visual Studio Solution File, Format Version 12.00
+Project("{2150E333-8FDC-42A3-9474-1A3956D46DE8}") = "src", "src", "{11111111-1111-1111-1111-111111111111}"
+EndProject
+Project("{FAE04EC0-301F-11D3-BF4B-00C04F79EFBC}") = "second", "dir\second.csproj", "{22222222-2222-2222-2222-222222222222}"
+EndProject
+`), 0644)).To(Succeed())
+				})
+
+				It("returns the project from the solution", func() {
+					path, err := subject.MainPath()
+					Expect(err).To(BeNil())
+					Expect(path).To(Equal(filepath.Join(buildDir, "dir", "second.csproj")))
+				})
+			})
+
+			Context("a .slnx file lists several projects", func() {
+				BeforeEach(func() {
+					Expect(os.WriteFile(filepath.Join(buildDir, "app.slnx"), []byte(`
+<Solution>
+  <Folder Name="/src/">
+    <Project Path="a/b/first.vbproj" />
+    <Project Path="b/c/first.fsproj" />
+  </Folder>
+  <Project Path="missing/missing.csproj" />
+</Solution>
+`), 0644)).To(Succeed())
+				})
+
+				It("only considers the projects in the solution", func() {
+					_, err := subject.MainPath()
+					Expect(err).To(MatchError("could not determine the startup project, candidates are: a/b/first.vbproj (could not be evaluated), b/c/first.fsproj (could not be evaluated); set project in buildpack.yml or add a .deployment file"))
+				})
+
+				Context("buildpack.yml names the startup project", func() {
+					BeforeEach(func() {
+						writeBuildpackYAML("dotnet-core:\n  project: first")
+					})
+
+					It("returns the first solution project with that name", func() {
+						path, err := subject.MainPath()
+						Expect(err).To(BeNil())
+						Expect(path).To(Equal(filepath.Join(buildDir, "a", "b", "first.vbproj")))
+					})
+				})
+
+				Context("buildpack.yml names a project that is not in the solution", func() {
+					BeforeEach(func() {
+						writeBuildpackYAML("dotnet-core:\n  project: second")
+					})
+
+					It("returns an error listing the solution's projects", func() {
+						_, err := subject.MainPath()
+						Expect(err).To(MatchError("project second specified in buildpack.yml is not in the solution, it contains: a/b/first.vbproj, b/c/first.fsproj"))
+					})
+				})
+			})
+		})
+	})
+
+	Describe("MainPath with a solution outside of the app", func() {
+		var outsideDir string
+
+		BeforeEach(func() {
+			var err error
+			outsideDir, err = os.MkdirTemp("", "dotnet-core-buildpack.outside.")
+			Expect(err).To(BeNil())
+			Expect(os.WriteFile(filepath.Join(outsideDir, "Shared.csproj"), []byte("<Project />"), 0644)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(buildDir, "src", "Web"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "src", "Web", "Web.csproj"), []byte(`<Project Sdk="Microsoft.NET.Sdk.Web"><PropertyGroup><OutputType>Exe</OutputType></PropertyGroup></Project>`), 0644)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(outsideDir)).To(Succeed())
+		})
+
+		writeSolution := func(paths ...string) {
+			content := "<Solution>\n"
+			for _, path := range paths {
+				content += `  <Project Path="` + path + `" />` + "\n"
+			}
+			Expect(os.WriteFile(filepath.Join(buildDir, "app.slnx"), []byte(content+"</Solution>\n"), 0644)).To(Succeed())
+		}
+
+		It("ignores the projects the solution lists outside of the app", func() {
+			rel, err := filepath.Rel(buildDir, filepath.Join(outsideDir, "Shared.csproj"))
+			Expect(err).To(BeNil())
+			writeSolution(rel, "src/Web/Web.csproj")
+
+			path, err := subject.MainPath()
+			Expect(err).To(BeNil())
+			Expect(path).To(Equal(filepath.Join(buildDir, "src", "Web", "Web.csproj")))
+			Expect(buffer.String()).To(ContainSubstring("Ignoring project " + filepath.Join(outsideDir, "Shared.csproj") + " listed in app.slnx, it is outside of the app"))
+		})
+
+		It("fails when none of the projects the solution lists are in the app", func() {
+			writeSolution("missing/Missing.csproj")
+
+			_, err := subject.MainPath()
+			Expect(err).To(MatchError("none of the projects listed in app.slnx exist in the app; fix the solution or set project in buildpack.yml"))
+		})
+	})
+
+	Describe("Processes", func() {
+		BeforeEach(func() {
+			for _, name := range []string{"src/Api/Api.csproj", "src/Worker/Worker.csproj"} {
+				Expect(os.MkdirAll(filepath.Dir(filepath.Join(buildDir, name)), 0755)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(buildDir, name), []byte("<Project />"), 0644)).To(Succeed())
+			}
+		})
+
+		Context("no processes are declared", func() {
+			It("returns none", func() {
+				processes, err := subject.Processes()
+				Expect(err).To(BeNil())
+				Expect(processes).To(BeEmpty())
+			})
+		})
+
+		Context(".deployment declares processes", func() {
+			BeforeEach(func() {
+				Expect(os.WriteFile(filepath.Join(buildDir, ".deployment"), []byte("[processes]\nworker = ./src/Worker/Worker.csproj\nweb = ./src/Api/Api.csproj\n"), 0644)).To(Succeed())
+			})
+
+			It("returns them sorted by name", func() {
+				processes, err := subject.Processes()
+				Expect(err).To(BeNil())
+				Expect(processes).To(Equal([]project.Process{
+					{Name: "web", ProjectPath: filepath.Join(buildDir, "src", "Api", "Api.csproj")},
+					{Name: "worker", ProjectPath: filepath.Join(buildDir, "src", "Worker", "Worker.csproj")},
+				}))
+			})
+
+			It("uses the web process as the main project", func() {
+				path, err := subject.MainPath()
+				Expect(err).To(BeNil())
+				Expect(path).To(Equal(filepath.Join(buildDir, "src", "Api", "Api.csproj")))
+			})
+		})
+
+		Context("buildpack.yml declares a process with a missing project", func() {
+			BeforeEach(func() {
+				writeBuildpackYAML("dotnet-core:\n  processes:\n    web:\n      project: src/Missing/Missing.csproj\n")
+			})
+
+			It("returns an error", func() {
+				_, err := subject.Processes()
+				Expect(err).To(MatchError("process web: project src/Missing/Missing.csproj specified in buildpack.yml does not exist"))
+			})
+		})
+
+		Context(".deployment declares a process outside of the app", func() {
+			var outsideDir string
+
+			BeforeEach(func() {
+				var err error
+				outsideDir, err = os.MkdirTemp("", "dotnet-core-buildpack.outside.")
+				Expect(err).To(BeNil())
+				Expect(os.WriteFile(filepath.Join(outsideDir, "Other.csproj"), []byte("<Project />"), 0644)).To(Succeed())
+			})
+
+			AfterEach(func() {
+				Expect(os.RemoveAll(outsideDir)).To(Succeed())
+			})
+
+			It("rejects a path leaving the app, naming the process", func() {
+				rel, err := filepath.Rel(buildDir, filepath.Join(outsideDir, "Other.csproj"))
+				Expect(err).To(BeNil())
+				Expect(os.WriteFile(filepath.Join(buildDir, ".deployment"), []byte("[processes]\nweb = ./src/Api/Api.csproj\nworker = "+rel+"\n"), 0644)).To(Succeed())
+
+				_, err = subject.Processes()
+				Expect(err).To(MatchError(fmt.Sprintf("process worker: project %s specified in .deployment is outside of the app", rel)))
+			})
+
+			It("rejects a symlink leaving the app", func() {
+				Expect(os.Symlink(outsideDir, filepath.Join(buildDir, "linked"))).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(buildDir, ".deployment"), []byte("[processes]\nweb = ./src/Api/Api.csproj\nworker = linked/Other.csproj\n"), 0644)).To(Succeed())
+
+				_, err := subject.Processes()
+				Expect(err).To(MatchError("process worker: project linked/Other.csproj specified in .deployment is outside of the app"))
+			})
+		})
+
+		Context(".deployment declares a process whose name is not a valid process type", func() {
+			BeforeEach(func() {
+				Expect(os.MkdirAll(filepath.Join(buildDir, "src", "Api"), 0755)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(buildDir, "src", "Api", "Api.csproj"), []byte("<Project />"), 0644)).To(Succeed())
+			})
+
+			for _, name := range []string{"../web", "a/b", "web.api"} {
+				name := name
+				It("rejects "+name, func() {
+					Expect(os.WriteFile(filepath.Join(buildDir, ".deployment"), []byte("[processes]\n"+name+" = ./src/Api/Api.csproj\n"), 0644)).To(Succeed())
+
+					_, err := subject.Processes()
+					Expect(err).To(MatchError(fmt.Sprintf("process %q in .deployment may only contain letters, digits, '-' and '_'", name)))
+				})
+			}
+		})
+
+		Context("a Procfile and buildpack.yml declare commands", func() {
+			BeforeEach(func() {
+				Expect(os.WriteFile(filepath.Join(buildDir, "Procfile"), []byte("# processes\nweb: cd {publish_dir} && exec {entry_assembly} --urls http://0.0.0.0:$PORT\n\nworker:   ./Worker --queue jobs\n"), 0644)).To(Succeed())
+				writeBuildpackYAML("dotnet-core:\n  processes:\n    migrate:\n      command: dotnet {publish_dir}/Api.dll --migrate\n    api:\n      project: src/Api/Api.csproj\n      command: \"{entry_assembly} --api\"\n")
+			})
+
+			It("returns the commands of processes without a project", func() {
+				commands, err := subject.ProcessCommands()
+				Expect(err).To(BeNil())
+				Expect(commands).To(Equal(map[string]string{
+					"web":     "cd {publish_dir} && exec {entry_assembly} --urls http://0.0.0.0:$PORT",
+					"worker":  "./Worker --queue jobs",
+					"migrate": "dotnet {publish_dir}/Api.dll --migrate",
+				}))
+			})
+
+			It("keeps the command of processes with a project on the process", func() {
+				processes, err := subject.Processes()
+				Expect(err).To(BeNil())
+				Expect(processes).To(Equal([]project.Process{
+					{Name: "api", ProjectPath: filepath.Join(buildDir, "src", "Api", "Api.csproj"), Command: "{entry_assembly} --api"},
+				}))
+			})
+
+			It("fails when both declare the same process type", func() {
+				Expect(os.WriteFile(filepath.Join(buildDir, "Procfile"), []byte("migrate: ./Api --migrate\n"), 0644)).To(Succeed())
+
+				_, err := subject.ProcessCommands()
+				Expect(err).To(MatchError("process migrate is declared in both Procfile and buildpack.yml"))
+			})
+		})
+
+		Context("the Procfile is malformed", func() {
+			BeforeEach(func() {
+				Expect(os.WriteFile(filepath.Join(buildDir, "Procfile"), []byte("web: ./Api\nworker\n"), 0644)).To(Succeed())
+			})
+
+			It("names the line", func() {
+				_, err := subject.Procfile()
+				Expect(err).To(MatchError("invalid Procfile: line 2: expected <process type>: <command>"))
+			})
+		})
+	})
+
+	Describe("MainPath with several projects and no startup project configured", func() {
+		writeProject := func(path, content string) {
+			Expect(os.MkdirAll(filepath.Dir(filepath.Join(buildDir, path)), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, path), []byte(content), 0644)).To(Succeed())
+		}
+
+		BeforeEach(func() {
+			writeProject("src/Lib/Lib.csproj", `<Project Sdk="Microsoft.NET.Sdk"><PropertyGroup><OutputType>Library</OutputType></PropertyGroup></Project>`)
+			writeProject("test/Web.Tests/Web.Tests.csproj", `<Project Sdk="Microsoft.NET.Sdk.Web"><ItemGroup><PackageReference Include="Microsoft.NET.Test.Sdk" Version="17.0.0" /></ItemGroup></Project>`)
+			writeProject("test/Integration/Integration.csproj", `<Project Sdk="Microsoft.NET.Sdk"><PropertyGroup><OutputType>Exe</OutputType><IsTestProject>true</IsTestProject></PropertyGroup></Project>`)
+			writeProject("tools/Migrator/Migrator.csproj", `<Project Sdk="Microsoft.NET.Sdk"><PropertyGroup><OutputType>Exe</OutputType></PropertyGroup></Project>`)
+			writeProject("src/Web/Web.csproj", `<Project Sdk="Microsoft.NET.Sdk.Web"><PropertyGroup><TargetFramework>net8.0</TargetFramework></PropertyGroup></Project>`)
+		})
+
+		It("skips tests and libraries and prefers the Web SDK project", func() {
+			path, err := subject.MainPath()
+			Expect(err).To(BeNil())
+			Expect(path).To(Equal(filepath.Join(buildDir, "src", "Web", "Web.csproj")))
+			Expect(buffer.String()).To(ContainSubstring("Using src/Web/Web.csproj as the startup project, it uses Microsoft.NET.Sdk.Web"))
+		})
+
+		It("lists the test projects", func() {
+			paths, err := subject.TestProjects()
+			Expect(err).To(BeNil())
+			Expect(paths).To(ConsistOf(
+				filepath.Join(buildDir, "test", "Web.Tests", "Web.Tests.csproj"),
+				filepath.Join(buildDir, "test", "Integration", "Integration.csproj"),
+			))
+		})
+
+		Context("a project without OutputType is next to the app", func() {
+			BeforeEach(func() {
+				Expect(os.Remove(filepath.Join(buildDir, "src", "Web", "Web.csproj"))).To(Succeed())
+				writeProject("src/Shared/Shared.csproj", `<Project Sdk="Microsoft.NET.Sdk"><PropertyGroup><TargetFramework>net8.0</TargetFramework></PropertyGroup></Project>`)
+			})
+
+			It("skips it as a library", func() {
+				path, err := subject.MainPath()
+				Expect(err).To(BeNil())
+				Expect(path).To(Equal(filepath.Join(buildDir, "tools", "Migrator", "Migrator.csproj")))
+				Expect(buffer.String()).To(ContainSubstring("Using tools/Migrator/Migrator.csproj as the startup project, it has OutputType Exe"))
+			})
+		})
+
+		Context("two projects are equally likely", func() {
+			BeforeEach(func() {
+				writeProject("src/Worker/Worker.csproj", `<Project Sdk="Microsoft.NET.Sdk.Worker"></Project>`)
+			})
+
+			It("fails listing the candidates", func() {
+				_, err := subject.MainPath()
+				Expect(err).To(MatchError("could not determine the startup project, candidates are: src/Web/Web.csproj (uses Microsoft.NET.Sdk.Web), src/Worker/Worker.csproj (uses Microsoft.NET.Sdk.Worker); set project in buildpack.yml or add a .deployment file"))
+			})
 		})
 	})
 
@@ -609,6 +1265,24 @@ var _ = Describe("Project", func() {
 
 				Expect(subject.FDDInstallFrameworks()).To(Succeed())
 			})
+
+			It("records where each framework version came from", func() {
+				mockInstaller.
+					EXPECT().
+					InstallDependency(libbuildpack.Dependency{Name: "dotnet-aspnetcore", Version: "6.7.8"}, depsPath).
+					Do(func(d libbuildpack.Dependency, s string) {
+						installRuntimeConfig("Microsoft.AspNetCore.App", "6.7.8", "1.2.3")
+					})
+				mockInstaller.
+					EXPECT().
+					InstallDependency(libbuildpack.Dependency{Name: "dotnet-runtime", Version: "1.2.3"}, depsPath)
+
+				Expect(subject.FDDInstallFrameworks()).To(Succeed())
+				Expect(subject.Resolutions()).To(Equal([]config.Resolution{
+					{Name: "dotnet-aspnetcore", Constraint: "6.7.8", Source: "test.runtimeconfig.json", Steps: []string{"applyPatches is false, used as is"}, Version: "6.7.8"},
+					{Name: "dotnet-runtime", Constraint: "1.2.3", Source: "Microsoft.AspNetCore.App 6.7.8", Steps: []string{"applyPatches is false, used as is"}, Version: "1.2.3"},
+				}))
+			})
 		})
 
 		Context("when .runtimeconfig.json contains multiple frameworks", func() {
@@ -630,6 +1304,39 @@ var _ = Describe("Project", func() {
 				Expect(subject.FDDInstallFrameworks()).To(Succeed())
 			})
 		})
+
+		Context("when the app specifies a framework registered in shared_frameworks", func() {
+			BeforeEach(func() {
+				createRuntimeConfig("Contoso.Platform.App", "3.1.4")
+				subject.SharedFrameworks = append(append([]config.SharedFramework{}, config.DefaultSharedFrameworks...),
+					config.SharedFramework{Name: "Contoso.Platform.App", Dependency: "contoso-platform"})
+			})
+
+			It("installs its dependency and the frameworks it is built on", func() {
+				mockInstaller.
+					EXPECT().
+					InstallDependency(libbuildpack.Dependency{Name: "contoso-platform", Version: "3.1.4"}, depsPath).
+					Do(func(d libbuildpack.Dependency, s string) {
+						installRuntimeConfig("Contoso.Platform.App", "3.1.4", "1.2.3")
+					})
+				mockInstaller.
+					EXPECT().
+					InstallDependency(libbuildpack.Dependency{Name: "dotnet-runtime", Version: "1.2.3"}, depsPath)
+
+				Expect(subject.FDDInstallFrameworks()).To(Succeed())
+			})
+		})
+
+		Context("when the app specifies a framework that is not registered", func() {
+			BeforeEach(func() {
+				createRuntimeConfig("Contoso.Platform.App", "3.1.4")
+			})
+
+			It("returns an error pointing at override.yml", func() {
+				err := subject.FDDInstallFrameworks()
+				Expect(err).To(MatchError("invalid framework 'Contoso.Platform.App' specified in test.runtimeconfig.json, add it to shared_frameworks in override.yml to install it"))
+			})
+		})
 	})
 
 	Describe("SourceInstallDotnetRuntime", func() {
@@ -660,6 +1367,203 @@ var _ = Describe("Project", func() {
 
 				Expect(subject.SourceInstallDotnetRuntime()).To(Succeed())
 			})
+
+			It("records the roll-forward steps that picked the runtime", func() {
+				mockManifest.
+					EXPECT().
+					AllDependencyVersions("dotnet-runtime").Return([]string{"5.1.0", "5.2.3"})
+				mockInstaller.
+					EXPECT().
+					InstallDependency(gomock.Any(), depsPath).
+					Times(2)
+
+				Expect(subject.SourceInstallDotnetRuntime()).To(Succeed())
+				Expect(subject.Resolutions()).To(Equal([]config.Resolution{
+					{Name: "dotnet-aspnetcore", Constraint: "5.2.3", Source: "dotnet-runtime", Steps: []string{"same version as dotnet-runtime"}, Version: "5.2.3"},
+					{Name: "dotnet-runtime", Constraint: "net5.0", Source: "foo.csproj", Steps: []string{"5.0.x: no match", "5.x.x: 5.2.3"}, Version: "5.2.3"},
+				}))
+			})
+		})
+
+		Context("when <TargetFramework> is set in Directory.Build.props", func() {
+			BeforeEach(func() {
+				Expect(os.MkdirAll(filepath.Join(buildDir, "src", "app"), 0755)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(buildDir, "Directory.Build.props"),
+					[]byte(`
+<Project>
+  <PropertyGroup>
+    <AppTargetFramework>net5.0</AppTargetFramework>
+  </PropertyGroup>
+</Project>`), 0644)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(buildDir, "src", "Directory.Build.props"),
+					[]byte(`
+<Project>
+  <Import Project="$([MSBuild]::GetPathOfFileAbove('Directory.Build.props', '$(MSBuildThisFileDirectory)../'))" />
+  <PropertyGroup>
+    <TargetFramework>$(AppTargetFramework)</TargetFramework>
+  </PropertyGroup>
+</Project>`), 0644)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(buildDir, "src", "app", "foo.csproj"),
+					[]byte(`
+<Project Sdk="Microsoft.NET.Sdk.Web">
+  <PropertyGroup>
+    <Nullable>enable</Nullable>
+  </PropertyGroup>
+</Project>`), 0644)).To(Succeed())
+			})
+
+			It("follows the Directory.Build.props chain to find it", func() {
+				mockManifest.
+					EXPECT().
+					AllDependencyVersions("dotnet-runtime").Return([]string{"4.5.6", "5.0.1", "5.0.2"})
+				mockInstaller.
+					EXPECT().
+					InstallDependency(libbuildpack.Dependency{Name: "dotnet-aspnetcore", Version: "5.0.2"}, depsPath)
+				mockInstaller.
+					EXPECT().
+					InstallDependency(libbuildpack.Dependency{Name: "dotnet-runtime", Version: "5.0.2"}, depsPath)
+
+				Expect(subject.SourceInstallDotnetRuntime()).To(Succeed())
+			})
+		})
+
+		Context("when <RuntimeFrameworkVersion> is set in a conditional PropertyGroup", func() {
+			BeforeEach(func() {
+				Expect(os.WriteFile(filepath.Join(buildDir, "foo.csproj"),
+					[]byte(`
+<Project Sdk="Microsoft.NET.Sdk.Web">
+  <PropertyGroup>
+    <TargetFramework>net5.0</TargetFramework>
+  </PropertyGroup>
+  <PropertyGroup Condition="'$(Configuration)' == 'Release'">
+    <RuntimeFrameworkVersion>6.7.8</RuntimeFrameworkVersion>
+  </PropertyGroup>
+  <PropertyGroup Condition="'$(Configuration)' != 'Release'">
+    <RuntimeFrameworkVersion>4.5.6</RuntimeFrameworkVersion>
+  </PropertyGroup>
+</Project>`), 0644)).To(Succeed())
+			})
+
+			It("evaluates the condition against the publish configuration", func() {
+				writeBuildpackYAML("dotnet-core:\n  configuration: Release\n")
+
+				mockInstaller.
+					EXPECT().
+					InstallDependency(libbuildpack.Dependency{Name: "dotnet-aspnetcore", Version: "6.7.8"}, depsPath)
+				mockInstaller.
+					EXPECT().
+					InstallDependency(libbuildpack.Dependency{Name: "dotnet-runtime", Version: "6.7.8"}, depsPath)
+
+				Expect(subject.SourceInstallDotnetRuntime()).To(Succeed())
+			})
+		})
+
+		Context("when <RuntimeFrameworkVersion> depends on defaults, the environment and quoted operators", func() {
+			BeforeEach(func() {
+				Expect(os.WriteFile(filepath.Join(buildDir, "foo.csproj"),
+					[]byte(`
+<Project Sdk="Microsoft.NET.Sdk.Web">
+  <PropertyGroup>
+    <TargetFramework>net5.0</TargetFramework>
+    <Flavor>a and b</Flavor>
+  </PropertyGroup>
+  <PropertyGroup Condition="'$(Flavor)' == 'a and b' and '$(Platform)' == 'AnyCPU' and ('$(RUNTIME_LINE)' == 'six' or Exists('missing.txt'))">
+    <RuntimeFrameworkVersion>6.7.8</RuntimeFrameworkVersion>
+  </PropertyGroup>
+  <PropertyGroup Condition="!('$(RUNTIME_LINE)' == 'six')">
+    <RuntimeFrameworkVersion>4.5.6</RuntimeFrameworkVersion>
+  </PropertyGroup>
+</Project>`), 0644)).To(Succeed())
+			})
+
+			It("evaluates the condition against the environment it is given", func() {
+				subject.Environ = []string{"RUNTIME_LINE=six"}
+
+				mockInstaller.
+					EXPECT().
+					InstallDependency(libbuildpack.Dependency{Name: "dotnet-aspnetcore", Version: "6.7.8"}, depsPath)
+				mockInstaller.
+					EXPECT().
+					InstallDependency(libbuildpack.Dependency{Name: "dotnet-runtime", Version: "6.7.8"}, depsPath)
+
+				Expect(subject.SourceInstallDotnetRuntime()).To(Succeed())
+			})
+
+			It("does not read the environment of the buildpack itself", func() {
+				Expect(os.Setenv("RUNTIME_LINE", "six")).To(Succeed())
+				defer os.Unsetenv("RUNTIME_LINE")
+
+				mockInstaller.
+					EXPECT().
+					InstallDependency(libbuildpack.Dependency{Name: "dotnet-aspnetcore", Version: "4.5.6"}, depsPath)
+				mockInstaller.
+					EXPECT().
+					InstallDependency(libbuildpack.Dependency{Name: "dotnet-runtime", Version: "4.5.6"}, depsPath)
+
+				Expect(subject.SourceInstallDotnetRuntime()).To(Succeed())
+			})
+		})
+
+		Context("when the project is multi-targeted with <TargetFrameworks>", func() {
+			BeforeEach(func() {
+				Expect(os.WriteFile(filepath.Join(buildDir, "foo.csproj"),
+					[]byte(`
+<Project Sdk="Microsoft.NET.Sdk.Web">
+  <PropertyGroup>
+    <TargetFrameworks>netstandard2.0;net5.0;net6.7;net7.0</TargetFrameworks>
+  </PropertyGroup>
+</Project>`), 0644)).To(Succeed())
+			})
+
+			It("installs the runtime of the highest framework the manifest can satisfy", func() {
+				mockManifest.
+					EXPECT().
+					AllDependencyVersions("dotnet-runtime").Return([]string{"5.0.2", "6.7.8", "6.7.9"}).
+					AnyTimes()
+				mockInstaller.
+					EXPECT().
+					InstallDependency(libbuildpack.Dependency{Name: "dotnet-aspnetcore", Version: "6.7.9"}, depsPath)
+				mockInstaller.
+					EXPECT().
+					InstallDependency(libbuildpack.Dependency{Name: "dotnet-runtime", Version: "6.7.9"}, depsPath)
+
+				Expect(subject.SourceInstallDotnetRuntime()).To(Succeed())
+				Expect(buffer.String()).To(ContainSubstring("Project targets netstandard2.0, net5.0, net6.7, net7.0, using net6.7"))
+
+				targetFramework, err := subject.PublishTargetFramework(filepath.Join(buildDir, "foo.csproj"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(targetFramework).To(Equal("net6.7"))
+			})
+
+			Context("buildpack.yml pins one of the target frameworks", func() {
+				BeforeEach(func() {
+					writeBuildpackYAML("dotnet-core:\n  target-framework: net5.0\n")
+				})
+
+				It("installs the runtime of the pinned framework", func() {
+					mockManifest.
+						EXPECT().
+						AllDependencyVersions("dotnet-runtime").Return([]string{"5.0.2", "6.7.8", "6.7.9"})
+					mockInstaller.
+						EXPECT().
+						InstallDependency(libbuildpack.Dependency{Name: "dotnet-aspnetcore", Version: "5.0.2"}, depsPath)
+					mockInstaller.
+						EXPECT().
+						InstallDependency(libbuildpack.Dependency{Name: "dotnet-runtime", Version: "5.0.2"}, depsPath)
+
+					Expect(subject.SourceInstallDotnetRuntime()).To(Succeed())
+				})
+			})
+
+			Context("buildpack.yml pins a framework the project does not target", func() {
+				BeforeEach(func() {
+					writeBuildpackYAML("dotnet-core:\n  target-framework: net9.0\n")
+				})
+
+				It("returns an error", func() {
+					Expect(subject.SourceInstallDotnetRuntime()).To(MatchError("target framework net9.0 in buildpack.yml is not one of the project's target frameworks (netstandard2.0, net5.0, net6.7, net7.0)"))
+				})
+			})
 		})
 
 		Context("when the runtime version is only specified under <TargetFramework> in the csproj", func() {
@@ -745,6 +1649,37 @@ var _ = Describe("Project", func() {
 				Expect(subject.SourceInstallDotnetRuntime()).To(Succeed())
 			})
 		})
+
+		Context("when buildpack.yml pins the runtime and ASP.NET Core versions", func() {
+			BeforeEach(func() {
+				Expect(os.WriteFile(filepath.Join(buildDir, "foo.csproj"),
+					[]byte(`
+<Project Sdk="Microsoft.NET.Sdk.Web">
+	<PropertyGroup>
+		<TargetFramework>net6.7</TargetFramework>
+	</PropertyGroup>
+</Project>`), 0644)).To(Succeed())
+				writeBuildpackYAML("dotnet-core:\n  runtime: 6.7.8\n  aspnetcore: 6.7.x\n")
+			})
+
+			It("installs the versions from buildpack.yml", func() {
+				mockManifest.
+					EXPECT().
+					AllDependencyVersions("dotnet-aspnetcore").Return([]string{"6.7.8", "6.7.9"})
+				mockManifest.
+					EXPECT().
+					AllDependencyVersions("dotnet-runtime").Return([]string{"6.7.8", "6.7.9"})
+				mockInstaller.
+					EXPECT().
+					InstallDependency(libbuildpack.Dependency{Name: "dotnet-aspnetcore", Version: "6.7.9"}, depsPath)
+				mockInstaller.
+					EXPECT().
+					InstallDependency(libbuildpack.Dependency{Name: "dotnet-runtime", Version: "6.7.8"}, depsPath)
+
+				Expect(subject.SourceInstallDotnetRuntime()).To(Succeed())
+				Expect(buffer.String()).To(ContainSubstring("Using dotnet-runtime 6.7.8 from buildpack.yml"))
+			})
+		})
 	})
 
 	Describe("UsesLibrary", func() {
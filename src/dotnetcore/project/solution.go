@@ -0,0 +1,134 @@
+package project
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// solutionProject is a project listed in a .sln or .slnx file
+type solutionProject struct {
+	Name string
+	Path string
+}
+
+var (
+	slnProjectRe  = regexp.MustCompile(`^Project\("\{[^}]+\}"\)\s*=\s*"([^"]*)"\s*,\s*"([^"]*)"`)
+	projectFileRe = regexp.MustCompile(`\.(cs|fs|vb)proj$`)
+)
+
+// solutionPath returns the .sln or .slnx file at the root of the app, or an
+// empty string when there is none. Several solution files are ambiguous and
+// are treated as none.
+func (p *Project) solutionPath() (string, error) {
+	var solutions []string
+	for _, pattern := range []string{"*.sln", "*.slnx"} {
+		matches, err := filepath.Glob(filepath.Join(p.buildDir, pattern))
+		if err != nil {
+			return "", err
+		}
+		solutions = append(solutions, matches...)
+	}
+
+	if len(solutions) != 1 {
+		return "", nil
+	}
+	return solutions[0], nil
+}
+
+// solutionProjects lists the .csproj, .fsproj and .vbproj files inside the
+// app that the solution at the root of the app builds. The boolean is false
+// when the app has no solution.
+func (p *Project) solutionProjects() ([]solutionProject, bool, error) {
+	solutionPath, err := p.solutionPath()
+	if err != nil || solutionPath == "" {
+		return nil, false, err
+	}
+
+	var projects []solutionProject
+	if filepath.Ext(solutionPath) == ".slnx" {
+		projects, err = parseSlnx(solutionPath)
+	} else {
+		projects, err = parseSln(solutionPath)
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("unable to read %s: %v", filepath.Base(solutionPath), err)
+	}
+
+	var existing []solutionProject
+	for _, project := range projects {
+		if !projectFileRe.MatchString(project.Path) {
+			continue
+		}
+
+		project.Path = filepath.Join(filepath.Dir(solutionPath), filepath.FromSlash(strings.ReplaceAll(project.Path, `\`, "/")))
+		if _, err := os.Stat(project.Path); err != nil {
+			if os.IsNotExist(err) {
+				p.Log.Debug("Project %s listed in %s does not exist", project.Path, filepath.Base(solutionPath))
+				continue
+			}
+			return nil, false, err
+		}
+		if inside, err := p.insideBuildDir(project.Path); err != nil {
+			return nil, false, err
+		} else if !inside {
+			p.Log.Warning("Ignoring project %s listed in %s, it is outside of the app", project.Path, filepath.Base(solutionPath))
+			continue
+		}
+		existing = append(existing, project)
+	}
+
+	return existing, true, nil
+}
+
+func parseSln(path string) ([]solutionProject, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var projects []solutionProject
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if matches := slnProjectRe.FindStringSubmatch(strings.TrimSpace(scanner.Text())); matches != nil {
+			projects = append(projects, solutionProject{Name: matches[1], Path: matches[2]})
+		}
+	}
+	return projects, scanner.Err()
+}
+
+func parseSlnx(path string) ([]solutionProject, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	root := msbuildNode{}
+	if err := xml.Unmarshal(content, &root); err != nil {
+		return nil, err
+	}
+
+	var projects []solutionProject
+	var walk func(node msbuildNode)
+	walk = func(node msbuildNode) {
+		for _, child := range node.Children {
+			if strings.EqualFold(child.XMLName.Local, "Project") && child.attr("Path") != "" {
+				projectPath := child.attr("Path")
+				name := filepath.Base(strings.ReplaceAll(projectPath, `\`, "/"))
+				projects = append(projects, solutionProject{
+					Name: strings.TrimSuffix(name, filepath.Ext(name)),
+					Path: projectPath,
+				})
+			}
+			walk(child)
+		}
+	}
+	walk(root)
+
+	return projects, nil
+}
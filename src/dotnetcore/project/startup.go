@@ -0,0 +1,140 @@
+package project
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// startupCandidate is a project file scored by how likely it is to be the
+// entry point of the app
+type startupCandidate struct {
+	path     string
+	score    int
+	reason   string
+	excluded bool
+}
+
+const (
+	// scoreUnknown is only given to projects that could not be evaluated
+	scoreUnknown = iota
+	scoreExecutable
+	scoreHostSdk
+)
+
+// selectStartupProject picks the entry point among several project files.
+// Test projects and libraries are skipped, and projects using the Web or
+// Worker SDKs are preferred over other executables. It fails, listing the
+// candidates, when that still leaves more than one.
+func (p *Project) selectStartupProject(paths []string) (string, error) {
+	if p.startupProject != "" {
+		return p.startupProject, nil
+	}
+
+	var candidates, skipped []startupCandidate
+	for _, path := range paths {
+		candidate := p.scoreStartupProject(path)
+		if candidate.excluded {
+			skipped = append(skipped, candidate)
+		} else {
+			candidates = append(candidates, candidate)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].score > candidates[j].score })
+
+	if len(candidates) == 1 || (len(candidates) > 1 && candidates[0].score > candidates[1].score && candidates[0].score > scoreUnknown) {
+		chosen := candidates[0]
+		p.Log.Info("Using %s as the startup project, it %s", p.relativePath(chosen.path), chosen.reason)
+		for _, s := range skipped {
+			p.Log.Debug("Skipped %s, it %s", p.relativePath(s.path), s.reason)
+		}
+		p.startupProject = chosen.path
+		return chosen.path, nil
+	}
+
+	var names []string
+	if len(candidates) == 0 {
+		candidates = skipped
+	}
+	for _, c := range candidates {
+		if c.score == candidates[0].score {
+			names = append(names, fmt.Sprintf("%s (%s)", p.relativePath(c.path), c.reason))
+		}
+	}
+
+	return "", fmt.Errorf("could not determine the startup project, candidates are: %s; set project in buildpack.yml or add a .deployment file", strings.Join(names, ", "))
+}
+
+func (p *Project) scoreStartupProject(path string) startupCandidate {
+	evaluation, err := p.evaluate(path)
+	if err != nil {
+		return startupCandidate{path: path, score: scoreUnknown, reason: "could not be evaluated"}
+	}
+
+	if isTest, reason := isTestProject(evaluation); isTest {
+		return startupCandidate{path: path, excluded: true, reason: reason}
+	}
+
+	outputType := evaluation.Property("OutputType")
+	if strings.EqualFold(outputType, "Library") {
+		return startupCandidate{path: path, excluded: true, reason: "has OutputType Library"}
+	}
+
+	sdk := strings.SplitN(evaluation.Sdk(), "/", 2)[0]
+	switch strings.ToLower(sdk) {
+	case "microsoft.net.sdk.web", "microsoft.net.sdk.worker":
+		return startupCandidate{path: path, score: scoreHostSdk, reason: "uses " + sdk}
+	}
+
+	switch strings.ToLower(outputType) {
+	case "exe", "winexe":
+		return startupCandidate{path: path, score: scoreExecutable, reason: "has OutputType " + outputType}
+	case "":
+		// The SDK defaults OutputType to Library
+		return startupCandidate{path: path, excluded: true, reason: "has no OutputType and so is a library"}
+	}
+
+	return startupCandidate{path: path, excluded: true, reason: "has OutputType " + outputType}
+}
+
+// TestProjects returns the test projects of the app, in the order of the
+// solution or of the project files.
+func (p *Project) TestProjects() ([]string, error) {
+	paths, err := p.appProjectPaths()
+	if err != nil {
+		return nil, err
+	}
+
+	var testProjects []string
+	for _, path := range paths {
+		evaluation, err := p.evaluate(path)
+		if err != nil {
+			return nil, err
+		}
+		if isTest, _ := isTestProject(evaluation); isTest {
+			testProjects = append(testProjects, path)
+		}
+	}
+	return testProjects, nil
+}
+
+func isTestProject(evaluation *msbuildEvaluator) (bool, string) {
+	if strings.EqualFold(evaluation.Property("IsTestProject"), "true") {
+		return true, "is a test project"
+	}
+
+	for _, reference := range evaluation.packageReferences {
+		if strings.EqualFold(reference.Include, "Microsoft.NET.Test.Sdk") {
+			return true, "references Microsoft.NET.Test.Sdk"
+		}
+	}
+	return false, ""
+}
+
+func (p *Project) relativePath(path string) string {
+	if relPath, err := filepath.Rel(p.buildDir, path); err == nil {
+		return relPath
+	}
+	return path
+}
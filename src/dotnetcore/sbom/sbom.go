@@ -0,0 +1,203 @@
+package sbom
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/config"
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// BOM is a CycloneDX 1.5 software bill of materials
+type BOM struct {
+	BOMFormat   string      `json:"bomFormat"`
+	SpecVersion string      `json:"specVersion"`
+	Version     int         `json:"version"`
+	Metadata    Metadata    `json:"metadata"`
+	Components  []Component `json:"components"`
+}
+
+type Metadata struct {
+	Tools []Tool `json:"tools"`
+}
+
+type Tool struct {
+	Vendor  string `json:"vendor"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type Component struct {
+	BOMRef             string              `json:"bom-ref"`
+	Type               string              `json:"type"`
+	Name               string              `json:"name"`
+	Version            string              `json:"version"`
+	PURL               string              `json:"purl"`
+	Hashes             []Hash              `json:"hashes,omitempty"`
+	ExternalReferences []ExternalReference `json:"externalReferences,omitempty"`
+}
+
+type Hash struct {
+	Algorithm string `json:"alg"`
+	Content   string `json:"content"`
+}
+
+type ExternalReference struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+type Manifest interface {
+	AllDependencyVersions(string) []string
+	GetEntry(libbuildpack.Dependency) (*libbuildpack.ManifestEntry, error)
+	Version() (string, error)
+}
+
+// library is an entry of the libraries section that deps.json and
+// project.assets.json share
+type library struct {
+	Type   string `json:"type"`
+	SHA512 string `json:"sha512"`
+}
+
+// New builds the SBOM of a droplet from the dependencies the buildpack
+// installed and the NuGet packages and runtime packs listed in the given
+// deps.json or project.assets.json files.
+func New(manifest Manifest, installed []config.Dependency, libraryFiles []string) (BOM, error) {
+	buildpackVersion, err := manifest.Version()
+	if err != nil {
+		return BOM{}, err
+	}
+
+	bom := BOM{
+		BOMFormat:   "CycloneDX",
+		SpecVersion: "1.5",
+		Version:     1,
+		Metadata: Metadata{
+			Tools: []Tool{{Vendor: "Cloud Foundry", Name: "dotnet-core-buildpack", Version: buildpackVersion}},
+		},
+		Components: []Component{},
+	}
+
+	for _, dep := range installed {
+		bom.Components = append(bom.Components, dependencyComponent(manifest, dep))
+	}
+
+	packages := map[string]Component{}
+	for _, path := range libraryFiles {
+		libraries := struct {
+			Libraries map[string]library `json:"libraries"`
+		}{}
+		if err := libbuildpack.NewJSON().Load(path, &libraries); err != nil {
+			return BOM{}, fmt.Errorf("unable to read %s: %v", path, err)
+		}
+
+		for key, lib := range libraries.Libraries {
+			if lib.Type != "package" && lib.Type != "runtimepack" {
+				continue
+			}
+
+			component := packageComponent(key, lib)
+			packages[component.PURL] = component
+		}
+	}
+
+	var nugetComponents []Component
+	for _, component := range packages {
+		nugetComponents = append(nugetComponents, component)
+	}
+	sort.Slice(nugetComponents, func(i, j int) bool {
+		if !strings.EqualFold(nugetComponents[i].Name, nugetComponents[j].Name) {
+			return strings.ToLower(nugetComponents[i].Name) < strings.ToLower(nugetComponents[j].Name)
+		}
+		return nugetComponents[i].Version < nugetComponents[j].Version
+	})
+	bom.Components = append(bom.Components, nugetComponents...)
+
+	return bom, nil
+}
+
+// JSON renders the SBOM as indented JSON
+func (b BOM) JSON() ([]byte, error) {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func dependencyComponent(manifest Manifest, dep config.Dependency) Component {
+	componentType := "application"
+	switch {
+	case strings.HasPrefix(dep.Name, "dotnet-"):
+		componentType = "framework"
+	case strings.HasPrefix(dep.Name, "lib"):
+		componentType = "library"
+	}
+
+	purl := fmt.Sprintf("pkg:generic/%s@%s", dep.Name, dep.Version)
+	component := Component{
+		BOMRef:  purl,
+		Type:    componentType,
+		Name:    dep.Name,
+		Version: dep.Version,
+		PURL:    purl,
+	}
+
+	if !contains(manifest.AllDependencyVersions(dep.Name), dep.Version) {
+		return component
+	}
+
+	if entry, err := manifest.GetEntry(libbuildpack.Dependency{Name: dep.Name, Version: dep.Version}); err == nil {
+		if entry.SHA256 != "" {
+			component.Hashes = []Hash{{Algorithm: "SHA-256", Content: entry.SHA256}}
+		}
+		if entry.URI != "" {
+			component.ExternalReferences = []ExternalReference{{Type: "distribution", URL: entry.URI}}
+		}
+	}
+	return component
+}
+
+// packageComponent turns a library into a component. The runtime packs that
+// a self-contained app carries instead of the shared runtime are listed as
+// frameworks under the name of their NuGet package.
+func packageComponent(key string, lib library) Component {
+	name, version := key, ""
+	if i := strings.LastIndex(key, "/"); i >= 0 {
+		name, version = key[:i], key[i+1:]
+	}
+
+	componentType := "library"
+	if lib.Type == "runtimepack" {
+		name = strings.TrimPrefix(name, "runtimepack.")
+		componentType = "framework"
+	}
+
+	purl := fmt.Sprintf("pkg:nuget/%s@%s", name, version)
+	component := Component{
+		BOMRef:  purl,
+		Type:    componentType,
+		Name:    name,
+		Version: version,
+		PURL:    purl,
+	}
+
+	if raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(lib.SHA512, "sha512-")); err == nil && len(raw) > 0 {
+		component.Hashes = []Hash{{Algorithm: "SHA-512", Content: hex.EncodeToString(raw)}}
+	}
+	return component
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,173 @@
+package sbom_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/config"
+	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/sbom"
+	"github.com/cloudfoundry/libbuildpack"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SBOM", func() {
+	var (
+		err          error
+		buildpackDir string
+		appDir       string
+		manifest     *libbuildpack.Manifest
+		installed    []config.Dependency
+	)
+
+	BeforeEach(func() {
+		buildpackDir, err = os.MkdirTemp("", "dotnet-core-buildpack.buildpack.")
+		Expect(err).To(BeNil())
+
+		appDir, err = os.MkdirTemp("", "dotnet-core-buildpack.app.")
+		Expect(err).To(BeNil())
+
+		Expect(os.Setenv("CF_STACK", "cflinuxfs4")).To(Succeed())
+
+		Expect(os.WriteFile(filepath.Join(buildpackDir, "VERSION"), []byte("2.4.1\n"), 0644)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(buildpackDir, "manifest.yml"), []byte(`---
+language: dotnet-core
+dependencies:
+- name: dotnet-sdk
+  version: 8.0.100
+  uri: https://buildpacks.example.com/dotnet-sdk_8.0.100.tgz
+  sha256: 0a1b2c
+  cf_stacks: [cflinuxfs4]
+- name: libunwind
+  version: 1.6.2
+  uri: https://buildpacks.example.com/libunwind_1.6.2.tgz
+  sha256: 3d4e5f
+  cf_stacks: [cflinuxfs4]
+`), 0644)).To(Succeed())
+
+		manifest, err = libbuildpack.NewManifest(buildpackDir, libbuildpack.NewLogger(&bytes.Buffer{}), time.Now())
+		Expect(err).To(BeNil())
+
+		installed = []config.Dependency{
+			{Name: "dotnet-sdk", Version: "8.0.100"},
+			{Name: "libunwind", Version: "1.6.2"},
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.Unsetenv("CF_STACK")).To(Succeed())
+		Expect(os.RemoveAll(buildpackDir)).To(Succeed())
+		Expect(os.RemoveAll(appDir)).To(Succeed())
+	})
+
+	It("lists the installed dependencies with their manifest checksums", func() {
+		bom, err := sbom.New(manifest, installed, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(bom.BOMFormat).To(Equal("CycloneDX"))
+		Expect(bom.SpecVersion).To(Equal("1.5"))
+		Expect(bom.Metadata.Tools).To(Equal([]sbom.Tool{{Vendor: "Cloud Foundry", Name: "dotnet-core-buildpack", Version: "2.4.1"}}))
+		Expect(bom.Components).To(Equal([]sbom.Component{
+			{
+				BOMRef:             "pkg:generic/dotnet-sdk@8.0.100",
+				Type:               "framework",
+				Name:               "dotnet-sdk",
+				Version:            "8.0.100",
+				PURL:               "pkg:generic/dotnet-sdk@8.0.100",
+				Hashes:             []sbom.Hash{{Algorithm: "SHA-256", Content: "0a1b2c"}},
+				ExternalReferences: []sbom.ExternalReference{{Type: "distribution", URL: "https://buildpacks.example.com/dotnet-sdk_8.0.100.tgz"}},
+			},
+			{
+				BOMRef:             "pkg:generic/libunwind@1.6.2",
+				Type:               "library",
+				Name:               "libunwind",
+				Version:            "1.6.2",
+				PURL:               "pkg:generic/libunwind@1.6.2",
+				Hashes:             []sbom.Hash{{Algorithm: "SHA-256", Content: "3d4e5f"}},
+				ExternalReferences: []sbom.ExternalReference{{Type: "distribution", URL: "https://buildpacks.example.com/libunwind_1.6.2.tgz"}},
+			},
+		}))
+	})
+
+	It("lists a dependency missing from the manifest without checksum", func() {
+		bom, err := sbom.New(manifest, []config.Dependency{{Name: "node", Version: "20.1.0"}}, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(bom.Components).To(Equal([]sbom.Component{
+			{BOMRef: "pkg:generic/node@20.1.0", Type: "application", Name: "node", Version: "20.1.0", PURL: "pkg:generic/node@20.1.0"},
+		}))
+	})
+
+	Context("the app has deps.json and project.assets.json files", func() {
+		var libraryFiles []string
+
+		BeforeEach(func() {
+			libraryFiles = []string{filepath.Join(appDir, "app.deps.json"), filepath.Join(appDir, "project.assets.json")}
+			Expect(os.WriteFile(libraryFiles[0], []byte(`{
+				"libraries": {
+					"app/1.0.0": {"type": "project", "serviceable": false, "sha512": ""},
+					"Serilog/3.1.1": {"type": "package", "serviceable": true, "sha512": "sha512-m3HSJL1i83hdltRq0+o9czGb+8KJDKra4t/3JRlnPKcjI8PZm6XBHXx6zG4UuMXaDEZjR1wuXDre9G9zvN7AQw=="},
+					"Dapper/2.1.24": {"type": "package", "serviceable": true, "sha512": ""}
+				}
+			}`), 0644)).To(Succeed())
+			Expect(os.WriteFile(libraryFiles[1], []byte(`{
+				"version": 3,
+				"libraries": {
+					"Serilog/3.1.1": {"type": "package", "sha512": "m3HSJL1i83hdltRq0+o9czGb+8KJDKra4t/3JRlnPKcjI8PZm6XBHXx6zG4UuMXaDEZjR1wuXDre9G9zvN7AQw=="}
+				}
+			}`), 0644)).To(Succeed())
+		})
+
+		It("appends every NuGet package once, sorted by name", func() {
+			bom, err := sbom.New(manifest, nil, libraryFiles)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(bom.Components).To(Equal([]sbom.Component{
+				{BOMRef: "pkg:nuget/Dapper@2.1.24", Type: "library", Name: "Dapper", Version: "2.1.24", PURL: "pkg:nuget/Dapper@2.1.24"},
+				{
+					BOMRef:  "pkg:nuget/Serilog@3.1.1",
+					Type:    "library",
+					Name:    "Serilog",
+					Version: "3.1.1",
+					PURL:    "pkg:nuget/Serilog@3.1.1",
+					Hashes:  []sbom.Hash{{Algorithm: "SHA-512", Content: "9b71d224bd62f3785d96d46ad3ea3d73319bfbc2890caadae2dff72519673ca72323c3d99ba5c11d7c7acc6e14b8c5da0c4663475c2e5c3adef46f73bcdec043"}},
+				},
+			}))
+		})
+	})
+
+	It("lists the runtime packs of a self-contained app as frameworks", func() {
+		depsJSON := filepath.Join(appDir, "app.deps.json")
+		Expect(os.WriteFile(depsJSON, []byte(`{
+			"libraries": {
+				"runtimepack.Microsoft.NETCore.App.Runtime.linux-x64/8.0.8": {"type": "runtimepack", "serviceable": false, "sha512": ""}
+			}
+		}`), 0644)).To(Succeed())
+
+		bom, err := sbom.New(manifest, nil, []string{depsJSON})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(bom.Components).To(Equal([]sbom.Component{
+			{
+				BOMRef:  "pkg:nuget/Microsoft.NETCore.App.Runtime.linux-x64@8.0.8",
+				Type:    "framework",
+				Name:    "Microsoft.NETCore.App.Runtime.linux-x64",
+				Version: "8.0.8",
+				PURL:    "pkg:nuget/Microsoft.NETCore.App.Runtime.linux-x64@8.0.8",
+			},
+		}))
+	})
+
+	It("renders the same JSON for the same input", func() {
+		first, err := sbom.New(manifest, installed, nil)
+		Expect(err).NotTo(HaveOccurred())
+		second, err := sbom.New(manifest, installed, nil)
+		Expect(err).NotTo(HaveOccurred())
+
+		firstJSON, err := first.JSON()
+		Expect(err).NotTo(HaveOccurred())
+		secondJSON, err := second.JSON()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(firstJSON).To(Equal(secondJSON))
+		Expect(string(firstJSON)).To(ContainSubstring(`"bomFormat": "CycloneDX"`))
+	})
+})
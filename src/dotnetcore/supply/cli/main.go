@@ -45,6 +45,20 @@ func main() {
 		os.Exit(17)
 	}
 
+	manifestExtensions, err := config.LoadManifestExtensions(buildpackDir, stager.DepsDir())
+	if err != nil {
+		logger.Error("Unable to load manifest.yml and override.yml extensions: %s", err.Error())
+		os.Exit(20)
+	}
+	arch := config.Architecture()
+	manifestExtensions.SelectArchitecture(manifest, arch)
+
+	stack, err := manifestExtensions.Stack(os.Getenv("CF_STACK"))
+	if err != nil {
+		logger.Error("%s", err.Error())
+		os.Exit(21)
+	}
+
 	err = libbuildpack.RunBeforeCompile(stager)
 	if err != nil {
 		logger.Error("Before Compile: %s", err.Error())
@@ -62,16 +76,39 @@ func main() {
 		os.Exit(14)
 	}
 
+	buildpackYAML, err := config.LoadBuildpackYAML(stager.BuildDir())
+	if err != nil {
+		logger.Error("Unable to parse buildpack.yml: %s", err.Error())
+		os.Exit(24)
+	}
+
 	cfg := &config.Config{}
+	recordingInstaller := config.NewInstaller(installer, manifest, cfg, arch)
+	recordingInstaller.EOL, err = config.NewEOLEnforcer(manifestExtensions.EOLPolicy, manifest, buildpackYAML, logger, time.Now())
+	if err != nil {
+		logger.Error("Unable to load the EOL policy: %s", err.Error())
+		os.Exit(22)
+	}
+	if recordingInstaller.Lock, err = config.LoadLock(stager.BuildDir(), stager.CacheDir(), buildpackYAML, logger); err != nil {
+		logger.Error("Unable to load %s: %s", config.LockFile, err.Error())
+		os.Exit(23)
+	}
+
+	dotnetProject := project.New(stager.BuildDir(), stager.DepDir(), stager.DepsIdx(), manifest, recordingInstaller, logger)
+	dotnetProject.BuildpackYAML = buildpackYAML
+	dotnetProject.Environ = os.Environ()
 
 	s := supply.Supplier{
 		Stager:    stager,
-		Installer: installer,
+		Installer: recordingInstaller,
 		Manifest:  manifest,
 		Log:       logger,
 		Command:   &libbuildpack.Command{},
 		Config:    cfg,
-		Project:   project.New(stager.BuildDir(), stager.DepDir(), stager.DepsIdx(), manifest, installer, logger),
+		Stack:     stack,
+		Project:   dotnetProject,
+
+		BuildpackYAML: buildpackYAML,
 	}
 
 	err = supply.Run(&s)
@@ -0,0 +1,174 @@
+package supply
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/blang/semver"
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+type globalJSON struct {
+	Sdk globalJSONSdk `json:"sdk"`
+}
+
+type globalJSONSdk struct {
+	Version         string `json:"version"`
+	RollForward     string `json:"rollForward"`
+	AllowPrerelease *bool  `json:"allowPrerelease"`
+}
+
+func (s *Supplier) globalJSON() (globalJSON, error) {
+	obj := globalJSON{}
+	if found, err := libbuildpack.FileExists(filepath.Join(s.Stager.BuildDir(), "global.json")); err != nil || !found {
+		return obj, err
+	}
+
+	if err := libbuildpack.NewJSON().Load(filepath.Join(s.Stager.BuildDir(), "global.json"), &obj); err != nil {
+		return obj, err
+	}
+	return obj, nil
+}
+
+// sdkVersion is a dotnet-sdk version split the way the SDK resolver looks at
+// it: 8.0.401 is major 8, minor 0, feature band 4 and patch 01.
+type sdkVersion struct {
+	raw    string
+	semver semver.Version
+}
+
+func (v sdkVersion) featureBand() uint64 {
+	return v.semver.Patch / 100
+}
+
+func (v sdkVersion) sameFeatureBand(o sdkVersion) bool {
+	return v.sameMinor(o) && v.featureBand() == o.featureBand()
+}
+
+func (v sdkVersion) sameMinor(o sdkVersion) bool {
+	return v.sameMajor(o) && v.semver.Minor == o.semver.Minor
+}
+
+func (v sdkVersion) sameMajor(o sdkVersion) bool {
+	return v.semver.Major == o.semver.Major
+}
+
+func (v sdkVersion) featureBandName() string {
+	return fmt.Sprintf("%d.%d.%dxx", v.semver.Major, v.semver.Minor, v.featureBand())
+}
+
+// resolveSdkVersion applies the rollForward and allowPrerelease policies of
+// global.json to the dotnet-sdk versions in the manifest, following the rules
+// of the .NET SDK resolver. Besides the chosen version it returns a short
+// description of the rule that picked it.
+func resolveSdkVersion(request globalJSONSdk, versions []string) (string, string, error) {
+	requested, err := semver.Parse(request.Version)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid sdk version '%s' in global.json: %v", request.Version, err)
+	}
+	want := sdkVersion{raw: request.Version, semver: requested}
+
+	policy := request.RollForward
+	if policy == "" {
+		policy = "latestPatch"
+	}
+
+	allowPrerelease := request.AllowPrerelease == nil || *request.AllowPrerelease
+
+	var candidates []sdkVersion
+	for _, raw := range versions {
+		parsed, err := semver.Parse(raw)
+		if err != nil {
+			continue
+		}
+		if len(parsed.Pre) > 0 && !allowPrerelease && raw != request.Version {
+			continue
+		}
+		if parsed.LT(requested) {
+			continue
+		}
+		candidates = append(candidates, sdkVersion{raw: raw, semver: parsed})
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].semver.LT(candidates[j].semver) })
+
+	exact := func() (string, string, bool) {
+		for _, c := range candidates {
+			if c.raw == request.Version {
+				return c.raw, "exact match", true
+			}
+		}
+		return "", "", false
+	}
+
+	latestIn := func(inScope func(sdkVersion) bool, rule string) (string, string, bool) {
+		for i := len(candidates) - 1; i >= 0; i-- {
+			if inScope(candidates[i]) {
+				return candidates[i].raw, fmt.Sprintf(rule, candidates[i].featureBandName()), true
+			}
+		}
+		return "", "", false
+	}
+
+	// nextFeatureBand picks the latest patch of the lowest feature band above
+	// the requested one that is still in scope.
+	nextFeatureBand := func(inScope func(sdkVersion) bool, rule string) (string, string, bool) {
+		for _, c := range candidates {
+			if inScope(c) && !c.sameFeatureBand(want) {
+				return latestIn(c.sameFeatureBand, rule)
+			}
+		}
+		return "", "", false
+	}
+
+	var rules []func() (string, string, bool)
+	patchRule := func() (string, string, bool) {
+		return latestIn(want.sameFeatureBand, "latest patch in feature band %s")
+	}
+	featureRule := func() (string, string, bool) {
+		return nextFeatureBand(want.sameMinor, "rolled forward to feature band %s")
+	}
+	minorRule := func() (string, string, bool) {
+		return nextFeatureBand(want.sameMajor, "rolled forward to minor version and feature band %s")
+	}
+	majorRule := func() (string, string, bool) {
+		return nextFeatureBand(func(sdkVersion) bool { return true }, "rolled forward to major version and feature band %s")
+	}
+
+	switch policy {
+	case "disable":
+		rules = append(rules, exact)
+	case "patch":
+		rules = append(rules, exact, patchRule)
+	case "feature":
+		rules = append(rules, patchRule, featureRule)
+	case "minor":
+		rules = append(rules, patchRule, featureRule, minorRule)
+	case "major":
+		rules = append(rules, patchRule, featureRule, minorRule, majorRule)
+	case "latestPatch":
+		rules = append(rules, patchRule)
+	case "latestFeature":
+		rules = append(rules, func() (string, string, bool) {
+			return latestIn(want.sameMinor, "latest feature band and patch, %s")
+		})
+	case "latestMinor":
+		rules = append(rules, func() (string, string, bool) {
+			return latestIn(want.sameMajor, "latest minor version, feature band and patch, %s")
+		})
+	case "latestMajor":
+		rules = append(rules, func() (string, string, bool) {
+			return latestIn(func(sdkVersion) bool { return true }, "latest available version, %s")
+		})
+	default:
+		return "", "", fmt.Errorf("invalid rollForward value '%s' in global.json", request.RollForward)
+	}
+
+	for _, rule := range rules {
+		if version, reason, found := rule(); found {
+			return version, fmt.Sprintf("rollForward %s, %s", policy, reason), nil
+		}
+	}
+
+	return "", "", fmt.Errorf("could not find an sdk matching '%s' with rollForward '%s'", request.Version, policy)
+}
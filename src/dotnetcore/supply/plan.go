@@ -0,0 +1,71 @@
+package supply
+
+import (
+	"fmt"
+
+	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/config"
+	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/plan"
+)
+
+// Plan records the dependencies supply would install, resolved the same way
+// Run resolves them but without installing anything
+func (s *Supplier) Plan(p *plan.Plan) error {
+	libunwind, err := s.onlyVersion("libunwind")
+	if err != nil {
+		return err
+	}
+	p.Dependencies = append(p.Dependencies, libunwind)
+
+	if p.Libgdiplus, err = s.Project.UsesLibrary("System.Drawing.Common"); err != nil {
+		return err
+	} else if p.Libgdiplus {
+		libgdiplus, err := s.onlyVersion("libgdiplus")
+		if err != nil {
+			return err
+		}
+		p.Dependencies = append(p.Dependencies, libgdiplus)
+	}
+
+	sdk, err := s.pickVersionToInstall()
+	if err != nil {
+		return err
+	}
+	p.Dependencies = append(p.Dependencies, sdk)
+
+	if p.Node, err = s.shouldInstallNode(); err != nil {
+		return err
+	} else if p.Node {
+		node, err := s.nodeResolution()
+		if err != nil {
+			return err
+		}
+		p.Dependencies = append(p.Dependencies, node)
+		p.Assumptions = append(p.Assumptions, pathAssumption("node"))
+	}
+
+	if p.Bower, err = s.shouldInstallBower(); err != nil {
+		return err
+	} else if p.Bower {
+		bower, err := s.bowerResolution()
+		if err != nil {
+			return err
+		}
+		p.Dependencies = append(p.Dependencies, bower)
+		p.Assumptions = append(p.Assumptions, pathAssumption("bower"))
+	}
+	return nil
+}
+
+// pathAssumption notes that a tool is planned without running it: staging
+// skips installing it when an earlier buildpack already put it on the PATH
+func pathAssumption(tool string) string {
+	return fmt.Sprintf("%[1]s is not on the PATH; staging does not install %[1]s when an earlier buildpack supplies it", tool)
+}
+
+func (s *Supplier) onlyVersion(name string) (config.Resolution, error) {
+	versions := s.Manifest.AllDependencyVersions(name)
+	if len(versions) != 1 {
+		return config.Resolution{}, fmt.Errorf("expected the manifest to have exactly one version of %s, it has %d", name, len(versions))
+	}
+	return config.Resolution{Name: name, Source: "manifest", Steps: []string{"only version"}, Version: versions[0]}, nil
+}
@@ -12,6 +12,7 @@ import (
 	"strings"
 
 	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/config"
+	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/nuget"
 	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/project"
 
 	"github.com/cloudfoundry/libbuildpack"
@@ -53,6 +54,9 @@ type Supplier struct {
 	Command   Command
 	Config    *config.Config
 	Project   *project.Project
+	Stack     config.Stack
+
+	BuildpackYAML config.BuildpackYAML
 }
 
 func Run(s *Supplier) error {
@@ -90,6 +94,11 @@ func Run(s *Supplier) error {
 		return err
 	}
 
+	if err := s.ConfigureNugetFeeds(); err != nil {
+		s.Log.Error("Unable to configure NuGet feeds from bound services: %s", err.Error())
+		return err
+	}
+
 	if err := s.LoadLegacySSLProvider(); err != nil {
 		s.Log.Error("Unable to load the requested legacy SSL provider: %s", err.Error())
 		return err
@@ -114,6 +123,8 @@ func Run(s *Supplier) error {
 		s.Log.Debug("BuildDir Checksum After Supply: %s", checksum)
 	}
 
+	s.LogResolutions()
+
 	if filesChanged, err := s.Command.Output(s.Stager.BuildDir(), "find", ".", "-newer", "/tmp/checkpoint", "-not", "-path", "./.cloudfoundry/*", "-not", "-path", "./.cloudfoundry"); err == nil && filesChanged != "" {
 		s.Log.Debug("Below files changed:")
 		s.Log.Debug(filesChanged)
@@ -122,6 +133,19 @@ func Run(s *Supplier) error {
 	return nil
 }
 
+// LogResolutions prints how the version of every dependency supply installed
+// was picked
+func (s *Supplier) LogResolutions() {
+	if len(s.Config.Resolutions) == 0 {
+		return
+	}
+
+	s.Log.BeginStep("Dependency versions")
+	for _, line := range config.ResolutionTable(s.Config.Resolutions) {
+		s.Log.Info("%s", line)
+	}
+}
+
 func (s *Supplier) InstallLibunwind() error {
 	if err := s.Installer.InstallOnlyVersion("libunwind", filepath.Join(s.Stager.DepDir(), "libunwind")); err != nil {
 		return err
@@ -159,8 +183,11 @@ func (s *Supplier) shouldInstallBower() (bool, error) {
 }
 
 func (s *Supplier) bowerInstall() error {
-	versions := s.Manifest.AllDependencyVersions("bower")
-	dep := libbuildpack.Dependency{Name: "bower", Version: versions[0]}
+	bower, err := s.bowerResolution()
+	if err != nil {
+		return err
+	}
+	dep := libbuildpack.Dependency{Name: "bower", Version: bower.Version}
 
 	dir, err := os.MkdirTemp("", "dotnet-core_buildpack-bower")
 	if err != nil {
@@ -175,6 +202,7 @@ func (s *Supplier) bowerInstall() error {
 	if err := s.Command.Execute(s.Stager.BuildDir(), io.Discard, io.Discard, "npm", "install", "-g", filepath.Join(dir, "bower.tar.gz")); err != nil {
 		return err
 	}
+	s.Config.RecordResolution(bower)
 	return s.Stager.LinkDirectoryInDepDir(filepath.Join(s.Stager.DepDir(), "node", "bin"), "bin")
 }
 
@@ -198,33 +226,56 @@ func (s *Supplier) InstallNode() error {
 		return fmt.Errorf("Could not decide whether to install node: %v", err)
 	}
 	if shouldInstallNode {
-		version, err := libbuildpack.FindMatchingVersion("x", s.Manifest.AllDependencyVersions("node"))
+		node, err := s.nodeResolution()
 		if err != nil {
 			return err
 		}
 
 		dep := libbuildpack.Dependency{
 			Name:    "node",
-			Version: version,
+			Version: node.Version,
 		}
 
 		nodePath := filepath.Join(s.Stager.DepDir(), "node")
 		if err := s.Installer.InstallDependency(dep, nodePath); err != nil {
 			return err
 		}
+		s.Config.RecordResolution(node)
 
 		return s.Stager.LinkDirectoryInDepDir(filepath.Join(nodePath, "bin"), "bin")
 	}
 	return nil
 }
 
+// nodeResolution picks the latest node in the manifest
+func (s *Supplier) nodeResolution() (config.Resolution, error) {
+	version, err := libbuildpack.FindMatchingVersion("x", s.Manifest.AllDependencyVersions("node"))
+	if err != nil {
+		return config.Resolution{}, err
+	}
+	return config.Resolution{Name: "node", Constraint: "x", Source: "manifest", Steps: []string{"x: " + version}, Version: version}, nil
+}
+
+// bowerResolution picks the first bower in the manifest
+func (s *Supplier) bowerResolution() (config.Resolution, error) {
+	versions := s.Manifest.AllDependencyVersions("bower")
+	if len(versions) == 0 {
+		return config.Resolution{}, fmt.Errorf("the manifest has no version of bower")
+	}
+	return config.Resolution{Name: "bower", Source: "manifest", Steps: []string{"first version"}, Version: versions[0]}, nil
+}
+
 func (s *Supplier) shouldInstallNode() (bool, error) {
 	err := s.Command.Execute(s.Stager.BuildDir(), io.Discard, io.Discard, "node", "-v")
 	if err == nil {
 		return false, nil
 	}
 
-	if os.Getenv("INSTALL_NODE") != "" {
+	if s.BuildpackYAML.KeepNode != nil {
+		if *s.BuildpackYAML.KeepNode {
+			return true, nil
+		}
+	} else if os.Getenv("INSTALL_NODE") != "" {
 		return true, nil
 	}
 
@@ -290,109 +341,54 @@ func (s *Supplier) commandsInProjFiles(commands []string) (bool, error) {
 
 }
 
-// Turn a semver string into major.minor.x
-// Will turn a.b.c into a.b.x
-// Will not modify strings that don't match a.b.c
-func sdkRollForward(version string, versions []string) (string, error) {
-	// Filter versions that match the major.minor version
-	versions = filterVersions(versions, version)
-
-	var featureLine string
-	var highestPatch string
-	parts := strings.SplitN(version, ".", 3)
-	if len(parts) == 3 {
-		featureLine = parts[2][:1]
-	}
-
-	for _, v := range versions {
-		versionSplit := strings.SplitN(v, ".", 3)
-		if len(versionSplit) == 3 && versionSplit[2][:1] == featureLine {
-			if highestPatch == "" {
-				highestPatch = versionSplit[2][1:]
-			} else {
-				current, err := strconv.Atoi(highestPatch)
-				comp, err := strconv.Atoi(versionSplit[2][1:])
-				if err != nil {
-					return "", err
-				}
-				if current < comp {
-					highestPatch = versionSplit[2][1:]
-				}
-			}
-		}
-	}
-
-	if highestPatch == "" {
-		return "", fmt.Errorf("could not find sdk in same feature line as '%s'", version)
-	}
-
-	return fmt.Sprintf("%s.%s.%s%s", parts[0], parts[1], featureLine, highestPatch), nil
-}
-
-func filterVersions(versions []string, version string) []string {
-	var filtered []string
-	semver := strings.SplitN(version, ".", 3)
-	major, minor := semver[0], semver[1]
-	for _, v := range versions {
-		if strings.HasPrefix(v, major+"."+minor) {
-			filtered = append(filtered, v)
-		}
-	}
-	return filtered
-}
-
-func (s *Supplier) pickVersionToInstall() (string, error) {
+// pickVersionToInstall resolves the dotnet-sdk version from buildpack.yml,
+// global.json or the manifest default, in that order
+func (s *Supplier) pickVersionToInstall() (config.Resolution, error) {
 	allVersions := s.Manifest.AllDependencyVersions("dotnet-sdk")
-	buildpackYamlVersion, err := s.parseBuildpackYamlVersion()
-	if err != nil {
-		return "", err
-	}
+	buildpackYamlVersion := s.BuildpackYAML.SDK
 
 	if buildpackYamlVersion != "" {
 		version, err := project.FindMatchingVersionWithPreview(buildpackYamlVersion, allVersions)
 		if err != nil {
 			s.Log.Warning("SDK %s in buildpack.yml is not available", buildpackYamlVersion)
-			return "", err
+			return config.Resolution{}, err
 		}
-		return version, err
+		return config.Resolution{Name: "dotnet-sdk", Constraint: buildpackYamlVersion, Source: "buildpack.yml", Steps: []string{fmt.Sprintf("%s: %s", buildpackYamlVersion, version)}, Version: version}, nil
 	}
 
-	globalJSONVersion, err := s.globalJsonSdkVersion()
+	globalJSON, err := s.globalJSON()
 	if err != nil {
-		return "", err
+		return config.Resolution{}, err
 	}
 
-	if globalJSONVersion != "" {
-		if contains(allVersions, globalJSONVersion) {
-			return globalJSONVersion, nil
-		}
-		s.Log.Warning("SDK %s in global.json is not available", globalJSONVersion)
-		installVersion, err := sdkRollForward(globalJSONVersion, allVersions)
-		if err == nil {
-			s.Log.Info("falling back to latest version in version line")
-			return installVersion, nil
+	if globalJSON.Sdk.Version != "" {
+		installVersion, rule, err := resolveSdkVersion(globalJSON.Sdk, allVersions)
+		if err != nil {
+			s.Log.Warning("SDK %s in global.json is not available", globalJSON.Sdk.Version)
+			return config.Resolution{}, err
 		}
-		return "", err
+		s.Log.Info("using SDK %s for %s in global.json (%s)", installVersion, globalJSON.Sdk.Version, rule)
+		return config.Resolution{Name: "dotnet-sdk", Constraint: globalJSON.Sdk.Version, Source: "global.json", Steps: []string{rule}, Version: installVersion}, nil
 	}
 
 	dep, err := s.Manifest.DefaultVersion("dotnet-sdk")
 	if err != nil {
-		return "", err
+		return config.Resolution{}, err
 	}
 	s.Log.Info("using the default SDK")
-	return dep.Version, nil
+	return config.Resolution{Name: "dotnet-sdk", Source: "manifest default", Version: dep.Version}, nil
 }
 
 func (s *Supplier) InstallDotnetSdk() error {
-	installVersion, err := s.pickVersionToInstall()
+	sdk, err := s.pickVersionToInstall()
 	if err != nil {
 		return err
 	}
-	s.Config.DotnetSdkVersion = installVersion
 
-	if err := s.Installer.InstallDependency(libbuildpack.Dependency{Name: "dotnet-sdk", Version: installVersion}, filepath.Join(s.Stager.DepDir(), "dotnet-sdk")); err != nil {
+	if err := s.Installer.InstallDependency(libbuildpack.Dependency{Name: "dotnet-sdk", Version: sdk.Version}, filepath.Join(s.Stager.DepDir(), "dotnet-sdk")); err != nil {
 		return err
 	}
+	s.Config.DotnetSdkVersion = s.Config.RecordResolution(sdk).Version
 
 	if err := s.Stager.AddBinDependencyLink(filepath.Join(s.Stager.DepDir(), "dotnet-sdk", "dotnet"), "dotnet"); err != nil {
 		return err
@@ -401,6 +397,32 @@ func (s *Supplier) InstallDotnetSdk() error {
 	return s.installRuntimeIfNeeded()
 }
 
+// ConfigureNugetFeeds adds the NuGet feeds of bound services as package
+// sources to the user-level NuGet.Config that dotnet publish picks up, since
+// finalize runs it with HOME set to the dep dir. Their credentials are only
+// handed to dotnet publish through the environment.
+func (s *Supplier) ConfigureNugetFeeds() error {
+	if isPublished, err := s.Project.IsPublished(); err != nil {
+		return err
+	} else if isPublished {
+		return nil
+	}
+
+	feeds, err := nuget.FeedsFromBindings()
+	if err != nil {
+		return err
+	} else if len(feeds) == 0 {
+		return nil
+	}
+
+	s.Log.BeginStep("Configuring NuGet feeds from bound services")
+	for _, feed := range feeds {
+		s.Log.Info("%s: %s", feed.Name, feed.RedactedURL())
+	}
+
+	return nuget.WriteConfig(filepath.Join(s.Stager.DepDir(), ".nuget", "NuGet", "NuGet.Config"), feeds)
+}
+
 // Users can load the legacy SSL provider via:
 // - the BP_OPENSSL_ACTIVATE_LEGACY_PROVIDER=true environment variable
 // - provide an openssl.cnf file in the application directory
@@ -416,8 +438,8 @@ func (s *Supplier) LoadLegacySSLProvider() error {
 	}
 
 	if loadLegacySSLProvider {
-		if os.Getenv("CF_STACK") == "cflinuxfs3" {
-			s.Log.Warning("Legacy SSL support requested, this feature is not available on cflinuxfs3")
+		if !s.Stack.LegacyOpenSSLProvider {
+			s.Log.Warning("Legacy SSL support requested, this feature is not available on %s", s.Stack.Name)
 			return nil
 		}
 		// If a user requests the legacy provider AND
@@ -466,31 +488,18 @@ func (s *Supplier) installRuntimeIfNeeded() error {
 			return err
 		}
 		name := "dotnet-runtime"
-		runtimeVersion, err := s.Project.FindMatchingFrameworkVersion(name, string(version), nil)
+		runtime, err := s.Project.ResolveFrameworkVersion(name, string(version), nil, "dotnet-sdk RuntimeVersion.txt")
 		if err != nil {
 			return err
 		}
-		return s.Installer.InstallDependency(libbuildpack.Dependency{Name: name, Version: runtimeVersion}, filepath.Join(s.Stager.DepDir(), "dotnet-sdk"))
+		if err := s.Installer.InstallDependency(libbuildpack.Dependency{Name: name, Version: runtime.Version}, filepath.Join(s.Stager.DepDir(), "dotnet-sdk")); err != nil {
+			return err
+		}
+		s.Config.RecordResolution(runtime)
 	}
 	return nil
 }
 
-func (s *Supplier) globalJsonSdkVersion() (string, error) {
-	if found, err := libbuildpack.FileExists(filepath.Join(s.Stager.BuildDir(), "global.json")); err != nil || !found {
-		return "", err
-	}
-
-	obj := struct {
-		Sdk struct {
-			Version string `json:"version"`
-		} `json:"sdk"`
-	}{}
-	if err := libbuildpack.NewJSON().Load(filepath.Join(s.Stager.BuildDir(), "global.json"), &obj); err != nil {
-		return "", err
-	}
-	return obj.Sdk.Version, nil
-}
-
 func (s *Supplier) CalcChecksum() (string, error) {
 	h := md5.New()
 	basepath := s.Stager.BuildDir()
@@ -521,39 +530,3 @@ func (s *Supplier) CalcChecksum() (string, error) {
 	}
 	return fmt.Sprintf("%x", h.Sum(nil)), nil
 }
-
-func contains(s []string, e string) bool {
-	for _, a := range s {
-		if a == e {
-			return true
-		}
-	}
-	return false
-}
-
-type buildpackYaml struct {
-	DotnetCore struct {
-		Version string `yaml:"sdk"`
-	} `yaml:"dotnet-core"`
-}
-
-func (s *Supplier) parseBuildpackYamlVersion() (string, error) {
-	content, err := s.parseBuildpackYamlFile()
-	if err != nil {
-		return "", err
-	}
-	return content.DotnetCore.Version, nil
-}
-
-func (s *Supplier) parseBuildpackYamlFile() (buildpackYaml, error) {
-	obj := buildpackYaml{}
-	if found, err := libbuildpack.FileExists(filepath.Join(s.Stager.BuildDir(), "buildpack.yml")); err != nil || !found {
-		return obj, err
-	}
-
-	if err := libbuildpack.NewYAML().Load(filepath.Join(s.Stager.BuildDir(), "buildpack.yml"), &obj); err != nil {
-		return obj, err
-	}
-
-	return obj, nil
-}
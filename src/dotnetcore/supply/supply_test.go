@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 
 	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/config"
+	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/plan"
 	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/project"
 	"github.com/cloudfoundry/dotnet-core-buildpack/src/dotnetcore/supply"
 
@@ -71,6 +72,7 @@ var _ = Describe("Supply", func() {
 			Command:   mockCommand,
 			Project:   project,
 			Config:    cfg,
+			Stack:     config.Stack{Name: "cflinuxfs4", RuntimeOS: "linux", LegacyOpenSSLProvider: true},
 		}
 
 		installNode = func(dep libbuildpack.Dependency, installDir string) {
@@ -82,6 +84,16 @@ var _ = Describe("Supply", func() {
 		}
 	})
 
+	// writeBuildpackYAML writes buildpack.yml and hands it to the supplier
+	// and its project parsed, the way the supply CLI does
+	writeBuildpackYAML := func(content string) {
+		Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte(content), 0644)).To(Succeed())
+		buildpackYAML, err := config.LoadBuildpackYAML(buildDir)
+		Expect(err).NotTo(HaveOccurred())
+		supplier.BuildpackYAML = buildpackYAML
+		supplier.Project.BuildpackYAML = buildpackYAML
+	}
+
 	AfterEach(func() {
 		mockCtrl.Finish()
 
@@ -180,6 +192,18 @@ var _ = Describe("Supply", func() {
 				})
 			})
 
+			Context("buildpack.yml asks to keep node", func() {
+				BeforeEach(func() {
+					writeBuildpackYAML("dotnet-core:\n  keep-node: true")
+				})
+
+				It("Installs node", func() {
+					mockManifest.EXPECT().AllDependencyVersions("node").Return([]string{"6.12.0"})
+					mockInstaller.EXPECT().InstallDependency(gomock.Any(), gomock.Any()).Do(installNode).Return(nil)
+					Expect(supplier.InstallNode()).To(Succeed())
+				})
+			})
+
 			Context("Not a published project and bower/npm commands necessary", func() {
 				BeforeEach(func() {
 					Expect(os.WriteFile(filepath.Join(buildDir, "test_app.csproj"), []byte(csprojXml), 0644)).To(Succeed())
@@ -216,6 +240,52 @@ var _ = Describe("Supply", func() {
 		})
 	})
 
+	Describe("ConfigureNugetFeeds", func() {
+		BeforeEach(func() {
+			Expect(os.Setenv("VCAP_SERVICES", `{"user-provided": [{"name": "private-feed", "tags": ["nuget"], "credentials": {"url": "https://nuget.example.com/v3/index.json", "username": "bob", "password": "secret"}}]}`)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			Expect(os.Unsetenv("VCAP_SERVICES")).To(Succeed())
+		})
+
+		It("writes the feeds without credentials to the user-level NuGet.Config", func() {
+			Expect(supplier.ConfigureNugetFeeds()).To(Succeed())
+
+			content, err := os.ReadFile(filepath.Join(depsDir, depsIdx, ".nuget", "NuGet", "NuGet.Config"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(ContainSubstring("https://nuget.example.com/v3/index.json"))
+			Expect(string(content)).NotTo(ContainSubstring("secret"))
+			Expect(buffer.String()).To(ContainSubstring("private-feed: https://nuget.example.com/v3/index.json"))
+			Expect(buffer.String()).NotTo(ContainSubstring("secret"))
+		})
+
+		Context("the app is already published", func() {
+			BeforeEach(func() {
+				Expect(os.WriteFile(filepath.Join(buildDir, "test_app.runtimeconfig.json"), []byte("any text"), 0644)).To(Succeed())
+			})
+
+			It("does not configure any feed", func() {
+				Expect(supplier.ConfigureNugetFeeds()).To(Succeed())
+				Expect(filepath.Join(depsDir, depsIdx, ".nuget")).NotTo(BeADirectory())
+			})
+		})
+
+		Context("two feeds map to the same package source", func() {
+			BeforeEach(func() {
+				Expect(os.Setenv("VCAP_SERVICES", `{"user-provided": [
+					{"name": "private-feed", "tags": ["nuget"], "credentials": {"url": "https://one.example.com/v3/index.json", "username": "bob", "password": "secret"}},
+					{"name": "private.feed", "tags": ["nuget"], "credentials": {"url": "https://two.example.com/v3/index.json", "username": "eve", "password": "other"}}
+				]}`)).To(Succeed())
+			})
+
+			It("fails without writing a NuGet.Config", func() {
+				Expect(supplier.ConfigureNugetFeeds()).To(MatchError("NuGet feeds private-feed and private.feed both map to package source cf_private_feed; rename one of the services"))
+				Expect(filepath.Join(depsDir, depsIdx, ".nuget")).NotTo(BeADirectory())
+			})
+		})
+	})
+
 	Describe("LoadLegacySSLProvider", func() {
 		Context("BP_OPENSSL_ACTIVATE_LEGACY_PROVIDER is set", func() {
 			Context("set to true", func() {
@@ -246,6 +316,21 @@ var _ = Describe("Supply", func() {
 				})
 			})
 
+			Context("the stack has no legacy provider", func() {
+				BeforeEach(func() {
+					Expect(os.Setenv("BP_OPENSSL_ACTIVATE_LEGACY_PROVIDER", "true")).To(Succeed())
+					supplier.Stack = config.Stack{Name: "cflinuxfs3", RuntimeOS: "linux"}
+				})
+				AfterEach(func() {
+					Expect(os.Unsetenv("BP_OPENSSL_ACTIVATE_LEGACY_PROVIDER")).To(Succeed())
+				})
+				It("warns and does not load it", func() {
+					Expect(supplier.LoadLegacySSLProvider()).To(Succeed())
+					Expect(filepath.Join(buildDir, "openssl.cnf")).NotTo(BeARegularFile())
+					Expect(buffer.String()).To(ContainSubstring("Legacy SSL support requested, this feature is not available on cflinuxfs3"))
+				})
+			})
+
 			Context("both environment variable and openssl.cnf are present", func() {
 				BeforeEach(func() {
 					Expect(os.Setenv("BP_OPENSSL_ACTIVATE_LEGACY_PROVIDER", "true")).To(Succeed())
@@ -287,7 +372,7 @@ var _ = Describe("Supply", func() {
 			Context("with exact sdk/version", func() {
 				Context("that is in the buildpack", func() {
 					BeforeEach(func() {
-						Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte("dotnet-core:\n  sdk: 6.7.8"), 0644)).To(Succeed())
+						writeBuildpackYAML("dotnet-core:\n  sdk: 6.7.8")
 						mockManifest.EXPECT().AllDependencyVersions("dotnet-sdk").Return([]string{"6.7.8"})
 					})
 
@@ -297,11 +382,25 @@ var _ = Describe("Supply", func() {
 
 						Expect(supplier.InstallDotnetSdk()).To(Succeed())
 					})
+
+					It("records and logs how the version was picked", func() {
+						mockInstaller.EXPECT().InstallDependency(gomock.Any(), gomock.Any())
+
+						Expect(supplier.InstallDotnetSdk()).To(Succeed())
+						Expect(supplier.Config.Resolutions).To(Equal([]config.Resolution{
+							{Name: "dotnet-sdk", Constraint: "6.7.8", Source: "buildpack.yml", Steps: []string{"6.7.8: 6.7.8"}, Version: "6.7.8"},
+						}))
+
+						supplier.LogResolutions()
+						Expect(buffer.String()).To(ContainSubstring("-----> Dependency versions\n" +
+							"       DEPENDENCY  VERSION  REQUESTED  SOURCE         ROLL-FORWARD\n" +
+							"       dotnet-sdk  6.7.8    6.7.8      buildpack.yml  6.7.8: 6.7.8\n"))
+					})
 				})
 
 				Context("that is not in the buildpack", func() {
 					BeforeEach(func() {
-						Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte("dotnet-core:\n  sdk: 1.2.3"), 0644)).To(Succeed())
+						writeBuildpackYAML("dotnet-core:\n  sdk: 1.2.3")
 						mockManifest.EXPECT().AllDependencyVersions("dotnet-sdk").Return([]string{"1.1.1", "1.2.2", "1.3.7"})
 					})
 
@@ -314,7 +413,7 @@ var _ = Describe("Supply", func() {
 			Context("with floating sdk/version line", func() {
 				Context("that is in the buildpack", func() {
 					BeforeEach(func() {
-						Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte("dotnet-core:\n  sdk: 6.7.x"), 0644)).To(Succeed())
+						writeBuildpackYAML("dotnet-core:\n  sdk: 6.7.x")
 						mockManifest.EXPECT().AllDependencyVersions("dotnet-sdk").Return([]string{"6.7.7", "6.7.8", "6.9.0"})
 					})
 
@@ -328,7 +427,7 @@ var _ = Describe("Supply", func() {
 
 				Context("that is in the buildpack", func() {
 					BeforeEach(func() {
-						Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte("dotnet-core:\n  sdk: 6.x.x"), 0644)).To(Succeed())
+						writeBuildpackYAML("dotnet-core:\n  sdk: 6.x.x")
 						mockManifest.EXPECT().AllDependencyVersions("dotnet-sdk").Return([]string{"6.7.7", "6.7.8", "7.0.0"})
 					})
 
@@ -342,7 +441,7 @@ var _ = Describe("Supply", func() {
 
 				Context("that is in the buildpack", func() {
 					BeforeEach(func() {
-						Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte("dotnet-core:\n  sdk: 6.x"), 0644)).To(Succeed())
+						writeBuildpackYAML("dotnet-core:\n  sdk: 6.x")
 						mockManifest.EXPECT().AllDependencyVersions("dotnet-sdk").Return([]string{"6.7.7", "6.7.8", "7.0.0"})
 					})
 
@@ -356,7 +455,7 @@ var _ = Describe("Supply", func() {
 
 				Context("that is not in the buildpack", func() {
 					BeforeEach(func() {
-						Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte("dotnet-core:\n  sdk: 1.2.x"), 0644)).To(Succeed())
+						writeBuildpackYAML("dotnet-core:\n  sdk: 1.2.x")
 						mockManifest.EXPECT().AllDependencyVersions("dotnet-sdk").Return([]string{"1.1.1", "1.3.7"})
 					})
 
@@ -417,11 +516,81 @@ var _ = Describe("Supply", func() {
 					})
 
 					It("returns an error", func() {
-						Expect(supplier.InstallDotnetSdk()).To(MatchError("could not find sdk in same feature line as '1.2.3'"))
+						Expect(supplier.InstallDotnetSdk()).To(MatchError("could not find an sdk matching '1.2.3' with rollForward 'latestPatch'"))
 					})
 				})
 			})
 
+			Context("with rollForward and allowPrerelease", func() {
+				versions := []string{"1.2.301", "1.2.305", "1.2.410", "1.2.503", "1.3.102", "1.3.206", "2.0.100", "2.1.100-preview.1.2"}
+
+				expectSdk := func(globalJSON, expected string) {
+					Expect(os.WriteFile(filepath.Join(buildDir, "global.json"), []byte(globalJSON), 0644)).To(Succeed())
+					mockManifest.EXPECT().AllDependencyVersions("dotnet-sdk").Return(versions)
+					mockInstaller.EXPECT().InstallDependency(libbuildpack.Dependency{Name: "dotnet-sdk", Version: expected}, filepath.Join(depsDir, depsIdx, "dotnet-sdk"))
+
+					Expect(supplier.InstallDotnetSdk()).To(Succeed())
+				}
+
+				It("defaults to the latest patch in the feature band", func() {
+					expectSdk(`{"sdk": {"version": "1.2.301"}}`, "1.2.305")
+					Expect(buffer.String()).To(ContainSubstring("using SDK 1.2.305 for 1.2.301 in global.json (rollForward latestPatch, latest patch in feature band 1.2.3xx)"))
+				})
+
+				It("uses the exact version for patch", func() {
+					expectSdk(`{"sdk": {"version": "1.2.301", "rollForward": "patch"}}`, "1.2.301")
+					Expect(buffer.String()).To(ContainSubstring("rollForward patch, exact match"))
+				})
+
+				It("rolls forward to the latest patch for patch", func() {
+					expectSdk(`{"sdk": {"version": "1.2.302", "rollForward": "patch"}}`, "1.2.305")
+				})
+
+				It("rolls forward to the next feature band for feature", func() {
+					expectSdk(`{"sdk": {"version": "1.2.306", "rollForward": "feature"}}`, "1.2.410")
+					Expect(buffer.String()).To(ContainSubstring("rollForward feature, rolled forward to feature band 1.2.4xx"))
+				})
+
+				It("rolls forward to the next minor version for minor", func() {
+					expectSdk(`{"sdk": {"version": "1.2.504", "rollForward": "minor"}}`, "1.3.102")
+				})
+
+				It("rolls forward to the next major version for major", func() {
+					expectSdk(`{"sdk": {"version": "1.3.207", "rollForward": "major", "allowPrerelease": false}}`, "2.0.100")
+				})
+
+				It("picks the highest feature band for latestFeature", func() {
+					expectSdk(`{"sdk": {"version": "1.2.100", "rollForward": "latestFeature"}}`, "1.2.503")
+					Expect(buffer.String()).To(ContainSubstring("rollForward latestFeature, latest feature band and patch, 1.2.5xx"))
+				})
+
+				It("picks the highest minor version for latestMinor", func() {
+					expectSdk(`{"sdk": {"version": "1.2.100", "rollForward": "latestMinor"}}`, "1.3.206")
+				})
+
+				It("picks the highest version including previews for latestMajor", func() {
+					expectSdk(`{"sdk": {"version": "1.2.100", "rollForward": "latestMajor"}}`, "2.1.100-preview.1.2")
+				})
+
+				It("skips previews when allowPrerelease is false", func() {
+					expectSdk(`{"sdk": {"version": "1.2.100", "rollForward": "latestMajor", "allowPrerelease": false}}`, "2.0.100")
+				})
+
+				It("requires an exact match for disable", func() {
+					Expect(os.WriteFile(filepath.Join(buildDir, "global.json"), []byte(`{"sdk": {"version": "1.2.302", "rollForward": "disable"}}`), 0644)).To(Succeed())
+					mockManifest.EXPECT().AllDependencyVersions("dotnet-sdk").Return(versions)
+
+					Expect(supplier.InstallDotnetSdk()).To(MatchError("could not find an sdk matching '1.2.302' with rollForward 'disable'"))
+				})
+
+				It("rejects unknown policies", func() {
+					Expect(os.WriteFile(filepath.Join(buildDir, "global.json"), []byte(`{"sdk": {"version": "1.2.301", "rollForward": "newest"}}`), 0644)).To(Succeed())
+					mockManifest.EXPECT().AllDependencyVersions("dotnet-sdk").Return(versions)
+
+					Expect(supplier.InstallDotnetSdk()).To(MatchError("invalid rollForward value 'newest' in global.json"))
+				})
+			})
+
 			Context("without sdk/version", func() {
 				BeforeEach(func() {
 					Expect(os.WriteFile(filepath.Join(buildDir, "global.json"), []byte(`{}`), 0644)).To(Succeed())
@@ -451,7 +620,7 @@ var _ = Describe("Supply", func() {
 
 		Context("with buildpack.yml and global.json", func() {
 			BeforeEach(func() {
-				Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte("dotnet-core:\n  sdk: 5.4.3"), 0644)).To(Succeed())
+				writeBuildpackYAML("dotnet-core:\n  sdk: 5.4.3")
 				Expect(os.WriteFile(filepath.Join(buildDir, "global.json"), []byte(`{"sdk": {"version": "6.7.8"}}`), 0644)).To(Succeed())
 				mockManifest.EXPECT().AllDependencyVersions("dotnet-sdk").Return([]string{"5.4.3", "6.7.8"})
 			})
@@ -476,7 +645,7 @@ var _ = Describe("Supply", func() {
 
 		Context("when runtimes were extracted completely", func() {
 			BeforeEach(func() {
-				Expect(os.WriteFile(filepath.Join(buildDir, "buildpack.yml"), []byte("dotnet-core:\n  sdk: 6.7.8"), 0644)).To(Succeed())
+				writeBuildpackYAML("dotnet-core:\n  sdk: 6.7.8")
 				mockManifest.EXPECT().AllDependencyVersions("dotnet-sdk").Return([]string{"6.7.8"})
 				mockManifest.EXPECT().AllDependencyVersions("dotnet-runtime").Return([]string{"3.1.4", "3.1.5"})
 				mockInstaller.EXPECT().InstallDependency(libbuildpack.Dependency{Name: "dotnet-sdk", Version: "6.7.8"}, gomock.Any()).
@@ -496,4 +665,50 @@ var _ = Describe("Supply", func() {
 			})
 		})
 	})
+
+	Describe("Plan", func() {
+		BeforeEach(func() {
+			csprojXml := `<Project Sdk="Microsoft.NET.Sdk.Web">
+						   <Target Name="PrepublishScript" BeforeTargets="PrepareForPublish">
+						     <Exec Command="npm install" />
+						   </Target>
+						   <ItemGroup>
+						     <PackageReference Include="System.Drawing.Common" Version="8.0.0" />
+						   </ItemGroup>
+						 </Project>`
+			Expect(os.WriteFile(filepath.Join(buildDir, "test_app.csproj"), []byte(csprojXml), 0644)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(buildDir, "global.json"), []byte(`{"sdk": {"version": "6.7.100"}}`), 0644)).To(Succeed())
+
+			mockManifest.EXPECT().AllDependencyVersions("libunwind").Return([]string{"1.2.3"})
+			mockManifest.EXPECT().AllDependencyVersions("libgdiplus").Return([]string{"4.5.6"})
+			mockManifest.EXPECT().AllDependencyVersions("dotnet-sdk").Return([]string{"6.7.101", "6.7.205"})
+			mockManifest.EXPECT().AllDependencyVersions("node").Return([]string{"6.12.0"})
+			mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "node", "-v").Return(fmt.Errorf("error"))
+			mockCommand.EXPECT().Execute(buildDir, gomock.Any(), gomock.Any(), "bower", "-v").Return(fmt.Errorf("error"))
+		})
+
+		It("resolves the dependencies without installing them", func() {
+			stagingPlan := plan.Plan{}
+			Expect(supplier.Plan(&stagingPlan)).To(Succeed())
+
+			Expect(stagingPlan.Libgdiplus).To(BeTrue())
+			Expect(stagingPlan.Node).To(BeTrue())
+			Expect(stagingPlan.Bower).To(BeFalse())
+			Expect(stagingPlan.Dependencies).To(Equal([]config.Resolution{
+				{Name: "libunwind", Source: "manifest", Steps: []string{"only version"}, Version: "1.2.3"},
+				{Name: "libgdiplus", Source: "manifest", Steps: []string{"only version"}, Version: "4.5.6"},
+				{Name: "dotnet-sdk", Constraint: "6.7.100", Source: "global.json", Steps: []string{"rollForward latestPatch, latest patch in feature band 6.7.1xx"}, Version: "6.7.101"},
+				{Name: "node", Constraint: "x", Source: "manifest", Steps: []string{"x: 6.12.0"}, Version: "6.12.0"},
+			}))
+		})
+
+		It("records that node is planned as if it were not on the PATH", func() {
+			stagingPlan := plan.Plan{}
+			Expect(supplier.Plan(&stagingPlan)).To(Succeed())
+
+			Expect(stagingPlan.Assumptions).To(Equal([]string{
+				"node is not on the PATH; staging does not install node when an earlier buildpack supplies it",
+			}))
+		})
+	})
 })